@@ -3,11 +3,16 @@ package paragliding
 import (
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // AdminMgr is the manager class fdor Admin
 type AdminMgr struct {
-	DB *Database
+	DB      *Database
+	Search  *SearchIndex
+	Boards  *LeaderboardMgr
+	Heatmap *HeatmapMgr
+	WHMgr   *WebHookMgr
 }
 
 // HandlerTrackCount is the handler for GET /admin/api/tracks_count.
@@ -23,13 +28,48 @@ func (aMgr *AdminMgr) HandlerTrackCount(w http.ResponseWriter, r *http.Request)
 }
 
 // HandlerDeleteAllTracks is the handler for DELETE /admin/api/tracks.
-//it deletes the tracks and responds of with the number of tracks deleted
+//it deletes the tracks and responds of with the number of tracks deleted. the webhooks get track.deleted, with at
+// most 100 tracks per event, and admin.tracks_purged
 func (aMgr *AdminMgr) HandlerDeleteAllTracks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "text/plain")
+	tracks, err := aMgr.DB.GetAllTracks()
+	if err != nil {
+		http.Error(w, "error getting tracks from database", http.StatusInternalServerError)
+		return
+	}
 	trackCount, err := aMgr.DB.DeleteAllTracks()
 	if err != nil {
 		http.Error(w, "error getting count from database", http.StatusInternalServerError)
 		return
 	}
+	aMgr.Search.Clear()
+	aMgr.Boards.Reset()
+	aMgr.Heatmap.Reset()
 	fmt.Fprint(w, trackCount)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, event := range trackDeletedEvents(tracks, now) {
+		aMgr.WHMgr.InvokeEvent(event)
+	}
+	aMgr.WHMgr.InvokeEvent(WebhookEvent{Type: EventTracksPurged, Timestamp: now, Tracks: []TrackSummary{}, Count: trackCount})
+}
+
+// the most tracks in a track.deleted event, the deleted tracks are split over as many events as needed
+const deletedEventTracks = 100
+
+// trackDeletedEvents returns the track.deleted events of the tracks
+func trackDeletedEvents(tracks []TrackInfo, now int64) []WebhookEvent {
+	var events []WebhookEvent
+	for start := 0; start < len(tracks); start += deletedEventTracks {
+		end := start + deletedEventTracks
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		summaries := []TrackSummary{}
+		for _, track := range tracks[start:end] {
+			summaries = append(summaries, newTrackSummary(track))
+		}
+		events = append(events, WebhookEvent{Type: EventTrackDeleted, Timestamp: now, Tracks: summaries})
+	}
+	return events
 }
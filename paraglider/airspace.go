@@ -0,0 +1,481 @@
+package paragliding
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	igc "github.com/marni/goigc"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// the references of airspace limits
+const (
+	AltitudeMSL = "MSL" // meters above mean sea level, compared with the gnss altitude
+	AltitudeAGL = "AGL" // meters above the ground, see Infringements
+	AltitudeFL  = "FL"  // pressure altitude in meters, compared with the pressure altitude
+)
+
+// AirspaceMgr is the manager for airspace. the airspace is kept in memory and loaded from the files in
+// the AIRSPACE_FILES environment variable and the files uploaded by the admin
+type AirspaceMgr struct {
+	DB    *Database
+	WHMgr *WebHookMgr
+
+	mutex     sync.RWMutex
+	airspaces []Airspace
+}
+
+// Airspace is an airspace from an openair file. arcs and circles are stored as polygons
+type Airspace struct {
+	Class   string        `json:"class"`
+	Name    string        `json:"name"`
+	Floor   AltitudeLimit `json:"floor"`
+	Ceiling AltitudeLimit `json:"ceiling"`
+	Polygon [][2]float64  `json:"-"` // lat, lon
+
+	minLat, maxLat, minLon, maxLon float64
+}
+
+// AltitudeLimit is the floor or ceiling of an airspace
+type AltitudeLimit struct {
+	Meters    float64 `json:"meters"`
+	Reference string  `json:"reference"` // AltitudeMSL, AltitudeAGL or AltitudeFL
+	Raw       string  `json:"raw"`       // as written in the file, eg. "FL95" or "1500ft AGL"
+}
+
+// Infringement is a part of a track that was inside an airspace
+type Infringement struct {
+	Airspace       string  `json:"airspace"`
+	Class          string  `json:"class"`
+	Floor          string  `json:"floor"`
+	Ceiling        string  `json:"ceiling"`
+	Entry          int64   `json:"entry"` // unix time in milliseconds
+	Exit           int64   `json:"exit"`
+	MaxPenetration float64 `json:"max_penetration"` // meters, the largest distance to the closest side, top or bottom
+}
+
+// AirspaceFile is an uploaded openair file as it is stored in the database
+type AirspaceFile struct {
+	ID       objectid.ObjectID `bson:"_id" json:"-"`
+	Content  string            `bson:"content" json:"-"`
+	Uploaded int64             `bson:"uploaded" json:"uploaded"` // unix time in milliseconds
+}
+
+// TrackAirspace is the response for GET /api/track/<id>/airspace
+type TrackAirspace struct {
+	TrackID       string         `json:"track_id"`
+	Infringements []Infringement `json:"infringements"`
+}
+
+// the step between the points of arcs and circles, in degrees
+const airspaceArcStep = 5.0
+
+// the altitude used for unlimited ceilings, in meters
+const unlimitedAltitude = 1000000
+
+// Load parses the files from the AIRSPACE_FILES environment variable (comma separated paths) and the uploaded files
+func (aMgr *AirspaceMgr) Load() error {
+	var airspaces []Airspace
+	for _, path := range strings.Split(os.Getenv("AIRSPACE_FILES"), ",") {
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return err
+		}
+		parsed, err := ParseOpenAirAirspace(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		airspaces = append(airspaces, parsed...)
+	}
+	files, err := aMgr.DB.GetAllAirspaceFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		parsed, err := ParseOpenAirAirspace(file.Content)
+		if err != nil {
+			return err
+		}
+		airspaces = append(airspaces, parsed...)
+	}
+	aMgr.mutex.Lock()
+	aMgr.airspaces = airspaces
+	aMgr.mutex.Unlock()
+	return nil
+}
+
+// HandlerImportAirspace is the handler for POST /admin/api/airspace. the body is an openair file,
+// its airspace is added to the airspace already loaded. it responds with the number of airspaces added
+func (aMgr *AirspaceMgr) HandlerImportAirspace(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read the body", http.StatusBadRequest)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	}
+	airspaces, err := ParseOpenAirAirspace(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file := AirspaceFile{ID: objectid.New(), Content: string(body), Uploaded: time.Now().UnixNano() / int64(time.Millisecond)}
+	if _, added := aMgr.DB.Insert("airspace_files", file); !added {
+		http.Error(w, "could not store the airspace file", http.StatusInternalServerError)
+		return
+	}
+	aMgr.mutex.Lock()
+	aMgr.airspaces = append(aMgr.airspaces, airspaces...)
+	aMgr.mutex.Unlock()
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Added int `json:"added"`
+	}{len(airspaces)})
+}
+
+// HandlerGetTrackAirspace is the handler for GET /api/track/<id>/airspace.
+// it responds with the infringements of the track against the airspace currently loaded
+func (aMgr *AirspaceMgr) HandlerGetTrackAirspace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	parts := strings.Split(r.URL.Path, "/")
+	track, found := aMgr.DB.GetTrackByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	points, err := aMgr.DB.GetPointsByTrackIDs([]string{track.ID.Hex()})
+	if err != nil {
+		http.Error(w, "could not get the points of the track", http.StatusInternalServerError)
+		return
+	}
+	result := TrackAirspace{TrackID: track.ID.Hex(), Infringements: []Infringement{}}
+	if len(points) > 0 {
+		result.Infringements = aMgr.CheckPoints(points[0].Points)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// CheckTrack checks a new track and alerts the airspace webhooks if it has infringements
+func (aMgr *AirspaceMgr) CheckTrack(track TrackInfo, points []TrackPoint) {
+	infringements := aMgr.CheckPoints(points)
+	if len(infringements) > 0 {
+		aMgr.WHMgr.InvokeAirspaceWebHooks(track, infringements)
+	}
+}
+
+// CheckPoints returns the infringements of the fixes against the airspace currently loaded
+func (aMgr *AirspaceMgr) CheckPoints(points []TrackPoint) []Infringement {
+	aMgr.mutex.RLock()
+	defer aMgr.mutex.RUnlock()
+	return Infringements(aMgr.airspaces, points)
+}
+
+// Infringements returns every part of the track that is inside an airspace. msl limits are compared with the gnss
+// altitude and flight levels with the pressure altitude. there is no terrain model, so agl limits use the altitude
+// of the first fix as the ground level
+func Infringements(airspaces []Airspace, points []TrackPoint) []Infringement {
+	infringements := []Infringement{}
+	if len(points) == 0 {
+		return infringements
+	}
+	ground := float64(trackAltitude(points[0]))
+	for _, airspace := range airspaces {
+		var current *Infringement
+		for _, p := range points {
+			depth, inside := airspace.penetration(p, ground)
+			if inside && current == nil {
+				current = &Infringement{Airspace: airspace.Name, Class: airspace.Class, Floor: airspace.Floor.Raw,
+					Ceiling: airspace.Ceiling.Raw, Entry: p.Time}
+			}
+			if inside {
+				current.Exit = p.Time
+				current.MaxPenetration = math.Max(current.MaxPenetration, depth)
+			} else if current != nil {
+				current.Exit = p.Time // the first fix outside
+				infringements = append(infringements, *current)
+				current = nil
+			}
+		}
+		if current != nil {
+			infringements = append(infringements, *current)
+		}
+	}
+	return infringements
+}
+
+// penetration returns how far inside the airspace the fix is, in meters, and whether it is inside at all
+func (airspace Airspace) penetration(p TrackPoint, ground float64) (float64, bool) {
+	if p.Lat < airspace.minLat || p.Lat > airspace.maxLat || p.Lon < airspace.minLon || p.Lon > airspace.maxLon {
+		return 0, false
+	}
+	// the floor and ceiling can have different references, eg. 1500ft AGL to FL95
+	floorAlt := airspace.Floor.altitude(p, ground)
+	ceilingAlt := airspace.Ceiling.altitude(p, ground)
+	if floorAlt <= airspace.Floor.Meters || ceilingAlt >= airspace.Ceiling.Meters || !pointInPolygon(p.Lat, p.Lon, airspace.Polygon) {
+		return 0, false
+	}
+	vertical := math.Min(floorAlt-airspace.Floor.Meters, airspace.Ceiling.Meters-ceilingAlt)
+	return math.Min(vertical, distanceToPolygon(p.Lat, p.Lon, airspace.Polygon)), true
+}
+
+// altitude returns the altitude of the fix measured the same way as the limit
+func (limit AltitudeLimit) altitude(p TrackPoint, ground float64) float64 {
+	switch limit.Reference {
+	case AltitudeFL:
+		return float64(p.PressureAltitude)
+	case AltitudeAGL:
+		return float64(trackAltitude(p)) - ground
+	}
+	return float64(trackAltitude(p))
+}
+
+// trackAltitude is the gnss altitude, or the pressure altitude for loggers without gnss altitude
+func trackAltitude(p TrackPoint) int64 {
+	if p.GNSSAltitude != 0 {
+		return p.GNSSAltitude
+	}
+	return p.PressureAltitude
+}
+
+// pointInPolygon uses ray casting, which is fine for the size of airspaces
+func pointInPolygon(lat float64, lon float64, polygon [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a[0] > lat) != (b[0] > lat) && lon < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// distanceToPolygon returns the distance in meters to the closest edge of the polygon
+func distanceToPolygon(lat float64, lon float64, polygon [][2]float64) float64 {
+	const rad = math.Pi / 180
+	// flat projection in meters around the point
+	project := func(p [2]float64) (float64, float64) {
+		return (p[1] - lon) * rad * math.Cos(lat*rad) * igc.EarthRadius * 1000, (p[0] - lat) * rad * igc.EarthRadius * 1000
+	}
+	best := math.Inf(1)
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		x1, y1 := project(polygon[j])
+		x2, y2 := project(polygon[i])
+		dx, dy := x2-x1, y2-y1
+		t := 0.0
+		if dx != 0 || dy != 0 {
+			t = math.Max(0, math.Min(1, -(x1*dx+y1*dy)/(dx*dx+dy*dy)))
+		}
+		best = math.Min(best, math.Hypot(x1+t*dx, y1+t*dy))
+	}
+	return best
+}
+
+// an openair altitude, eg. "FL95", "3500ft", "1500 ft AGL", "1000m MSL" or "GND"
+var openAirAltitudePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(FT|F|M)?\s*(MSL|AMSL|AGL|GND|SFC|ASFC)?$`)
+
+// ParseOpenAirAltitude parses the value of an AL or AH record. feet is the default unit
+func ParseOpenAirAltitude(s string) (AltitudeLimit, error) {
+	raw := strings.TrimSpace(s)
+	value := strings.ToUpper(raw)
+	switch value {
+	case "GND", "SFC", "0":
+		return AltitudeLimit{Meters: 0, Reference: AltitudeAGL, Raw: raw}, nil
+	case "UNL", "UNLIM", "UNLIMITED":
+		return AltitudeLimit{Meters: unlimitedAltitude, Reference: AltitudeMSL, Raw: raw}, nil
+	}
+	if strings.HasPrefix(value, "FL") {
+		fl, err := strconv.ParseFloat(strings.TrimSpace(value[2:]), 64)
+		if err != nil {
+			return AltitudeLimit{}, errors.New("invalid flight level: " + raw)
+		}
+		return AltitudeLimit{Meters: fl * 100 * 0.3048, Reference: AltitudeFL, Raw: raw}, nil
+	}
+	m := openAirAltitudePattern.FindStringSubmatch(value)
+	if m == nil {
+		return AltitudeLimit{}, errors.New("invalid altitude: " + raw)
+	}
+	limit := AltitudeLimit{Reference: AltitudeMSL, Raw: raw}
+	limit.Meters, _ = strconv.ParseFloat(m[1], 64)
+	if m[2] != "M" {
+		limit.Meters *= 0.3048
+	}
+	if m[3] == "AGL" || m[3] == "GND" || m[3] == "SFC" || m[3] == "ASFC" {
+		limit.Reference = AltitudeAGL
+	}
+	return limit, nil
+}
+
+// ParseOpenAirAirspace parses an openair file. it supports the records AC, AN, AL, AH, DP, V X=, V D=, DA, DB and DC.
+// the other records, like labels and styles, are ignored
+func ParseOpenAirAirspace(content string) ([]Airspace, error) {
+	var airspaces []Airspace
+	var airspace *Airspace
+	centerLat, centerLon, clockwise := 0.0, 0.0, true
+	finish := func(line int) error {
+		if airspace == nil {
+			return nil
+		}
+		if len(airspace.Polygon) < 3 {
+			return fmt.Errorf("line %d: airspace %s has no area", line, airspace.Name)
+		}
+		airspace.minLat, airspace.maxLat, airspace.minLon, airspace.maxLon = 90, -90, 180, -180
+		for _, p := range airspace.Polygon {
+			airspace.minLat, airspace.maxLat = math.Min(airspace.minLat, p[0]), math.Max(airspace.maxLat, p[0])
+			airspace.minLon, airspace.maxLon = math.Min(airspace.minLon, p[1]), math.Max(airspace.maxLon, p[1])
+		}
+		airspaces = append(airspaces, *airspace)
+		airspace = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "*") {
+			continue
+		}
+		record, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			record, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		record = strings.ToUpper(record)
+		if record == "AC" {
+			if err := finish(lineNr); err != nil {
+				return nil, err
+			}
+			airspace = &Airspace{Class: value, Ceiling: AltitudeLimit{Meters: unlimitedAltitude, Reference: AltitudeMSL, Raw: "UNL"}}
+			centerLat, centerLon, clockwise = 0, 0, true
+			continue
+		}
+		if airspace == nil {
+			// records before the first AC, eg. a header without comment marks
+			continue
+		}
+		var err error
+		switch record {
+		case "AN":
+			airspace.Name = value
+		case "AL":
+			airspace.Floor, err = ParseOpenAirAltitude(value)
+		case "AH":
+			airspace.Ceiling, err = ParseOpenAirAltitude(value)
+		case "DP":
+			var lat, lon float64
+			if lat, lon, err = ParseOpenAirCoord(value); err == nil {
+				airspace.Polygon = append(airspace.Polygon, [2]float64{lat, lon})
+			}
+		case "V":
+			upper := strings.ToUpper(strings.Replace(value, " ", "", -1))
+			switch {
+			case strings.HasPrefix(upper, "X="):
+				centerLat, centerLon, err = ParseOpenAirCoord(strings.TrimSpace(value)[2:])
+			case strings.HasPrefix(upper, "D="):
+				clockwise = upper[2:] != "-"
+			}
+		case "DC":
+			var nm float64
+			if nm, err = strconv.ParseFloat(value, 64); err == nil {
+				airspace.Polygon = append(airspace.Polygon, arcPoints(centerLat, centerLon, nm*1852, 0, 360, true)...)
+			}
+		case "DA":
+			fields := strings.Split(value, ",")
+			if len(fields) != 3 {
+				err = errors.New("DA needs radius, start and end angle")
+				break
+			}
+			var values [3]float64
+			for i, f := range fields {
+				if values[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				airspace.Polygon = append(airspace.Polygon, arcPoints(centerLat, centerLon, values[0]*1852, values[1], values[2], clockwise)...)
+			}
+		case "DB":
+			coords := strings.Split(value, ",")
+			if len(coords) != 2 {
+				err = errors.New("DB needs two coordinates")
+				break
+			}
+			var lat1, lon1, lat2, lon2 float64
+			if lat1, lon1, err = ParseOpenAirCoord(coords[0]); err != nil {
+				break
+			}
+			if lat2, lon2, err = ParseOpenAirCoord(coords[1]); err != nil {
+				break
+			}
+			radius := DistanceKm(centerLat, centerLon, lat1, lon1) * 1000
+			points := arcPoints(centerLat, centerLon, radius, bearing(centerLat, centerLon, lat1, lon1),
+				bearing(centerLat, centerLon, lat2, lon2), clockwise)
+			// the arc ends exactly on the given coordinates
+			points[0], points[len(points)-1] = [2]float64{lat1, lon1}, [2]float64{lat2, lon2}
+			airspace.Polygon = append(airspace.Polygon, points...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNr, err)
+		}
+	}
+	if err := finish(lineNr); err != nil {
+		return nil, err
+	}
+	return airspaces, nil
+}
+
+// arcPoints returns the points of an arc around the center from the start to the end bearing (degrees from north)
+func arcPoints(lat float64, lon float64, radius float64, start float64, end float64, clockwise bool) [][2]float64 {
+	sweep := math.Mod(end-start+720, 360)
+	if !clockwise {
+		sweep = math.Mod(start-end+720, 360)
+	}
+	if sweep == 0 {
+		sweep = 360
+	}
+	steps := int(math.Ceil(sweep / airspaceArcStep))
+	var points [][2]float64
+	for i := 0; i <= steps; i++ {
+		angle := start + sweep*float64(i)/float64(steps)
+		if !clockwise {
+			angle = start - sweep*float64(i)/float64(steps)
+		}
+		points = append(points, destination(lat, lon, radius, angle))
+	}
+	return points
+}
+
+// destination returns the point the distance (meters) away from the start in the direction of the bearing
+func destination(lat float64, lon float64, distance float64, bearingDeg float64) [2]float64 {
+	const rad = math.Pi / 180
+	d := distance / (igc.EarthRadius * 1000)
+	lat1, lon1, b := lat*rad, lon*rad, bearingDeg*rad
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lon2 := lon1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return [2]float64{lat2 / rad, lon2 / rad}
+}
+
+// bearing returns the initial bearing from the first to the second point in degrees from north
+func bearing(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLon := (lon2 - lon1) * rad
+	y := math.Sin(dLon) * math.Cos(lat2*rad)
+	x := math.Cos(lat1*rad)*math.Sin(lat2*rad) - math.Sin(lat1*rad)*math.Cos(lat2*rad)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)/rad+360, 360)
+}
@@ -0,0 +1,92 @@
+package paragliding
+
+import (
+	"math"
+	"testing"
+)
+
+const testOpenAir = `* test airspace
+AC D
+AN BERGEN CTR
+AL GND
+AH 3500ft
+DP 60:00:00 N 005:00:00 E
+DP 60:00:00 N 005:30:00 E
+DP 60:10:00 N 005:30:00 E
+DP 60:10:00 N 005:00:00 E
+
+AC R
+AN VOSS R
+AL 1500 ft AGL
+AH FL95
+V X=60:30:00 N 006:00:00 E
+DC 2
+
+AC C
+AN ARC
+AL 1000m MSL
+AH UNL
+V D=-
+V X=61:00:00 N 006:00:00 E
+DB 61:05:00 N 006:00:00 E, 60:55:00 N 006:00:00 E
+DP 61:00:00 N 006:00:00 E
+`
+
+func Test_ParseOpenAirAirspace(t *testing.T) {
+	airspaces, err := ParseOpenAirAirspace(testOpenAir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(airspaces) != 3 || airspaces[0].Name != "BERGEN CTR" || airspaces[0].Class != "D" || len(airspaces[0].Polygon) != 4 {
+		t.Fatalf("wrong airspaces: %+v", airspaces)
+	}
+	if airspaces[0].Floor.Reference != AltitudeAGL || math.Abs(airspaces[0].Ceiling.Meters-1066.8) > 0.01 {
+		t.Errorf("wrong limits of the ctr: %+v %+v", airspaces[0].Floor, airspaces[0].Ceiling)
+	}
+	if airspaces[1].Floor.Reference != AltitudeAGL || airspaces[1].Ceiling.Reference != AltitudeFL ||
+		math.Abs(airspaces[1].Ceiling.Meters-2895.6) > 0.01 {
+		t.Errorf("wrong limits of the restricted area: %+v %+v", airspaces[1].Floor, airspaces[1].Ceiling)
+	}
+	// every point of the circle is 2 nm from the center
+	for _, p := range airspaces[1].Polygon {
+		if d := DistanceKm(60.5, 6, p[0], p[1]) * 1000; math.Abs(d-3704) > 1 {
+			t.Fatalf("circle point %v is %f m from the center", p, d)
+		}
+	}
+	// the arc goes counter clockwise from north to south, so through the west
+	for _, p := range airspaces[2].Polygon {
+		if p[1] > 6.0001 {
+			t.Fatalf("arc point %v is east of the center", p)
+		}
+	}
+	if _, err := ParseOpenAirAirspace("AC D\nAN X\nDP 91:00:00 N 005:00:00 E\n"); err == nil {
+		t.Error("expected error for coordinate out of range")
+	}
+}
+
+func Test_Infringements(t *testing.T) {
+	airspaces, err := ParseOpenAirAirspace(testOpenAir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := []TrackPoint{
+		{Time: 1000, Lat: 59.9, Lon: 5.25, GNSSAltitude: 500},
+		{Time: 2000, Lat: 60.05, Lon: 5.25, GNSSAltitude: 800}, // inside the ctr
+		{Time: 3000, Lat: 60.08, Lon: 5.25, GNSSAltitude: 1000},
+		{Time: 4000, Lat: 60.08, Lon: 5.25, GNSSAltitude: 1200},                   // above the ctr
+		{Time: 5000, Lat: 60.5, Lon: 6, GNSSAltitude: 900, PressureAltitude: 900}, // below 1500 ft above the takeoff
+	}
+	infringements := Infringements(airspaces, points)
+	if len(infringements) != 1 {
+		t.Fatalf("expected one infringement, got %+v", infringements)
+	}
+	inf := infringements[0]
+	if inf.Airspace != "BERGEN CTR" || inf.Entry != 2000 || inf.Exit != 4000 || math.Abs(inf.MaxPenetration-266.8) > 0.01 {
+		t.Errorf("wrong infringement: %+v", inf)
+	}
+
+	points[4].GNSSAltitude, points[4].PressureAltitude = 1200, 1200
+	if infringements := Infringements(airspaces, points); len(infringements) != 2 || infringements[1].Class != "R" {
+		t.Errorf("expected the restricted area to be infringed: %+v", infringements)
+	}
+}
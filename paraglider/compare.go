@@ -0,0 +1,335 @@
+package paragliding
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+// CompareMgr is the manager for comparing tracks
+type CompareMgr struct {
+	DB *Database
+}
+
+// Comparison is the response for GET /api/compare
+type Comparison struct {
+	Tracks   []CompareTrack  `json:"tracks"`
+	Interval int64           `json:"interval"` // seconds between the samples
+	Route    string          `json:"route"`    // what the progress is measured along, a task id or the first track id
+	Samples  []CompareSample `json:"samples"`
+}
+
+// CompareTrack is the metadata of a compared track
+type CompareTrack struct {
+	ID     string `json:"id"`
+	Pilot  string `json:"pilot"`
+	Glider string `json:"glider"`
+	Color  string `json:"color"` // the colour of the track in the exports, #rrggbb
+}
+
+// CompareSample is the state of every track at one point in time. the positions and distances are in the same
+// order as the tracks, and are null for tracks that are not in the air at that time
+type CompareSample struct {
+	Time      int64              `json:"time"` // unix time in milliseconds
+	Positions []*ComparePosition `json:"positions"`
+	Distances [][]*float64       `json:"distances"` // km between each pair of tracks
+	Leader    string             `json:"leader"`    // the track furthest along the route
+}
+
+// ComparePosition is the position of a track at the time of a sample
+type ComparePosition struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Altitude int64   `json:"altitude"` // meters, gnss
+	Progress float64 `json:"progress"` // km along the route
+}
+
+// the limits of a comparison
+const (
+	compareMaxTracks  = 10
+	compareMaxSamples = 5000
+)
+
+// the colours of the tracks in the exports
+var compareColors = []string{"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4", "#42d4f4", "#f032e6", "#bfef45", "#469990", "#9a6324"}
+
+// HandlerCompare is the handler for GET /api/compare?ids=a,b,c. it aligns the tracks by time and responds with
+// a sample every interval seconds (default 10). the leader is found along the turnpoints of the task parameter,
+// or along the first track if it is not set. with format=gpx or format=kml it responds with the tracks as a file
+func (cMgr *CompareMgr) HandlerCompare(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	ids := parseIDsParam(params.Get("ids"))
+	if len(ids) < 2 || len(ids) > compareMaxTracks {
+		http.Error(w, "ids must have between 2 and "+strconv.Itoa(compareMaxTracks)+" track ids", http.StatusBadRequest)
+		return
+	}
+	interval := int64(10)
+	if s := params.Get("interval"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v <= 0 {
+			http.Error(w, "interval is not a positive number", http.StatusBadRequest)
+			return
+		}
+		interval = v
+	}
+
+	ordered, orderedPoints, ok := loadTracksWithPoints(w, cMgr.DB, ids)
+	if !ok {
+		return
+	}
+
+	route, routeName := orderedPoints[0], ordered[0].ID.Hex()
+	if taskID := params.Get("task"); taskID != "" {
+		task, found := cMgr.DB.GetTaskByID(taskID)
+		if !found {
+			http.Error(w, "the task does not exist", http.StatusNotFound)
+			return
+		}
+		route, routeName = nil, taskID
+		for _, tp := range task.Turnpoints {
+			route = append(route, TrackPoint{Lat: tp.Lat, Lon: tp.Lon})
+		}
+	}
+
+	switch params.Get("format") {
+	case "":
+		w.Header().Add("content-type", "application/json")
+		json.NewEncoder(w).Encode(CompareTracks(ordered, orderedPoints, route, routeName, interval))
+	case "gpx":
+		w.Header().Add("content-type", "application/gpx+xml")
+		w.Header().Add("content-disposition", "attachment; filename=compare.gpx")
+		w.Write(CompareGPX(ordered, orderedPoints))
+	case "kml":
+		w.Header().Add("content-type", "application/vnd.google-earth.kml+xml")
+		w.Header().Add("content-disposition", "attachment; filename=compare.kml")
+		w.Write(CompareKML(ordered, orderedPoints))
+	default:
+		http.Error(w, "format must be gpx or kml", http.StatusBadRequest)
+	}
+}
+
+// loadTracksWithPoints returns the tracks and their points in the order of the ids.
+// if something goes wrong it writes the error to the response and returns false
+func loadTracksWithPoints(w http.ResponseWriter, db *Database, ids []string) ([]TrackInfo, [][]TrackPoint, bool) {
+	tracks, err := db.GetTracksByIDs(ids)
+	if err != nil {
+		http.Error(w, "could not get the tracks", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	points, err := db.GetPointsByTrackIDs(ids)
+	if err != nil {
+		http.Error(w, "could not get the points of the tracks", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	byID := map[string]TrackInfo{}
+	for _, track := range tracks {
+		byID[track.ID.Hex()] = track
+	}
+	pointsByID := map[string][]TrackPoint{}
+	for _, p := range points {
+		pointsByID[p.ID.Hex()] = p.Points
+	}
+	var ordered []TrackInfo
+	var orderedPoints [][]TrackPoint
+	for _, id := range ids {
+		track, found := byID[id]
+		if !found {
+			http.Error(w, "the id "+id+" does not exist", http.StatusNotFound)
+			return nil, nil, false
+		}
+		ordered, orderedPoints = append(ordered, track), append(orderedPoints, pointsByID[id])
+	}
+	return ordered, orderedPoints, true
+}
+
+// parseIDsParam splits a comma separated list of ids, skipping empty and repeated ids
+func parseIDsParam(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// CompareTracks samples the tracks every interval seconds from the first takeoff to the last landing.
+// the interval is increased if there would be more than 5000 samples, and is at most the time between them
+func CompareTracks(tracks []TrackInfo, points [][]TrackPoint, route []TrackPoint, routeName string, interval int64) Comparison {
+	comparison := Comparison{Route: routeName, Samples: []CompareSample{}}
+	start, end := int64(math.MaxInt64), int64(math.MinInt64)
+	for i, track := range tracks {
+		comparison.Tracks = append(comparison.Tracks, CompareTrack{ID: track.ID.Hex(), Pilot: track.Pilot, Glider: track.Glider,
+			Color: compareColors[i%len(compareColors)]})
+		if len(points[i]) > 0 && points[i][0].Time < start {
+			start = points[i][0].Time
+		}
+		if len(points[i]) > 0 && points[i][len(points[i])-1].Time > end {
+			end = points[i][len(points[i])-1].Time
+		}
+	}
+	if start > end {
+		comparison.Interval = interval
+		return comparison
+	}
+	// in seconds, so a huge interval does not overflow
+	span := (end - start) / 1000
+	if interval > span {
+		interval = span
+		if interval < 1 {
+			interval = 1
+		}
+	}
+	for span/interval >= compareMaxSamples {
+		interval *= 2
+	}
+	comparison.Interval = interval
+	legs := routeLegs(route)
+
+	next := make([]int, len(tracks)) // the index of the first fix after the sample time, per track
+	for t := start; t <= end; t += interval * 1000 {
+		sample := CompareSample{Time: t}
+		best := -1.0
+		for i := range tracks {
+			p, found := positionAt(points[i], t, &next[i])
+			if !found {
+				sample.Positions = append(sample.Positions, nil)
+				continue
+			}
+			pos := &ComparePosition{Lat: p.Lat, Lon: p.Lon, Altitude: trackAltitude(p), Progress: routeProgress(route, legs, p.Lat, p.Lon)}
+			sample.Positions = append(sample.Positions, pos)
+			if pos.Progress > best {
+				best, sample.Leader = pos.Progress, comparison.Tracks[i].ID
+			}
+		}
+		for _, a := range sample.Positions {
+			row := make([]*float64, len(tracks))
+			for j, b := range sample.Positions {
+				if a != nil && b != nil {
+					d := DistanceKm(a.Lat, a.Lon, b.Lat, b.Lon)
+					row[j] = &d
+				}
+			}
+			sample.Distances = append(sample.Distances, row)
+		}
+		comparison.Samples = append(comparison.Samples, sample)
+	}
+	return comparison
+}
+
+// positionAt interpolates the position at the time. next is where to start looking, the samples are in order
+// so each track is only walked once. returns false if the track is not in the air at the time
+func positionAt(points []TrackPoint, t int64, next *int) (TrackPoint, bool) {
+	if len(points) == 0 || t < points[0].Time || t > points[len(points)-1].Time {
+		return TrackPoint{}, false
+	}
+	for *next < len(points)-1 && points[*next].Time < t {
+		*next++
+	}
+	b := points[*next]
+	if *next == 0 || b.Time == t {
+		return b, true
+	}
+	a := points[*next-1]
+	f := float64(t-a.Time) / float64(b.Time-a.Time)
+	return TrackPoint{Time: t, Lat: a.Lat + f*(b.Lat-a.Lat), Lon: a.Lon + f*(b.Lon-a.Lon),
+		GNSSAltitude:     a.GNSSAltitude + int64(math.Round(f*float64(b.GNSSAltitude-a.GNSSAltitude))),
+		PressureAltitude: a.PressureAltitude + int64(math.Round(f*float64(b.PressureAltitude-a.PressureAltitude)))}, true
+}
+
+// routeLegs returns the distance in km along the route to each of its points
+func routeLegs(route []TrackPoint) []float64 {
+	legs := make([]float64, len(route))
+	for i := 1; i < len(route); i++ {
+		legs[i] = legs[i-1] + DistanceKm(route[i-1].Lat, route[i-1].Lon, route[i].Lat, route[i].Lon)
+	}
+	return legs
+}
+
+// routeProgress returns how far along the route the position is, in km. the position is projected on the closest
+// segment of the route
+func routeProgress(route []TrackPoint, legs []float64, lat float64, lon float64) float64 {
+	if len(route) == 0 {
+		return 0
+	}
+	if len(route) == 1 {
+		return DistanceKm(route[0].Lat, route[0].Lon, lat, lon)
+	}
+	const rad = math.Pi / 180
+	// flat projection in km around the position
+	project := func(p TrackPoint) (float64, float64) {
+		return (p.Lon - lon) * rad * math.Cos(lat*rad) * igc.EarthRadius, (p.Lat - lat) * rad * igc.EarthRadius
+	}
+	bestDist, progress := math.Inf(1), 0.0
+	for i := 1; i < len(route); i++ {
+		x1, y1 := project(route[i-1])
+		x2, y2 := project(route[i])
+		dx, dy := x2-x1, y2-y1
+		t := 0.0
+		if dx != 0 || dy != 0 {
+			t = math.Max(0, math.Min(1, -(x1*dx+y1*dy)/(dx*dx+dy*dy)))
+		}
+		if d := math.Hypot(x1+t*dx, y1+t*dy); d < bestDist {
+			bestDist, progress = d, legs[i-1]+t*(legs[i]-legs[i-1])
+		}
+	}
+	return progress
+}
+
+// CompareGPX writes the tracks as a gpx file with one track per pilot. the colours use the garmin extension
+func CompareGPX(tracks []TrackInfo, points [][]TrackPoint) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<gpx version="1.1" creator="paragliding" xmlns="http://www.topografix.com/GPX/1/1"` +
+		` xmlns:gpxx="http://www.garmin.com/xmlschemas/GpxExtensions/v3">` + "\n")
+	for i, track := range tracks {
+		buf.WriteString("  <trk>\n    <name>")
+		xml.EscapeText(&buf, []byte(track.Pilot+" "+track.ID.Hex()))
+		fmt.Fprintf(&buf, "</name>\n    <extensions><gpxx:TrackExtension><gpxx:DisplayColor>%s</gpxx:DisplayColor></gpxx:TrackExtension></extensions>\n",
+			garminColor(i))
+		buf.WriteString("    <trkseg>\n")
+		for _, p := range points[i] {
+			fmt.Fprintf(&buf, "      <trkpt lat=\"%f\" lon=\"%f\"><ele>%d</ele><time>%s</time></trkpt>\n",
+				p.Lat, p.Lon, trackAltitude(p), time.Unix(0, p.Time*int64(time.Millisecond)).UTC().Format(time.RFC3339))
+		}
+		buf.WriteString("    </trkseg>\n  </trk>\n")
+	}
+	buf.WriteString("</gpx>\n")
+	return buf.Bytes()
+}
+
+// CompareKML writes the tracks as a kml file with one coloured line per pilot
+func CompareKML(tracks []TrackInfo, points [][]TrackPoint) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2">` + "\n<Document>\n")
+	for i, track := range tracks {
+		color := compareColors[i%len(compareColors)]
+		// kml colours are aabbggrr
+		fmt.Fprintf(&buf, "  <Style id=\"track%d\"><LineStyle><color>ff%s%s%s</color><width>3</width></LineStyle></Style>\n",
+			i, color[5:7], color[3:5], color[1:3])
+		buf.WriteString("  <Placemark>\n    <name>")
+		xml.EscapeText(&buf, []byte(track.Pilot+" "+track.ID.Hex()))
+		fmt.Fprintf(&buf, "</name>\n    <styleUrl>#track%d</styleUrl>\n    <LineString><altitudeMode>absolute</altitudeMode><coordinates>\n", i)
+		for _, p := range points[i] {
+			fmt.Fprintf(&buf, "      %f,%f,%d\n", p.Lon, p.Lat, trackAltitude(p))
+		}
+		buf.WriteString("    </coordinates></LineString>\n  </Placemark>\n")
+	}
+	buf.WriteString("</Document>\n</kml>\n")
+	return buf.Bytes()
+}
+
+// garminColor returns the name of the garmin display colour of the track with the given index
+func garminColor(i int) string {
+	colors := []string{"Red", "Green", "Blue", "DarkYellow", "Magenta", "Cyan", "DarkMagenta", "Yellow", "DarkCyan", "DarkRed"}
+	return colors[i%len(colors)]
+}
@@ -0,0 +1,52 @@
+package paragliding
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_CompareTracks(t *testing.T) {
+	tracks := []TrackInfo{{ID: objectid.New(), Pilot: "a"}, {ID: objectid.New(), Pilot: "b"}}
+	points := [][]TrackPoint{
+		{{Time: 0, Lat: 60, Lon: 5, GNSSAltitude: 1000}, {Time: 20000, Lat: 60, Lon: 5.2, GNSSAltitude: 1200}},
+		{{Time: 10000, Lat: 60, Lon: 5.2, GNSSAltitude: 500}, {Time: 30000, Lat: 60, Lon: 5.4, GNSSAltitude: 500}},
+	}
+	comparison := CompareTracks(tracks, points, points[0], tracks[0].ID.Hex(), 10)
+	if len(comparison.Samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d", len(comparison.Samples))
+	}
+	first, second := comparison.Samples[0], comparison.Samples[1]
+	if first.Positions[1] != nil || first.Distances[0][1] != nil || first.Leader != tracks[0].ID.Hex() {
+		t.Errorf("b is not in the air at the first sample: %+v", first)
+	}
+	// a is half way at the second sample
+	if p := second.Positions[0]; math.Abs(p.Lon-5.1) > 1e-9 || p.Altitude != 1100 || math.Abs(p.Progress-5.56) > 0.01 {
+		t.Errorf("wrong interpolated position: %+v", p)
+	}
+	if d := *second.Distances[0][1]; math.Abs(d-5.56) > 0.01 || *second.Distances[1][0] != d {
+		t.Errorf("wrong distance between the pilots: %f", d)
+	}
+	if second.Leader != tracks[1].ID.Hex() {
+		t.Error("b is furthest along the route at the second sample")
+	}
+	if comparison.Samples[3].Positions[0] != nil {
+		t.Error("a has landed at the last sample")
+	}
+
+	// an interval longer than the flights is cut to their span
+	if comparison := CompareTracks(tracks, points, points[0], "", math.MaxInt64); comparison.Interval != 30 ||
+		len(comparison.Samples) != 2 {
+		t.Errorf("expected 2 samples 30 seconds apart, got %d with interval %d", len(comparison.Samples), comparison.Interval)
+	}
+
+	kml := string(CompareKML(tracks, points))
+	if !strings.Contains(kml, "<color>ff4b19e6</color>") || strings.Count(kml, "<Placemark>") != 2 {
+		t.Errorf("wrong kml: %s", kml)
+	}
+	if gpx := string(CompareGPX(tracks, points)); strings.Count(gpx, "<trk>") != 2 || !strings.Contains(gpx, "1970-01-01T00:00:20Z") {
+		t.Errorf("wrong gpx: %s", gpx)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
 )
 
 // Database is the representation of the databse
@@ -29,6 +31,117 @@ type TrackInfo struct {
 	TrackLength string            `bson:"track_length" json:"track_length"`
 	TrackURL    string            `bson:"track_url" json:"track_url"`
 	Timestamp   int64             `bson:"timestamp" json:"-"`
+	PilotID     string            `bson:"pilot_id" json:"pilot_id"`
+	Airtime     int64             `bson:"airtime" json:"airtime"`         // seconds from the first to the last fix
+	XCDistance  float64           `bson:"xc_distance" json:"xc_distance"` // km, the furthest the track got from the takeoff
+	GliderRef   string            `bson:"glider_ref" json:"glider_ref"`   // the normalised glider, empty if no rule matched
+	GliderClass string            `bson:"glider_class" json:"glider_class"`
+	GliderSize  string            `bson:"glider_size" json:"glider_size"`
+	SiteID      string            `bson:"site_id" json:"site_id"` // the takeoff site, empty if the takeoff was not at a known site
+	Site        string            `bson:"site" json:"site"`
+	Validity    string            `bson:"validity" json:"validity"`                     // the security record check, see validity.go
+	Warnings    []Diagnostic      `bson:"warnings,omitempty" json:"warnings,omitempty"` // what a lenient ingest found, see diagnostics.go
+}
+
+// SiteInfo represents a takeoff site. is used both in database and as a response
+type SiteInfo struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	Name        string            `bson:"name" json:"name"`
+	Lat         float64           `bson:"lat" json:"lat"`
+	Lon         float64           `bson:"lon" json:"lon"`
+	Radius      float64           `bson:"radius" json:"radius"`           // meters
+	Orientation string            `bson:"orientation" json:"orientation"` // the wind directions the site works in, eg. "SW" or "180-270"
+}
+
+// PilotInfo represents a pilot. is used both in database and as a response
+type PilotInfo struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	Name        string            `bson:"name" json:"name"`       // the canonical name
+	Aliases     []string          `bson:"aliases" json:"aliases"` // normalised names used to link tracks to the pilot
+	Club        string            `bson:"club" json:"club"`
+	Nationality string            `bson:"nationality" json:"nationality"`
+	Auto        bool              `bson:"auto" json:"auto"` // created for the name of a track, other pilots can take its aliases
+}
+
+// WaypointInfo is a waypoint imported from a waypoint file. is used both in database and as a response
+type WaypointInfo struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	Name        string            `bson:"name" json:"name"`
+	Code        string            `bson:"code" json:"code"`
+	Lat         float64           `bson:"lat" json:"lat"`
+	Lon         float64           `bson:"lon" json:"lon"`
+	Elevation   float64           `bson:"elevation" json:"elevation"` // meters
+	Description string            `bson:"description" json:"description"`
+}
+
+// TaskInfo is a competition task. is used both in database and as a response
+type TaskInfo struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	Name        string            `bson:"name" json:"name"`
+	Turnpoints  []Turnpoint       `bson:"turnpoints" json:"turnpoints"`
+	StartType   string            `bson:"start_type" json:"start_type"`   // race or elapsed
+	StartGate   int64             `bson:"start_gate" json:"start_gate"`   // unix time in milliseconds, the start opens at this time
+	GoalType    string            `bson:"goal_type" json:"goal_type"`     // cylinder or line
+	WindowOpen  int64             `bson:"window_open" json:"window_open"` // unix time in milliseconds
+	WindowClose int64             `bson:"window_close" json:"window_close"`
+}
+
+// Turnpoint is a cylinder of a task. the goal line has the radius as half its length
+type Turnpoint struct {
+	Waypoint string  `bson:"waypoint" json:"waypoint,omitempty"` // the name of a stored waypoint, used for the position if it is set
+	Name     string  `bson:"name" json:"name"`
+	Lat      float64 `bson:"lat" json:"lat"`
+	Lon      float64 `bson:"lon" json:"lon"`
+	Radius   float64 `bson:"radius" json:"radius"` // meters
+	Type     string  `bson:"type" json:"type"`     // takeoff, sss, turnpoint, ess or goal
+	Exit     bool    `bson:"exit" json:"exit"`     // only for sss, the start is crossed going out of the cylinder
+}
+
+// TaskResult is the result of a track validated against a task. is used both in database and as a response
+type TaskResult struct {
+	ID        objectid.ObjectID `bson:"_id" json:"-"`
+	TaskID    string            `bson:"task_id" json:"task_id"`
+	TrackID   string            `bson:"track_id" json:"track_id"`
+	PilotID   string            `bson:"pilot_id" json:"pilot_id"`
+	Pilot     string            `bson:"pilot" json:"pilot"`
+	Reached   []TurnpointTime   `bson:"reached" json:"reached"`
+	StartTime int64             `bson:"start_time" json:"start_time"` // unix time in milliseconds, 0 if the pilot did not start
+	ESSTime   int64             `bson:"ess_time" json:"ess_time"`
+	SpeedTime int64             `bson:"speed_time" json:"speed_time"` // seconds in the speed section, 0 if ess was not reached
+	Goal      bool              `bson:"goal" json:"goal"`
+	Distance  float64           `bson:"distance" json:"distance"` // km along the task
+}
+
+// TurnpointTime is when a turnpoint was reached
+type TurnpointTime struct {
+	Index int    `bson:"index" json:"index"`
+	Name  string `bson:"name" json:"name"`
+	Time  int64  `bson:"time" json:"time"` // unix time in milliseconds
+}
+
+// TrackPoint is a single fix of a track as it is stored in the database
+type TrackPoint struct {
+	Time             int64   `bson:"time" json:"time"` // unix time in milliseconds
+	Lat              float64 `bson:"lat" json:"lat"`
+	Lon              float64 `bson:"lon" json:"lon"`
+	PressureAltitude int64   `bson:"pressure_altitude" json:"pressure_altitude"`
+	GNSSAltitude     int64   `bson:"gnss_altitude" json:"gnss_altitude"`
+}
+
+// TrackPoints stores the fixes of a track. it has the same id as the track it belongs to
+type TrackPoints struct {
+	ID     objectid.ObjectID `bson:"_id" json:"-"`
+	Points []TrackPoint      `bson:"points" json:"points"`
+}
+
+// HeatmapCounts is how many fixes the cells of an index tile of the heatmap have, for one layer, day and glider
+// class. see heatmap.go. the cells are keyed by their number in base 10
+type HeatmapCounts struct {
+	Layer string           `bson:"layer"`
+	Day   string           `bson:"day"`
+	Class string           `bson:"class"`
+	Index int64            `bson:"index"`
+	Cells map[string]int32 `bson:"cells"`
 }
 
 // WebhookInfo represents a webhook. is used both in databse and as a response
@@ -38,6 +151,19 @@ type WebhookInfo struct {
 	MinTriggerValue int64             `bson:"minTriggerValue" json:"minTriggerValue"`
 	Counter         int64             `bson:"counter" json:"-"`
 	LatestTimestamp int64             `bson:"latestTimestamp" json:"-"` // the latest timestamp that invoked this webhook
+	Airspace        bool              `bson:"airspace" json:"airspace"` // also alert when a new track infringes airspace
+	Secret          string            `bson:"secret" json:"-"`          // signs the requests, see the webhooksig package
+	PreviousSecret  string            `bson:"previous_secret" json:"-"` // also signs the requests until it expires
+	PreviousExpires int64             `bson:"previous_expires" json:"-"`
+	Format          string            `bson:"format" json:"format"`      // the payload format, see payload.go
+	Template        string            `bson:"template" json:"template"`  // the text of the message, see template.go
+	Events          []string          `bson:"events" json:"events"`      // the event types the webhook is subscribed to
+	Filter          string            `bson:"filter" json:"filter"`      // only the matching tracks are sent, see filter.go
+	Schedule        string            `bson:"schedule" json:"schedule"`  // hourly or daily HH:MM for a digest, see digest.go
+	Timezone        string            `bson:"timezone" json:"timezone"`  // of the schedule, UTC by default
+	NextDigest      int64             `bson:"next_digest" json:"-"`      // unix time in milliseconds the next digest is sent
+	MaxDelay        int64             `bson:"max_delay" json:"maxDelay"` // seconds, new tracks are sent at the latest after this
+	Paused          bool              `bson:"paused" json:"paused"`      // nothing is sent while the webhook is paused
 }
 
 // Connect creates a connection to the database
@@ -51,6 +177,17 @@ func (db *Database) Connect() {
 	db.db = db.conn.Database(db.Name)
 }
 
+// EnsureIndexes creates the indexes the collections rely on. an alias can only belong to one pilot, so two tracks
+// flown under a new name can not both create a pilot for it. the pilots without aliases are left out of the index
+func (db *Database) EnsureIndexes() error {
+	_, err := db.db.Collection("pilots").Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.NewDocument(bson.EC.Int32("aliases", 1)),
+		Options: bson.NewDocument(bson.EC.Boolean("unique", true),
+			bson.EC.SubDocumentFromElements("partialFilterExpression",
+				bson.EC.SubDocumentFromElements("aliases", bson.EC.String("$type", "string"))))})
+	return err
+}
+
 // Insert insert an object into specified collection. the id of the inserted object and and wether it was added
 func (db *Database) Insert(collection string, obj interface{}) (string, bool) {
 	res, err := db.db.Collection(collection).InsertOne(context.Background(), obj)
@@ -101,7 +238,7 @@ func (db *Database) GetTrackByID(id string) (TrackInfo, bool) {
 			log.Fatal(err)
 		}
 	}
-	if track == (TrackInfo{}) {
+	if track.ID == (objectid.ObjectID{}) {
 		return track, false
 	}
 
@@ -126,6 +263,9 @@ func (db *Database) DeleteAllTracks() (int64, error) {
 		return count, err
 	}
 	col.DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("points").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("task_results").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("heatmap").DeleteMany(context.Background(), bson.NewDocument())
 	return count, err
 }
 
@@ -152,6 +292,377 @@ func (db *Database) GetAllTracks() ([]TrackInfo, error) {
 	return tracks, err
 }
 
+// GetTracksByIDs returns the tracks with the given ids using a single query. ids that are not found are skipped
+func (db *Database) GetTracksByIDs(ids []string) ([]TrackInfo, error) {
+	return db.findTracks(idsFilter(ids))
+}
+
+// GetTracks returns a page of the tracks, the oldest first
+func (db *Database) GetTracks(skip int64, limit int64) ([]TrackInfo, error) {
+	return db.findTracks(nil, findopt.Sort(bson.NewDocument(bson.EC.Int32("_id", 1))), findopt.Skip(skip),
+		findopt.Limit(limit))
+}
+
+// findTracks returns the tracks matching the filter
+func (db *Database) findTracks(filter *bson.Document, opts ...findopt.Find) ([]TrackInfo, error) {
+	cursor, err := db.db.Collection("tracks").Find(context.Background(), filter, opts...)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var tracks []TrackInfo
+	for cursor.Next(context.Background()) {
+		track := TrackInfo{}
+		if err := cursor.Decode(&track); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// GetPointsByTrackIDs returns the stored points of the given tracks using a single query
+func (db *Database) GetPointsByTrackIDs(ids []string) ([]TrackPoints, error) {
+	cursor, err := db.db.Collection("points").Find(context.Background(), idsFilter(ids))
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var points []TrackPoints
+	for cursor.Next(context.Background()) {
+		p := TrackPoints{}
+		if err := cursor.Decode(&p); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// GetHeatmapCounts returns the stored counts of the heatmap
+func (db *Database) GetHeatmapCounts() ([]HeatmapCounts, error) {
+	cursor, err := db.db.Collection("heatmap").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var counts []HeatmapCounts
+	for cursor.Next(context.Background()) {
+		c := HeatmapCounts{}
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// AddHeatmapCounts adds the counts to the stored counts of the heatmap, the index tiles that are not stored are
+// created
+func (db *Database) AddHeatmapCounts(counts []HeatmapCounts) error {
+	for _, c := range counts {
+		var inc []*bson.Element
+		for cell, n := range c.Cells {
+			inc = append(inc, bson.EC.Int32("cells."+cell, n))
+		}
+		_, err := db.db.Collection("heatmap").UpdateOne(context.Background(),
+			bson.NewDocument(bson.EC.String("layer", c.Layer), bson.EC.String("day", c.Day),
+				bson.EC.String("class", c.Class), bson.EC.Int64("index", c.Index)),
+			bson.NewDocument(bson.EC.SubDocumentFromElements("$inc", inc...)), updateopt.Upsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idsFilter creates a filter matching every document with one of the given ids. invalid ids are ignored
+func idsFilter(ids []string) *bson.Document {
+	var values []*bson.Value
+	for _, id := range ids {
+		if oID, err := objectid.FromHex(id); err == nil {
+			values = append(values, bson.VC.ObjectID(oID))
+		}
+	}
+	return bson.NewDocument(bson.EC.SubDocumentFromElements("_id", bson.EC.ArrayFromElements("$in", values...)))
+}
+
+// GetPilotByID returns the pilot for the given id and true/false for wether it was found
+func (db *Database) GetPilotByID(id string) (PilotInfo, bool) {
+	pilot := PilotInfo{}
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return pilot, false
+	}
+	err = db.db.Collection("pilots").FindOne(context.Background(), bson.NewDocument(bson.EC.ObjectID("_id", objectID))).Decode(&pilot)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println(err)
+		}
+		return pilot, false
+	}
+	return pilot, true
+}
+
+// GetPilotByAlias returns the pilot that has the given normalised alias and true/false for wether it was found
+func (db *Database) GetPilotByAlias(alias string) (PilotInfo, bool) {
+	pilot := PilotInfo{}
+	err := db.db.Collection("pilots").FindOne(context.Background(), bson.NewDocument(bson.EC.String("aliases", alias))).Decode(&pilot)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println(err)
+		}
+		return pilot, false
+	}
+	return pilot, true
+}
+
+// GetAllPilots returns all the pilots in the database
+func (db *Database) GetAllPilots() ([]PilotInfo, error) {
+	cursor, err := db.db.Collection("pilots").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var pilots []PilotInfo
+	for cursor.Next(context.Background()) {
+		pilot := PilotInfo{}
+		if err := cursor.Decode(&pilot); err != nil {
+			return nil, err
+		}
+		pilots = append(pilots, pilot)
+	}
+	return pilots, nil
+}
+
+// AddPilotAlias adds a normalised alias to the pilot with the given id
+func (db *Database) AddPilotAlias(id objectid.ObjectID, alias string) error {
+	_, err := db.db.Collection("pilots").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$addToSet", bson.EC.String("aliases", alias))))
+	return err
+}
+
+// RemovePilotAlias removes an alias from the pilot with the given id. the pilot is deleted if it was created for
+// the name of a track and has no aliases left
+func (db *Database) RemovePilotAlias(id objectid.ObjectID, alias string) error {
+	col := db.db.Collection("pilots")
+	_, err := col.UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$pull", bson.EC.String("aliases", alias))))
+	if err != nil {
+		return err
+	}
+	_, err = col.DeleteOne(context.Background(), bson.NewDocument(bson.EC.ObjectID("_id", id),
+		bson.EC.Boolean("auto", true), bson.EC.ArrayFromElements("aliases")))
+	return err
+}
+
+// GetTracksByPilot returns all the tracks linked to the given pilot
+func (db *Database) GetTracksByPilot(pilotID string) ([]TrackInfo, error) {
+	return db.findTracks(bson.NewDocument(bson.EC.String("pilot_id", pilotID)))
+}
+
+// SetTracksPilot links the tracks with the given ids to a pilot
+func (db *Database) SetTracksPilot(ids []string, pilotID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := db.db.Collection("tracks").UpdateMany(context.Background(), idsFilter(ids),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.String("pilot_id", pilotID))))
+	return err
+}
+
+// GetAllSites returns all the takeoff sites in the database
+func (db *Database) GetAllSites() ([]SiteInfo, error) {
+	cursor, err := db.db.Collection("sites").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var sites []SiteInfo
+	for cursor.Next(context.Background()) {
+		site := SiteInfo{}
+		if err := cursor.Decode(&site); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// GetSiteByID returns the site for the given id and true/false for wether it was found
+func (db *Database) GetSiteByID(id string) (SiteInfo, bool) {
+	site := SiteInfo{}
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return site, false
+	}
+	err = db.db.Collection("sites").FindOne(context.Background(), bson.NewDocument(bson.EC.ObjectID("_id", objectID))).Decode(&site)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println(err)
+		}
+		return site, false
+	}
+	return site, true
+}
+
+// GetTracksBySite returns all the tracks that took off from the given site
+func (db *Database) GetTracksBySite(siteID string) ([]TrackInfo, error) {
+	return db.findTracks(bson.NewDocument(bson.EC.String("site_id", siteID)))
+}
+
+// GetLongestSiteTrack returns the longest track from the site other than the track with the given id, and false if
+// there is none
+func (db *Database) GetLongestSiteTrack(siteID string, except objectid.ObjectID) (TrackInfo, bool, error) {
+	return db.longestTrack(bson.NewDocument(bson.EC.String("site_id", siteID),
+		bson.EC.SubDocumentFromElements("_id", bson.EC.ObjectID("$ne", except))))
+}
+
+// GetLongestSeasonTrack returns the longest track flown in the year other than the track with the given id, and
+// false if there is none. with verifiedOnly only the verified tracks are looked at
+func (db *Database) GetLongestSeasonTrack(season int, verifiedOnly bool, except objectid.ObjectID) (TrackInfo, bool, error) {
+	// H_date starts with the year
+	filter := bson.NewDocument(bson.EC.SubDocumentFromElements("H_date",
+		bson.EC.String("$gte", fmt.Sprintf("%04d-", season)), bson.EC.String("$lt", fmt.Sprintf("%04d-", season+1))),
+		bson.EC.SubDocumentFromElements("_id", bson.EC.ObjectID("$ne", except)))
+	if verifiedOnly {
+		filter.Append(bson.EC.String("validity", ValidityVerified))
+	}
+	return db.longestTrack(filter)
+}
+
+// longestTrack returns the longest of the tracks that match the filter. the length is stored as a string, so it is
+// converted to a number to be sorted on
+func (db *Database) longestTrack(filter *bson.Document) (TrackInfo, bool, error) {
+	pipeline := []*bson.Document{
+		bson.NewDocument(bson.EC.SubDocument("$match", filter)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$addFields", bson.EC.SubDocumentFromElements("length_km",
+			bson.EC.SubDocumentFromElements("$convert", bson.EC.String("input", "$track_length"),
+				bson.EC.String("to", "double"), bson.EC.Double("onError", 0), bson.EC.Double("onNull", 0))))),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$sort", bson.EC.Int32("length_km", -1))),
+		bson.NewDocument(bson.EC.Int64("$limit", 1)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$project", bson.EC.Int32("length_km", 0))),
+	}
+	cursor, err := db.db.Collection("tracks").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		fmt.Println(err)
+		return TrackInfo{}, false, err
+	}
+	defer cursor.Close(context.Background())
+
+	track := TrackInfo{}
+	if !cursor.Next(context.Background()) {
+		return track, false, cursor.Err()
+	}
+	if err := cursor.Decode(&track); err != nil {
+		return track, false, err
+	}
+	return track, true, nil
+}
+
+// GetTracksByValidity returns all the tracks with the given validity
+func (db *Database) GetTracksByValidity(validity string) ([]TrackInfo, error) {
+	return db.findTracks(bson.NewDocument(bson.EC.String("validity", validity)))
+}
+
+// SetTrackSite tags a track with a takeoff site
+func (db *Database) SetTrackSite(trackID objectid.ObjectID, site SiteInfo) error {
+	_, err := db.db.Collection("tracks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", trackID)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("site_id", site.ID.Hex()), bson.EC.String("site", site.Name))))
+	return err
+}
+
+// GetAllWaypoints returns all the waypoints in the database
+func (db *Database) GetAllWaypoints() ([]WaypointInfo, error) {
+	cursor, err := db.db.Collection("waypoints").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var waypoints []WaypointInfo
+	for cursor.Next(context.Background()) {
+		waypoint := WaypointInfo{}
+		if err := cursor.Decode(&waypoint); err != nil {
+			return nil, err
+		}
+		waypoints = append(waypoints, waypoint)
+	}
+	return waypoints, nil
+}
+
+// GetAllTasks returns all the tasks in the database
+func (db *Database) GetAllTasks() ([]TaskInfo, error) {
+	cursor, err := db.db.Collection("tasks").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var tasks []TaskInfo
+	for cursor.Next(context.Background()) {
+		task := TaskInfo{}
+		if err := cursor.Decode(&task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// GetTaskByID returns the task for the given id and true/false for wether it was found
+func (db *Database) GetTaskByID(id string) (TaskInfo, bool) {
+	task := TaskInfo{}
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return task, false
+	}
+	err = db.db.Collection("tasks").FindOne(context.Background(), bson.NewDocument(bson.EC.ObjectID("_id", objectID))).Decode(&task)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			fmt.Println(err)
+		}
+		return task, false
+	}
+	return task, true
+}
+
+// GetTaskResults returns the results of all the tracks validated against the given task
+func (db *Database) GetTaskResults(taskID string) ([]TaskResult, error) {
+	cursor, err := db.db.Collection("task_results").Find(context.Background(), bson.NewDocument(bson.EC.String("task_id", taskID)))
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var results []TaskResult
+	for cursor.Next(context.Background()) {
+		result := TaskResult{}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 // GetWebhookByID returns the webhook for the given id and true/false for wether it was found
 func (db *Database) GetWebhookByID(id string) (WebhookInfo, bool) {
 	var cursor mongo.Cursor
@@ -170,13 +681,25 @@ func (db *Database) GetWebhookByID(id string) (WebhookInfo, bool) {
 			log.Fatal(err)
 		}
 	}
-	if webhook == (WebhookInfo{}) {
+	if webhook.ID == (objectid.ObjectID{}) {
 		return webhook, false
 	}
 
 	return webhook, true
 }
 
+// SetWebhookSecret sets the secret of the webhook, and the previous secret that is used until it expires
+func (db *Database) SetWebhookSecret(webhook WebhookInfo) error {
+	_, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID)),
+		bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.String("secret", webhook.Secret),
+				bson.EC.String("previous_secret", webhook.PreviousSecret),
+				bson.EC.Int64("previous_expires", webhook.PreviousExpires))))
+	return err
+}
+
 // DeleteWebhookByID deletes the specified webhook from the database
 func (db *Database) DeleteWebhookByID(id string) error {
 	oID, err := objectid.FromHex(id)
@@ -190,11 +713,84 @@ func (db *Database) DeleteWebhookByID(id string) error {
 	return nil
 }
 
-// GetAllInvokeWebhooks returns an rray of every webhook that should be invoked
-func (db *Database) GetAllInvokeWebhooks() ([]WebhookInfo, error) {
-	// subtracts 1 from each webhook's counter
+// GetWebhooksByEvent returns every webhook that is subscribed to the event type
+func (db *Database) GetWebhooksByEvent(eventType string) ([]WebhookInfo, error) {
+	return db.findWebhooks(bson.NewDocument(bson.EC.String("events", eventType)))
+}
+
+// GetAirspaceWebhooks returns every webhook that alerts on airspace infringements, from before webhooks had events
+func (db *Database) GetAirspaceWebhooks() ([]WebhookInfo, error) {
+	return db.findWebhooks(bson.NewDocument(bson.EC.Boolean("airspace", true)))
+}
+
+// findWebhooks returns the webhooks that match the filter and are not paused
+func (db *Database) findWebhooks(filter *bson.Document) ([]WebhookInfo, error) {
+	cursor, err := db.db.Collection("webhooks").Find(context.Background(), filter.Append(notPaused()))
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var webhooks []WebhookInfo
+	for cursor.Next(context.Background()) {
+		webhook := WebhookInfo{}
+		if err := cursor.Decode(&webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// GetAllAirspaceFiles returns all the uploaded airspace files
+func (db *Database) GetAllAirspaceFiles() ([]AirspaceFile, error) {
+	cursor, err := db.db.Collection("airspace_files").Find(context.Background(), nil)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var files []AirspaceFile
+	for cursor.Next(context.Background()) {
+		file := AirspaceFile{}
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// notPaused matches the webhooks that are not paused, also the ones from before webhooks could be paused
+func notPaused() *bson.Element {
+	return bson.EC.SubDocumentFromElements("paused", bson.EC.Boolean("$ne", true))
+}
+
+// trackCreatedFilter matches the webhooks that are subscribed to new tracks, and the ones from before webhooks had events
+func trackCreatedFilter() *bson.Element {
+	return bson.EC.ArrayFromElements("$or",
+		bson.VC.DocumentFromElements(bson.EC.Null("events")),
+		bson.VC.DocumentFromElements(bson.EC.String("events", EventTrackCreated)))
+}
+
+// GetAllInvokeWebhooks returns an rray of every webhook that should be invoked. the webhooks in skip are the ones
+// whose filter the new track does not match, their counters are left as they are
+func (db *Database) GetAllInvokeWebhooks(skip []objectid.ObjectID) ([]WebhookInfo, error) {
+	// subtracts 1 from the counter of each webhook that is subscribed to new tracks
 	coll := db.db.Collection("webhooks")
-	_, err := coll.UpdateMany(context.Background(), nil, bson.NewDocument(bson.EC.SubDocumentFromElements("$inc",
+	// the digests are sent by the scheduler, and the paused webhooks do not count the tracks
+	filter := bson.NewDocument(trackCreatedFilter(), notPaused(), bson.EC.SubDocumentFromElements("schedule",
+		bson.EC.ArrayFromElements("$in", bson.VC.Null(), bson.VC.String(""))))
+	if len(skip) > 0 {
+		var values []*bson.Value
+		for _, id := range skip {
+			values = append(values, bson.VC.ObjectID(id))
+		}
+		filter.Append(bson.EC.SubDocumentFromElements("_id", bson.EC.ArrayFromElements("$nin", values...)))
+	}
+	_, err := coll.UpdateMany(context.Background(), filter, bson.NewDocument(bson.EC.SubDocumentFromElements("$inc",
 		bson.EC.Int64("counter", -1))))
 	if err != nil {
 		fmt.Println(err)
@@ -202,7 +798,8 @@ func (db *Database) GetAllInvokeWebhooks() ([]WebhookInfo, error) {
 	}
 
 	// selects all webhooks that should be triggered (counter = 0)
-	cursor, err2 := coll.Find(context.Background(), bson.NewDocument(bson.EC.SubDocumentFromElements("counter",
+	cursor, err2 := coll.Find(context.Background(), bson.NewDocument(trackCreatedFilter(), notPaused(), bson.EC.SubDocumentFromElements("schedule",
+		bson.EC.ArrayFromElements("$in", bson.VC.Null(), bson.VC.String(""))), bson.EC.SubDocumentFromElements("counter",
 		bson.EC.Int64("$lte", 0))))
 	if err2 != nil {
 		fmt.Println(err2)
@@ -211,9 +808,9 @@ func (db *Database) GetAllInvokeWebhooks() ([]WebhookInfo, error) {
 	defer cursor.Close(context.Background())
 
 	var whs []WebhookInfo
-	wh := WebhookInfo{}
 	// adds the webhooks to be invoked into the array that will be returned
 	for cursor.Next(context.Background()) {
+		wh := WebhookInfo{} // decode does not clear the fields the document does not have
 		err := cursor.Decode(&wh)
 		if err != nil {
 			log.Fatal(err)
@@ -236,8 +833,236 @@ func (db *Database) ResetWebhookCounter(webhook WebhookInfo) {
 	}
 }
 
+// GetWebhooks returns a page of the webhooks, the oldest first, and the number of webhooks
+func (db *Database) GetWebhooks(skip int64, limit int64) ([]WebhookInfo, int64, error) {
+	col := db.db.Collection("webhooks")
+	total, err := col.Count(context.Background(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := col.Find(context.Background(), nil, findopt.Sort(bson.NewDocument(bson.EC.Int32("_id", 1))),
+		findopt.Skip(skip), findopt.Limit(limit))
+	if err != nil {
+		fmt.Println(err)
+		return nil, 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var webhooks []WebhookInfo
+	for cursor.Next(context.Background()) {
+		webhook := WebhookInfo{}
+		if err := cursor.Decode(&webhook); err != nil {
+			return nil, 0, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, total, nil
+}
+
+// UpdateWebhook sets the settings of the webhook and adds counterChange to its counter. the counter is changed
+// instead of set, so the tracks that are counted while the webhook is updated are not lost
+func (db *Database) UpdateWebhook(webhook WebhookInfo, counterChange int64) error {
+	var events []*bson.Value
+	for _, event := range webhook.Events {
+		events = append(events, bson.VC.String(event))
+	}
+	_, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID)),
+		bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.String("webhookURL", webhook.WebhookURL),
+				bson.EC.Int64("minTriggerValue", webhook.MinTriggerValue),
+				bson.EC.ArrayFromElements("events", events...),
+				bson.EC.String("format", webhook.Format),
+				bson.EC.String("template", webhook.Template),
+				bson.EC.String("filter", webhook.Filter),
+				bson.EC.String("schedule", webhook.Schedule),
+				bson.EC.String("timezone", webhook.Timezone),
+				bson.EC.Int64("next_digest", webhook.NextDigest),
+				bson.EC.Int64("max_delay", webhook.MaxDelay)),
+			bson.EC.SubDocumentFromElements("$inc", bson.EC.Int64("counter", counterChange))))
+	return err
+}
+
+// PauseWebhook pauses the webhook
+func (db *Database) PauseWebhook(id objectid.ObjectID) error {
+	_, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Boolean("paused", true))))
+	return err
+}
+
+// ResumeWebhook resumes the webhook with its counter, LatestTimestamp and next digest
+func (db *Database) ResumeWebhook(webhook WebhookInfo) error {
+	_, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID)),
+		bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.Boolean("paused", false),
+				bson.EC.Int64("counter", webhook.Counter),
+				bson.EC.Int64("latestTimestamp", webhook.LatestTimestamp),
+				bson.EC.Int64("next_digest", webhook.NextDigest))))
+	return err
+}
+
+// ClaimWebhookTracks resets the counter and sets LatestTimestamp of the webhook, if its LatestTimestamp still is
+// previous. returns false if the tracks were already claimed
+func (db *Database) ClaimWebhookTracks(webhook WebhookInfo, previous int64) bool {
+	result, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID), bson.EC.Int64("latestTimestamp", previous)),
+		bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.Int64("counter", webhook.MinTriggerValue),
+				bson.EC.Int64("latestTimestamp", webhook.LatestTimestamp))))
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return result.MatchedCount == 1
+}
+
+// ClaimDigest moves the next digest of the webhook to next, if it still is the one of the webhook. returns false
+// if the digest was already claimed
+func (db *Database) ClaimDigest(webhook WebhookInfo, next int64) bool {
+	result, err := db.db.Collection("webhooks").UpdateOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID), bson.EC.Int64("next_digest", webhook.NextDigest)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Int64("next_digest", next))))
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return result.MatchedCount == 1
+}
+
+// GetDueDigests returns the webhooks with a digest that is due
+func (db *Database) GetDueDigests(now int64) ([]WebhookInfo, error) {
+	return db.findWebhooks(bson.NewDocument(trackCreatedFilter(), bson.EC.SubDocumentFromElements("next_digest",
+		bson.EC.Int64("$gt", 0), bson.EC.Int64("$lte", now))))
+}
+
+// GetMaxDelayWebhooks returns the webhooks that are sent when their counter or their max delay is reached
+func (db *Database) GetMaxDelayWebhooks() ([]WebhookInfo, error) {
+	return db.findWebhooks(bson.NewDocument(trackCreatedFilter(),
+		bson.EC.SubDocumentFromElements("max_delay", bson.EC.Int64("$gt", 0))))
+}
+
+// GetDueDeliveries returns up to limit pending deliveries in the outbox that are due at the time, the oldest first
+func (db *Database) GetDueDeliveries(now int64, limit int64) ([]DeliveryInfo, error) {
+	filter := bson.NewDocument(bson.EC.String("status", DeliveryPending),
+		bson.EC.SubDocumentFromElements("next_attempt", bson.EC.Int64("$lte", now)))
+	cursor, err := db.db.Collection("outbox").Find(context.Background(), filter,
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("next_attempt", 1))), findopt.Limit(limit))
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var deliveries []DeliveryInfo
+	for cursor.Next(context.Background()) {
+		delivery := DeliveryInfo{}
+		if err := cursor.Decode(&delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// RescheduleDeliveries makes the pending deliveries of the webhook due at the time
+func (db *Database) RescheduleDeliveries(webhookID string, time int64) error {
+	_, err := db.db.Collection("outbox").UpdateMany(context.Background(),
+		bson.NewDocument(bson.EC.String("webhook_id", webhookID), bson.EC.String("status", DeliveryPending)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Int64("next_attempt", time))))
+	return err
+}
+
+// GetDeliveriesByStatus returns the deliveries in the outbox with the status, the newest first
+func (db *Database) GetDeliveriesByStatus(status string) ([]DeliveryInfo, error) {
+	cursor, err := db.db.Collection("outbox").Find(context.Background(), bson.NewDocument(bson.EC.String("status", status)),
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("created_at", -1))))
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var deliveries []DeliveryInfo
+	for cursor.Next(context.Background()) {
+		delivery := DeliveryInfo{}
+		if err := cursor.Decode(&delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// GetDeliveryByID returns the delivery in the outbox and true/false wether it was found
+func (db *Database) GetDeliveryByID(id string) (DeliveryInfo, bool) {
+	delivery := DeliveryInfo{}
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return delivery, false
+	}
+	err = db.db.Collection("outbox").FindOne(context.Background(), bson.NewDocument(bson.EC.ObjectID("_id", objectID))).Decode(&delivery)
+	return delivery, err == nil
+}
+
+// UpdateDelivery replaces the stored delivery
+func (db *Database) UpdateDelivery(delivery DeliveryInfo) error {
+	_, err := db.db.Collection("outbox").ReplaceOne(context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", delivery.ID)), delivery)
+	return err
+}
+
+// GetDeliveryAttempts returns a page of the delivery log of the webhook, the newest first, and the number of
+// entries in the log of the webhook
+func (db *Database) GetDeliveryAttempts(webhookID string, skip int64, limit int64) ([]DeliveryAttempt, int64, error) {
+	col := db.db.Collection("delivery_log")
+	filter := bson.NewDocument(bson.EC.String("webhook_id", webhookID))
+	total, err := col.Count(context.Background(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := col.Find(context.Background(), filter, findopt.Sort(bson.NewDocument(bson.EC.Int32("time", -1))),
+		findopt.Skip(skip), findopt.Limit(limit))
+	if err != nil {
+		fmt.Println(err)
+		return nil, 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var attempts []DeliveryAttempt
+	for cursor.Next(context.Background()) {
+		attempt := DeliveryAttempt{}
+		if err := cursor.Decode(&attempt); err != nil {
+			return nil, 0, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, total, nil
+}
+
+// DeleteDeliveryAttemptsBefore removes the entries of the delivery log older than the time
+func (db *Database) DeleteDeliveryAttemptsBefore(time int64) error {
+	_, err := db.db.Collection("delivery_log").DeleteMany(context.Background(),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("time", bson.EC.Int64("$lt", time))))
+	return err
+}
+
 // DeleteAllTracksAndWebhooks clears the database. used for testing
 func (db *Database) DeleteAllTracksAndWebhooks() {
 	db.db.Collection("tracks").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("points").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("pilots").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("sites").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("waypoints").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("airspace_files").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("tasks").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("task_results").DeleteMany(context.Background(), bson.NewDocument())
 	db.db.Collection("webhooks").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("outbox").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("delivery_log").DeleteMany(context.Background(), bson.NewDocument())
+	db.db.Collection("heatmap").DeleteMany(context.Background(), bson.NewDocument())
 }
@@ -1,6 +1,7 @@
 package paragliding
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
@@ -146,7 +147,7 @@ func Test_GetAllTracks(t *testing.T) {
 		t.Error("coult not get list of tracks")
 	} else {
 		for i := 0; i < 3; i++ {
-			if resTracks[i] != newTracks[i] {
+			if !reflect.DeepEqual(resTracks[i], newTracks[i]) {
 				t.Error("ids do not match")
 			}
 		}
@@ -205,12 +206,12 @@ func Test_GetAllInvokeWebhooks(t *testing.T) {
 	db.Insert("webhooks", webhookNoInvoke)
 	db.Insert("webhooks", webhookInvoke)
 
-	webhooks, err := db.GetAllInvokeWebhooks()
+	webhooks, err := db.GetAllInvokeWebhooks(nil)
 	if err != nil {
 		t.Error("could not get webhooks")
 	} else if len(webhooks) != 1 {
 		t.Error("received wrong number of webhooks")
-	} else if webhooks[0] == webhookNoInvoke {
+	} else if webhooks[0].ID == webhookNoInvoke.ID {
 		t.Error("received the wrong webhook")
 	}
 }
@@ -234,7 +235,7 @@ func Test_ResetWebhookCounter(t *testing.T) {
 	whInfo, exists := db.GetWebhookByID(webhook.ID.Hex())
 	if !exists {
 		t.Error("didnt receive the webhook from database")
-	} else if whInfo != webhook {
+	} else if !reflect.DeepEqual(whInfo, webhook) {
 		t.Error("webhook didnt update correctly")
 	}
 }
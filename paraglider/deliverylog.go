@@ -0,0 +1,93 @@
+package paragliding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// DeliveryAttempt is an entry in the delivery log, one for every time a delivery is sent
+type DeliveryAttempt struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	DeliveryID  string            `bson:"delivery_id" json:"delivery_id"`
+	WebhookID   string            `bson:"webhook_id" json:"webhook_id"`
+	Time        int64             `bson:"time" json:"time"` // unix time in milliseconds
+	Attempt     int               `bson:"attempt" json:"attempt"`
+	PayloadHash string            `bson:"payload_hash" json:"payload_hash"` // sha256 of the payload
+	Status      int               `bson:"status" json:"status"`             // the http status, 0 if there was no response
+	Latency     int64             `bson:"latency" json:"latency"`           // milliseconds
+	Error       string            `bson:"error" json:"error,omitempty"`
+}
+
+// DeliveryLog is the response for GET /api/webhook/new_track/<id>/deliveries
+type DeliveryLog struct {
+	WebhookID string            `json:"webhook_id"`
+	Page      int               `json:"page"`
+	Limit     int               `json:"limit"`
+	Total     int64             `json:"total"`
+	Attempts  []DeliveryAttempt `json:"attempts"`
+}
+
+// HandlerGetWebhookDeliveries is the handler for GET /api/webhook/new_track/<id>/deliveries?page=1&limit=20.
+// it responds with a page of the delivery attempts of the webhook, the newest first
+func (whMgr *WebHookMgr) HandlerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	id := parts[len(parts)-2] // guaranteed to be valid cause of regex in server.go
+	if _, found := whMgr.DB.GetWebhookByID(id); !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	page, limit, ok := parsePaging(w, r, 20)
+	if !ok {
+		return
+	}
+	attempts, total, err := whMgr.DB.GetDeliveryAttempts(id, int64((page-1)*limit), int64(limit))
+	if err != nil {
+		http.Error(w, "could not get the deliveries", http.StatusInternalServerError)
+		return
+	}
+	if attempts == nil {
+		attempts = []DeliveryAttempt{}
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(DeliveryLog{WebhookID: id, Page: page, Limit: limit, Total: total, Attempts: attempts})
+}
+
+// parsePaging returns the page (from 1) and limit parameters, at most 100 per page. it responds with
+// 400 and returns false if they are invalid
+func parsePaging(w http.ResponseWriter, r *http.Request, defaultLimit int) (int, int, bool) {
+	page, limit := 1, defaultLimit
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"page", &page}, {"limit", &limit}} {
+		s := r.URL.Query().Get(param.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 {
+			http.Error(w, param.name+" is not a positive number", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		*param.value = v
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return page, limit, true
+}
+
+// newDeliveryAttempt creates the log entry of an attempt of the delivery
+func newDeliveryAttempt(delivery DeliveryInfo, start time.Time, latency time.Duration) DeliveryAttempt {
+	hash := sha256.Sum256([]byte(delivery.Payload))
+	return DeliveryAttempt{ID: objectid.New(), DeliveryID: delivery.ID.Hex(), WebhookID: delivery.WebhookID,
+		Time: start.UnixNano() / int64(time.Millisecond), Attempt: delivery.Attempts, PayloadHash: hex.EncodeToString(hash[:]),
+		Status: delivery.LastStatus, Latency: int64(latency / time.Millisecond), Error: delivery.LastError}
+}
@@ -0,0 +1,49 @@
+package paragliding
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_ParsePaging(t *testing.T) {
+	tests := []struct {
+		query       string
+		page, limit int
+		ok          bool
+	}{
+		{"", 1, 20, true},
+		{"page=3&limit=5", 3, 5, true},
+		{"limit=500", 1, 100, true},
+		{"page=0", 0, 0, false},
+		{"limit=abc", 0, 0, false},
+	}
+	for _, test := range tests {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/paragliding/api/webhook/new_track/abc/deliveries?"+test.query, nil)
+		page, limit, ok := parsePaging(res, req, 20)
+		if page != test.page || limit != test.limit || ok != test.ok {
+			t.Errorf("expected %d %d %v for %q, got %d %d %v", test.page, test.limit, test.ok, test.query, page, limit, ok)
+		}
+		if !ok && res.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %q, got %d", test.query, res.Code)
+		}
+	}
+}
+
+func Test_NewDeliveryAttempt(t *testing.T) {
+	delivery := DeliveryInfo{ID: objectid.New(), WebhookID: "abc", Payload: "hello", Attempts: 2, LastStatus: 503,
+		LastError: "the webhook responded with 503 Service Unavailable"}
+	start := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	attempt := newDeliveryAttempt(delivery, start, 1500*time.Millisecond)
+	if attempt.DeliveryID != delivery.ID.Hex() || attempt.WebhookID != "abc" || attempt.Attempt != 2 || attempt.Status != 503 ||
+		attempt.Latency != 1500 || attempt.Time != start.UnixNano()/int64(time.Millisecond) || attempt.Error != delivery.LastError {
+		t.Errorf("wrong attempt: %+v", attempt)
+	}
+	if attempt.PayloadHash != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("wrong payload hash %s", attempt.PayloadHash)
+	}
+}
@@ -0,0 +1,243 @@
+package paragliding
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	igc "github.com/marni/goigc"
+)
+
+// the ingest modes. strict rejects a file with any diagnostic, lenient drops the broken records and stores the
+// diagnostics on the track, and only rejects files that can not be used at all
+const (
+	IngestStrict  = "strict"
+	IngestLenient = "lenient"
+)
+
+// the severities of a diagnostic
+const (
+	SeverityFatal   = "fatal"   // the file can not be used
+	SeverityError   = "error"   // the record can not be used and is dropped in lenient mode
+	SeverityWarning = "warning" // the record is used, but the data looks wrong
+)
+
+// the limits of the checks of the fixes
+const (
+	diagMaxGap = 60 // seconds between two fixes before it is a time jump
+)
+
+// Diagnostic is a problem found in an igc file. line is 1 based, and 0 for problems with the whole file
+type Diagnostic struct {
+	Line     int    `bson:"line" json:"line,omitempty"`
+	Record   string `bson:"record" json:"record,omitempty"` // the record type, eg. B
+	Code     string `bson:"code" json:"code"`
+	Severity string `bson:"severity" json:"severity"`
+	Message  string `bson:"message" json:"message"`
+}
+
+// ParseIGC checks the igc file and parses it. it returns false if the file is rejected in the mode,
+// the diagnostics are returned in both cases
+func ParseIGC(content []byte, mode string) (igc.Track, []Diagnostic, bool) {
+	diagnostics := DiagnoseIGC(content)
+	for _, d := range diagnostics {
+		if d.Severity == SeverityFatal || mode == IngestStrict {
+			return igc.Track{}, diagnostics, false
+		}
+	}
+	track, err := parseIGCSafely(string(withoutErrorLines(content, diagnostics)))
+	if err != nil {
+		// something the checks do not know about
+		diagnostics = append(diagnostics, Diagnostic{Code: "parse_failed", Severity: SeverityFatal, Message: err.Error()})
+		return igc.Track{}, diagnostics, false
+	}
+	return track, diagnostics, true
+}
+
+// parseIGCSafely parses the file, turning a panic in the parser into an error
+func parseIGCSafely(content string) (track igc.Track, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the parser failed: %v", r)
+		}
+	}()
+	return igc.Parse(content)
+}
+
+// DiagnoseIGC checks the records of the igc file. it finds unknown and malformed records, files without fixes,
+// fixes going back in time, time jumps, gps dropouts (fixes without a 3d fix) and fixes at 0,0
+func DiagnoseIGC(content []byte) []Diagnostic {
+	var diagnostics []Diagnostic
+	add := func(line int, record string, code string, severity string, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Line: line, Record: record, Code: code, Severity: severity,
+			Message: fmt.Sprintf(format, args...)})
+	}
+	hasA, fixes, extensionEnd := false, 0, 35
+	lastTime, dropoutStart, dropoutFixes := -1, 0, 0
+	endDropout := func() {
+		if dropoutFixes > 0 {
+			add(dropoutStart, "B", "gps_dropout", SeverityWarning, "%d fixes without a valid gps fix", dropoutFixes)
+		}
+		dropoutFixes = 0
+	}
+	for i, raw := range bytes.Split(content, []byte("\n")) {
+		n, line := i+1, strings.TrimSpace(string(raw))
+		if line == "" {
+			continue
+		}
+		record := line[:1]
+		switch line[0] {
+		case 'A':
+			hasA = true
+		case 'C', 'D', 'E', 'F', 'G', 'H', 'J', 'K', 'L':
+		case 'I':
+			end, err := extensionsEnd(line)
+			if err != nil {
+				add(n, record, "malformed_record", SeverityError, "%s", err)
+				continue
+			}
+			extensionEnd = end
+		case 'B':
+			fix, err := parseDiagFix(line, extensionEnd)
+			if err != nil {
+				add(n, record, "malformed_fix", SeverityError, "%s", err)
+				continue
+			}
+			fixes++
+			if lastTime >= 0 {
+				gap := fix.seconds - lastTime
+				if gap < -12*3600 {
+					gap += 24 * 3600 // the flight passed midnight utc
+				}
+				if gap < 0 {
+					add(n, record, "time_backwards", SeverityWarning, "the fix is %d seconds before the previous fix", -gap)
+				} else if gap > diagMaxGap {
+					add(n, record, "time_jump", SeverityWarning, "%d seconds since the previous fix", gap)
+				}
+			}
+			lastTime = fix.seconds
+			if fix.zero {
+				add(n, record, "zero_fix", SeverityWarning, "the fix is at 0,0")
+			}
+			if fix.valid {
+				endDropout()
+			} else {
+				if dropoutFixes == 0 {
+					dropoutStart = n
+				}
+				dropoutFixes++
+			}
+		default:
+			add(n, "", "unknown_record", SeverityError, "unknown record type %q", record)
+		}
+	}
+	endDropout()
+
+	if !hasA && fixes == 0 {
+		return []Diagnostic{{Code: "not_igc", Severity: SeverityFatal, Message: "the file is not an igc file"}}
+	}
+	if !hasA {
+		add(0, "A", "missing_header", SeverityWarning, "the file has no A record")
+	}
+	if fixes == 0 {
+		add(0, "B", "no_fixes", SeverityFatal, "the file has no valid fixes")
+	}
+	return diagnostics
+}
+
+// diagFix is what the checks need to know about a fix
+type diagFix struct {
+	seconds int // since midnight utc
+	valid   bool
+	zero    bool
+}
+
+// parseDiagFix checks the B record, eg. B1101355206343N00006198WA0058700558
+func parseDiagFix(line string, extensionEnd int) (diagFix, error) {
+	if len(line) < 35 {
+		return diagFix{}, fmt.Errorf("the fix is %d characters, expected at least 35", len(line))
+	}
+	if len(line) < extensionEnd {
+		return diagFix{}, fmt.Errorf("the fix is %d characters, the I record extensions need %d", len(line), extensionEnd)
+	}
+	digits := func(s string) bool {
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+		return true
+	}
+	if !digits(line[1:7]) || !digits(line[7:14]) || !digits(line[15:23]) {
+		return diagFix{}, fmt.Errorf("the time or the position is not a number")
+	}
+	h, m, s := atoi(line[1:3]), atoi(line[3:5]), atoi(line[5:7])
+	if h > 23 || m > 59 || s > 59 {
+		return diagFix{}, fmt.Errorf("invalid time %s", line[1:7])
+	}
+	if atoi(line[7:9]) > 90 || atoi(line[9:11]) > 59 || atoi(line[15:18]) > 180 || atoi(line[18:20]) > 59 {
+		return diagFix{}, fmt.Errorf("invalid position %s", line[7:24])
+	}
+	if (line[14] != 'N' && line[14] != 'S') || (line[23] != 'E' && line[23] != 'W') {
+		return diagFix{}, fmt.Errorf("invalid hemisphere in %s", line[7:24])
+	}
+	if line[24] != 'A' && line[24] != 'V' {
+		return diagFix{}, fmt.Errorf("invalid fix validity %q", line[24])
+	}
+	for _, altitude := range []string{line[25:30], line[30:35]} {
+		if _, err := strconv.ParseInt(altitude, 10, 64); err != nil {
+			return diagFix{}, fmt.Errorf("invalid altitude %q", altitude)
+		}
+	}
+	return diagFix{seconds: h*3600 + m*60 + s, valid: line[24] == 'A',
+		zero: strings.Trim(line[7:14]+line[15:23], "0") == ""}, nil
+}
+
+// extensionsEnd returns the last byte of the B record extensions in the I record, eg. I023638FXA3940SIU
+func extensionsEnd(line string) (int, error) {
+	if len(line) < 3 {
+		return 0, fmt.Errorf("the I record is too short")
+	}
+	count, err := strconv.Atoi(line[1:3])
+	if err != nil || len(line) < 3+count*7 {
+		return 0, fmt.Errorf("invalid number of extensions in the I record")
+	}
+	end := 35
+	for i := 0; i < count; i++ {
+		start, err1 := strconv.Atoi(line[3+i*7 : 5+i*7])
+		last, err2 := strconv.Atoi(line[5+i*7 : 7+i*7])
+		if err1 != nil || err2 != nil || start < 1 || last < start {
+			return 0, fmt.Errorf("invalid extension %s in the I record", line[3+i*7:10+i*7])
+		}
+		if last > end {
+			end = last
+		}
+	}
+	return end, nil
+}
+
+// withoutErrorLines removes the lines with errors from the file
+func withoutErrorLines(content []byte, diagnostics []Diagnostic) []byte {
+	broken := map[int]bool{}
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError && d.Line > 0 {
+			broken[d.Line] = true
+		}
+	}
+	if len(broken) == 0 {
+		return content
+	}
+	var lines [][]byte
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if !broken[i+1] {
+			lines = append(lines, line)
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
@@ -0,0 +1,61 @@
+package paragliding
+
+import (
+	"strings"
+	"testing"
+)
+
+const diagHeader = "AXCSAAA\nHFDTE020518\n"
+
+func diagCodes(diagnostics []Diagnostic) string {
+	var codes []string
+	for _, d := range diagnostics {
+		codes = append(codes, d.Code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func Test_DiagnoseIGC(t *testing.T) {
+	tests := []struct {
+		content string
+		codes   string
+		line    int
+	}{
+		{diagHeader + "B1101355206343N00006198WA0058700558\nB1101365206343N00006198WA0058700558\n", "", 0},
+		{"<html>not found</html>", "not_igc", 0},
+		{diagHeader, "no_fixes", 0},
+		{diagHeader + "B1101355206343N00006198WA0058700558\nB11013X5206343N00006198WA0058700558\n", "malformed_fix", 4},
+		{diagHeader + "B1101355206343N00006198WA0058700558\nX\n", "unknown_record", 4},
+		{diagHeader + "B1101355206343N00006198WA0058700558\nB1103355206343N00006198WA0058700558\n", "time_jump", 4},
+		{diagHeader + "B1101355206343N00006198WA0058700558\nB1101305206343N00006198WA0058700558\n", "time_backwards", 4},
+		{diagHeader + "B2359595206343N00006198WA0058700558\nB0000005206343N00006198WA0058700558\n", "", 0},
+		{diagHeader + "B1101355206343N00006198WA0058700558\nB1101365206343N00006198WV0058700558\n" +
+			"B1101375206343N00006198WV0058700558\nB1101385206343N00006198WA0058700558\n", "gps_dropout", 4},
+		{diagHeader + "B1101350000000N00000000EA0058700558\n", "zero_fix", 3},
+		{"B1101355206343N00006198WA0058700558\n", "missing_header", 0},
+		{diagHeader + "I013638FXA\nB1101355206343N00006198WA0058700558\n", "malformed_fix,no_fixes", 4},
+	}
+	for _, test := range tests {
+		diagnostics := DiagnoseIGC([]byte(test.content))
+		if codes := diagCodes(diagnostics); codes != test.codes {
+			t.Errorf("expected %q, got %q for %q", test.codes, codes, test.content)
+		} else if len(diagnostics) > 0 && diagnostics[0].Line != test.line {
+			t.Errorf("expected line %d, got %d for %q", test.line, diagnostics[0].Line, test.content)
+		}
+	}
+}
+
+func Test_ParseIGC(t *testing.T) {
+	content := []byte(diagHeader + "B1101355206343N00006198WA0058700558\nB11013X5206343N00006198WA0058700558\n" +
+		"B1101375206343N00006198WA0058700558\n")
+	if _, diagnostics, ok := ParseIGC(content, IngestStrict); ok || len(diagnostics) != 1 {
+		t.Errorf("strict mode should reject the file: %+v", diagnostics)
+	}
+	track, diagnostics, ok := ParseIGC(content, IngestLenient)
+	if !ok || len(track.Points) != 2 || diagCodes(diagnostics) != "malformed_fix" {
+		t.Errorf("lenient mode should drop the broken fix: %d points, %+v", len(track.Points), diagnostics)
+	}
+	if _, diagnostics, ok := ParseIGC([]byte(diagHeader), IngestLenient); ok || diagnostics[0].Severity != SeverityFatal {
+		t.Errorf("a file without fixes should be rejected: %+v", diagnostics)
+	}
+}
@@ -0,0 +1,115 @@
+package paragliding
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the most tracks in a digest or in the tracks sent after the max delay, the rest are in the next one
+const maxDigestTracks = 100
+
+// DigestSchedule is when the digests of a webhook are sent, either "hourly" on the hour or "daily HH:MM" in the
+// timezone of the webhook
+type DigestSchedule struct {
+	Hourly   bool
+	Hour     int
+	Minute   int
+	Location *time.Location
+}
+
+// ParseDigestSchedule parses the schedule and the timezone of a webhook. the timezone is an IANA name like
+// Europe/Oslo, UTC if it is empty
+func ParseDigestSchedule(schedule string, timezone string) (DigestSchedule, error) {
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return DigestSchedule{}, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+	fields := strings.Fields(strings.ToLower(schedule))
+	switch {
+	case len(fields) == 1 && fields[0] == "hourly":
+		return DigestSchedule{Hourly: true, Location: location}, nil
+	case len(fields) == 2 && fields[0] == "daily":
+		at, err := time.Parse("15:04", fields[1])
+		if err != nil {
+			return DigestSchedule{}, fmt.Errorf("invalid time %q, must be HH:MM", fields[1])
+		}
+		return DigestSchedule{Hour: at.Hour(), Minute: at.Minute(), Location: location}, nil
+	}
+	return DigestSchedule{}, errors.New("the schedule must be hourly or daily HH:MM")
+}
+
+// Next returns the first time of the schedule after the time
+func (schedule DigestSchedule) Next(after time.Time) time.Time {
+	t := after.In(schedule.Location)
+	if schedule.Hourly {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, schedule.Location)
+	}
+	next := time.Date(t.Year(), t.Month(), t.Day(), schedule.Hour, schedule.Minute, 0, 0, schedule.Location)
+	if !next.After(after) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, schedule.Hour, schedule.Minute, 0, 0, schedule.Location)
+	}
+	return next
+}
+
+// nextDigest returns the unix time in milliseconds of the next digest of the webhook after the time, 0 if it has
+// no schedule
+func nextDigest(webhook WebhookInfo, after time.Time) int64 {
+	if webhook.Schedule == "" {
+		return 0
+	}
+	schedule, err := ParseDigestSchedule(webhook.Schedule, webhook.Timezone)
+	if err != nil { // checked when the webhook was registered
+		return 0
+	}
+	return schedule.Next(after).UnixNano() / int64(time.Millisecond)
+}
+
+// StartScheduler checks for due digests and for tracks that waited longer than the max delay of their webhook
+// every interval. the time of the next digest is stored with the webhook, so a digest that was due while the
+// server was down is sent when it starts, once
+func (whMgr *WebHookMgr) StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			whMgr.runScheduler(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runScheduler sends the digests that are due and the tracks that waited too long
+func (whMgr *WebHookMgr) runScheduler(now time.Time) {
+	ms := now.UnixNano() / int64(time.Millisecond)
+	digests, err := whMgr.DB.GetDueDigests(ms)
+	if err != nil {
+		return
+	}
+	for _, v := range digests {
+		// the next digest is claimed first, so a digest is only sent by one scheduler
+		if !whMgr.DB.ClaimDigest(v, nextDigest(v, now)) {
+			continue
+		}
+		whMgr.sendNewTracks(v, maxDigestTracks)
+	}
+
+	webhooks, err := whMgr.DB.GetMaxDelayWebhooks()
+	if err != nil {
+		return
+	}
+	for _, v := range webhooks {
+		tickerResp, err := whMgr.Ticker.GetTickerFiltered(v.LatestTimestamp, whMgr.trackMatcher(v), 1)
+		if err != nil || len(tickerResp.TrackIDs) == 0 {
+			continue
+		}
+		// the oldest track that was not sent has waited long enough
+		if ms-tickerResp.TStart >= v.MaxDelay*1000 {
+			whMgr.sendNewTracks(v, maxDigestTracks)
+		}
+	}
+}
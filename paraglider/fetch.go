@@ -0,0 +1,220 @@
+package paragliding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the kinds of fetch errors
+const (
+	FetchInvalidURL     = "invalid_url"
+	FetchHostNotAllowed = "host_not_allowed"
+	FetchBlockedAddress = "blocked_address"
+	FetchTimeout        = "timeout"
+	FetchTooLarge       = "too_large"
+	FetchTooManyHops    = "too_many_redirects"
+	FetchContentType    = "content_type"
+	FetchNotFound       = "not_found"
+	FetchUpstream       = "upstream_error"
+	FetchNetwork        = "network_error"
+)
+
+// the http status a fetch error is reported with
+var fetchErrorStatus = map[string]int{
+	FetchInvalidURL:     http.StatusBadRequest,
+	FetchHostNotAllowed: http.StatusForbidden,
+	FetchBlockedAddress: http.StatusForbidden,
+	FetchTimeout:        http.StatusGatewayTimeout,
+	FetchTooLarge:       http.StatusRequestEntityTooLarge,
+	FetchTooManyHops:    http.StatusBadGateway,
+	FetchContentType:    http.StatusUnsupportedMediaType,
+	FetchNotFound:       http.StatusNotFound,
+	FetchUpstream:       http.StatusBadGateway,
+	FetchNetwork:        http.StatusBadGateway,
+}
+
+// FetchError is the error of a failed fetch
+type FetchError struct {
+	Kind    string
+	Message string
+}
+
+func (err *FetchError) Error() string {
+	return err.Message
+}
+
+// Status returns the http status the error is reported with
+func (err *FetchError) Status() int {
+	return fetchErrorStatus[err.Kind]
+}
+
+// the content types an igc file is served with. files without a content type are accepted
+var igcContentTypes = []string{"text/plain", "application/octet-stream", "application/x-igc", "text/x-igc",
+	"application/vnd.fai.igc"}
+
+// the ranges that are blocked by default on top of the loopback, private, link local and unspecified addresses
+var blockedNetworks = []string{"100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15"}
+
+// Fetcher fetches the remote files of the ingestion. it only fetches http and https urls, stops after
+// MaxRedirects redirects and MaxBytes bytes, and refuses to connect to private and loopback addresses unless
+// AllowPrivate is set. the addresses are checked when connecting, so a host can not resolve to a public address
+// when checked and a private one when fetched
+type Fetcher struct {
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration // for the response, from the connection to the last byte
+	MaxBytes       int64
+	MaxRedirects   int
+	AllowedHosts   []string // if set only these hosts are fetched from. *.example.com allows the subdomains
+	AllowPrivate   bool
+	ContentTypes   []string // if set the content type of the response must be one of these
+}
+
+// NewFetcher creates a fetcher for igc files with the default limits
+func NewFetcher() *Fetcher {
+	return &Fetcher{ConnectTimeout: 5 * time.Second, ReadTimeout: 20 * time.Second, MaxBytes: 10 << 20, MaxRedirects: 5,
+		ContentTypes: igcContentTypes}
+}
+
+// Fetch returns the body of the url
+func (fetcher *Fetcher) Fetch(rawURL string) ([]byte, error) {
+	if err := fetcher.checkURL(rawURL); err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: fetcher.ConnectTimeout, Control: fetcher.checkAddress}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext, ResponseHeaderTimeout: fetcher.ReadTimeout},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > fetcher.MaxRedirects {
+				return &FetchError{FetchTooManyHops, fmt.Sprintf("stopped after %d redirects", fetcher.MaxRedirects)}
+			}
+			return fetcher.checkURL(req.URL.String())
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetcher.ConnectTimeout+fetcher.ReadTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, &FetchError{FetchInvalidURL, "invalid url: " + err.Error()}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, &FetchError{FetchNotFound, "the file was not found"}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, &FetchError{FetchUpstream, "the server responded with " + resp.Status}
+	}
+	if err := fetcher.checkContentType(resp.Header.Get("content-type")); err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > fetcher.MaxBytes {
+		return nil, fetcher.tooLarge()
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, fetcher.MaxBytes+1))
+	if err != nil {
+		return nil, fetchError(err)
+	}
+	if int64(len(content)) > fetcher.MaxBytes {
+		return nil, fetcher.tooLarge()
+	}
+	return content, nil
+}
+
+func (fetcher *Fetcher) tooLarge() error {
+	return &FetchError{FetchTooLarge, fmt.Sprintf("the file is larger than %d bytes", fetcher.MaxBytes)}
+}
+
+// checkURL checks the scheme and the host of the url
+func (fetcher *Fetcher) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return &FetchError{FetchInvalidURL, "invalid url: " + rawURL}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &FetchError{FetchInvalidURL, "the url must be http or https"}
+	}
+	if len(fetcher.AllowedHosts) > 0 && !hostAllowed(u.Hostname(), fetcher.AllowedHosts) {
+		return &FetchError{FetchHostNotAllowed, "fetching from " + u.Hostname() + " is not allowed"}
+	}
+	return nil
+}
+
+// checkAddress is called with the resolved address before connecting
+func (fetcher *Fetcher) checkAddress(network string, address string, conn syscall.RawConn) error {
+	if fetcher.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+		return &FetchError{FetchBlockedAddress, "fetching from the address " + host + " is not allowed"}
+	}
+	return nil
+}
+
+func (fetcher *Fetcher) checkContentType(contentType string) error {
+	if contentType == "" || len(fetcher.ContentTypes) == 0 {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && containsString(fetcher.ContentTypes, strings.ToLower(mediaType)) {
+		return nil
+	}
+	return &FetchError{FetchContentType, "unexpected content type " + contentType}
+}
+
+// blockedIP returns true for the addresses that are not on the public internet
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, cidr := range blockedNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed returns true if the host is in the list. *.example.com matches the subdomains of example.com
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if host == pattern || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchError turns the error of the http client into a fetch error
+func fetchError(err error) error {
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &FetchError{FetchTimeout, "timed out fetching the file"}
+	}
+	return &FetchError{FetchNetwork, "could not fetch the file: " + err.Error()}
+}
@@ -0,0 +1,100 @@
+package paragliding
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FetcherFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/track.igc":
+			w.Header().Set("content-type", "text/plain; charset=utf-8")
+			w.Write([]byte("AXCSAAA"))
+		case "/page":
+			w.Header().Set("content-type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/large.igc":
+			w.Write([]byte(strings.Repeat("B", 200)))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/redirect":
+			http.Redirect(w, r, "/track.igc", http.StatusFound)
+		case "/slow.igc":
+			time.Sleep(200 * time.Millisecond)
+		case "/error":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.AllowPrivate = true
+	fetcher.MaxBytes = 100
+	fetcher.ReadTimeout = 100 * time.Millisecond
+	for _, path := range []string{"/track.igc", "/redirect"} {
+		if content, err := fetcher.Fetch(server.URL + path); err != nil || string(content) != "AXCSAAA" {
+			t.Errorf("could not fetch %s: %v", path, err)
+		}
+	}
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{server.URL + "/missing.igc", http.StatusNotFound},
+		{server.URL + "/page", http.StatusUnsupportedMediaType},
+		{server.URL + "/large.igc", http.StatusRequestEntityTooLarge},
+		{server.URL + "/loop", http.StatusBadGateway},
+		{server.URL + "/slow.igc", http.StatusGatewayTimeout},
+		{server.URL + "/error", http.StatusBadGateway},
+		{"file:///etc/passwd", http.StatusBadRequest},
+		{"/etc/passwd", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		_, err := fetcher.Fetch(test.url)
+		if fetchErr, ok := err.(*FetchError); !ok || fetchErr.Status() != test.status {
+			t.Errorf("expected %d for %s, got %v", test.status, test.url, err)
+		}
+	}
+
+	// the test server is on the loopback address, which is blocked by default
+	_, err := NewFetcher().Fetch(server.URL + "/track.igc")
+	if fetchErr, ok := err.(*FetchError); !ok || fetchErr.Kind != FetchBlockedAddress {
+		t.Errorf("expected the loopback address to be blocked, got %v", err)
+	}
+	fetcher.AllowedHosts = []string{"*.example.com"}
+	_, err = fetcher.Fetch(server.URL + "/track.igc")
+	if fetchErr, ok := err.(*FetchError); !ok || fetchErr.Kind != FetchHostNotAllowed {
+		t.Errorf("expected the host to not be allowed, got %v", err)
+	}
+}
+
+func Test_BlockedIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "172.16.5.5", "169.254.169.254", "::1",
+		"fd00::1", "0.0.0.0", "100.64.0.1"} {
+		if !blockedIP(net.ParseIP(ip)) {
+			t.Errorf("%s should be blocked", ip)
+		}
+	}
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		if blockedIP(net.ParseIP(ip)) {
+			t.Errorf("%s should not be blocked", ip)
+		}
+	}
+}
+
+func Test_HostAllowed(t *testing.T) {
+	allowed := []string{"xcontest.org", "*.leonardo.example.com"}
+	for host, want := range map[string]bool{"xcontest.org": true, "XContest.org.": true, "www.xcontest.org": false,
+		"a.leonardo.example.com": true, "leonardo.example.com": false, "evilxcontest.org": false} {
+		if hostAllowed(host, allowed) != want {
+			t.Errorf("expected %v for %s", want, host)
+		}
+	}
+}
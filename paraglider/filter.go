@@ -0,0 +1,410 @@
+package paragliding
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxFilterLength = 500
+
+// TrackFilter is a filter expression over the tracks of a webhook, eg.
+//
+//	distance > 50 and site in (Voss, Hangur, "Bømlo")
+//	class = EN-C or (pilot ~ nordmann and not validity = invalid)
+//	bbox(5.0, 60.0, 7.5, 61.5)
+//
+// the fields are pilot, glider, class (the glider class), distance (km), site and validity. the operators are
+// = != < <= > >= for distance, = != and ~ (contains) for the text fields, and in (a, b, ...) for both. text is
+// compared without case, and values with spaces are quoted. bbox(minLon, minLat, maxLon, maxLat) matches the
+// tracks that took off in the box. conditions are combined with and, or, not and parentheses
+type TrackFilter struct {
+	root    filterNode
+	takeoff bool // the filter needs the takeoff of the tracks
+}
+
+type filterNode interface {
+	match(track TrackSummary, takeoff *TrackPoint) bool
+}
+
+// ParseTrackFilter parses a filter expression
+func ParseTrackFilter(expr string) (*TrackFilter, error) {
+	if len(expr) > maxFilterLength {
+		return nil, errors.New("the filter is longer than " + strconv.Itoa(maxFilterLength) + " characters")
+	}
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &TrackFilter{root: root, takeoff: p.takeoff}, nil
+}
+
+// NeedsTakeoff returns true if the filter has a bbox, so the takeoff of the tracks must be passed to Match
+func (filter *TrackFilter) NeedsTakeoff() bool {
+	return filter.takeoff
+}
+
+// Match returns true if the track matches the filter. a bbox does not match if takeoff is nil
+func (filter *TrackFilter) Match(track TrackSummary, takeoff *TrackPoint) bool {
+	return filter.root.match(track, takeoff)
+}
+
+type filterAnd []filterNode
+
+func (nodes filterAnd) match(track TrackSummary, takeoff *TrackPoint) bool {
+	for _, node := range nodes {
+		if !node.match(track, takeoff) {
+			return false
+		}
+	}
+	return true
+}
+
+type filterOr []filterNode
+
+func (nodes filterOr) match(track TrackSummary, takeoff *TrackPoint) bool {
+	for _, node := range nodes {
+		if node.match(track, takeoff) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterNot struct {
+	node filterNode
+}
+
+func (not filterNot) match(track TrackSummary, takeoff *TrackPoint) bool {
+	return !not.node.match(track, takeoff)
+}
+
+type filterBBox struct {
+	minLon, minLat, maxLon, maxLat float64
+}
+
+func (box filterBBox) match(track TrackSummary, takeoff *TrackPoint) bool {
+	return takeoff != nil && takeoff.Lon >= box.minLon && takeoff.Lon <= box.maxLon &&
+		takeoff.Lat >= box.minLat && takeoff.Lat <= box.maxLat
+}
+
+// filterCompare compares a field with the values, there is more than one value for in
+type filterCompare struct {
+	field   string
+	op      string
+	values  []string
+	numbers []float64 // the values of distance
+}
+
+// the fields of the filters and wether they are numbers
+var filterFields = map[string]bool{"pilot": false, "glider": false, "class": false, "distance": true, "site": false,
+	"validity": false}
+
+func (cmp filterCompare) match(track TrackSummary, takeoff *TrackPoint) bool {
+	if cmp.field == "distance" {
+		for _, n := range cmp.numbers {
+			switch {
+			case cmp.op == "=" && track.Distance == n, cmp.op == "<" && track.Distance < n,
+				cmp.op == "<=" && track.Distance <= n, cmp.op == ">" && track.Distance > n,
+				cmp.op == ">=" && track.Distance >= n:
+				return true
+			case cmp.op == "!=":
+				return track.Distance != n
+			}
+		}
+		return false
+	}
+
+	var value string
+	switch cmp.field {
+	case "pilot":
+		value = track.Pilot
+	case "glider":
+		value = track.Glider
+	case "class":
+		value = track.GliderClass
+	case "site":
+		value = track.Site
+	case "validity":
+		value = track.Validity
+	}
+	for _, v := range cmp.values {
+		switch cmp.op {
+		case "=":
+			if strings.EqualFold(value, v) {
+				return true
+			}
+		case "!=":
+			return !strings.EqualFold(value, v)
+		case "~":
+			if strings.Contains(strings.ToLower(value), strings.ToLower(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type filterToken struct {
+	kind string // word, string or the punctuation itself
+	text string
+}
+
+// lexFilter splits the expression into words, quoted strings, operators and punctuation
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, filterToken{string(r), string(r)})
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, filterToken{"op", string(r)})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, errors.New("expected != after !")
+			}
+			tokens = append(tokens, filterToken{"op", op})
+			i += len(op)
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("the string is not closed")
+			}
+			tokens = append(tokens, filterToken{"string", string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.+", r):
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) ||
+				strings.ContainsRune("-_.+", runes[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{"word", string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	takeoff bool
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return filterToken{kind: "end"}
+}
+
+// keyword returns true and moves on if the next token is the keyword
+func (p *filterParser) keyword(word string) bool {
+	if token := p.peek(); token.kind == "word" && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind string) (filterToken, error) {
+	token := p.peek()
+	if token.kind != kind {
+		if token.kind == "end" {
+			return token, fmt.Errorf("expected %s at the end of the filter", kind)
+		}
+		return token, fmt.Errorf("expected %s, got %q", kind, token.text)
+	}
+	p.pos++
+	return token, nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	var nodes filterOr
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.keyword("or") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	var nodes filterAnd
+	for {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if !p.keyword("and") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.keyword("not") {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+	if p.peek().kind == "(" {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *filterParser) parseCondition() (filterNode, error) {
+	token, err := p.expect("word")
+	if err != nil {
+		return nil, err
+	}
+	field := strings.ToLower(token.text)
+	if field == "bbox" {
+		return p.parseBBox()
+	}
+	number, found := filterFields[field]
+	if !found {
+		return nil, fmt.Errorf("unknown field %q, must be pilot, glider, class, distance, site, validity or bbox", token.text)
+	}
+
+	cmp := filterCompare{field: field}
+	if p.keyword("in") {
+		cmp.op = "="
+		if cmp.values, err = p.parseList(); err != nil {
+			return nil, err
+		}
+	} else {
+		op, err := p.expect("op")
+		if err != nil {
+			return nil, err
+		}
+		cmp.op = op.text
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		cmp.values = []string{value}
+	}
+	if !number && cmp.op != "=" && cmp.op != "!=" && cmp.op != "~" {
+		return nil, fmt.Errorf("%s can not be compared with %s", field, cmp.op)
+	}
+	if number {
+		if cmp.op == "~" {
+			return nil, errors.New("distance can not be compared with ~")
+		}
+		for _, value := range cmp.values {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("distance must be compared with a number, got %q", value)
+			}
+			cmp.numbers = append(cmp.numbers, n)
+		}
+	}
+	return cmp, nil
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	token := p.peek()
+	if token.kind != "word" && token.kind != "string" {
+		if token.kind == "end" {
+			return "", errors.New("expected a value at the end of the filter")
+		}
+		return "", fmt.Errorf("expected a value, got %q", token.text)
+	}
+	p.pos++
+	return token.text, nil
+}
+
+// parseList parses (a, b, ...)
+func (p *filterParser) parseList() ([]string, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.peek().kind != "," {
+			break
+		}
+		p.pos++
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseBBox parses the (minLon, minLat, maxLon, maxLat) of a bbox
+func (p *filterParser) parseBBox() (filterNode, error) {
+	values, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 4 {
+		return nil, errors.New("bbox must be bbox(minLon, minLat, maxLon, maxLat)")
+	}
+	var n [4]float64
+	for i, value := range values {
+		if n[i], err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("the bbox must be numbers, got %q", value)
+		}
+	}
+	if n[0] > n[2] || n[1] > n[3] {
+		return nil, errors.New("the bbox must be bbox(minLon, minLat, maxLon, maxLat)")
+	}
+	p.takeoff = true
+	return filterBBox{n[0], n[1], n[2], n[3]}, nil
+}
@@ -0,0 +1,54 @@
+package paragliding
+
+import "testing"
+
+func Test_TrackFilter(t *testing.T) {
+	voss := TrackSummary{Pilot: "Ola Nordmann", Glider: "Ozone Rush 5", GliderClass: "EN-B", Distance: 62.5, Site: "Voss",
+		Validity: ValidityVerified}
+	bomlo := TrackSummary{Pilot: "Kari Nordmann", Glider: "Gin Bonanza", GliderClass: "EN-C", Distance: 30, Site: "Bømlo",
+		Validity: ValidityUnsigned}
+	takeoff := &TrackPoint{Lat: 60.63, Lon: 6.42}
+
+	cases := []struct {
+		expr        string
+		voss, bomlo bool
+	}{
+		{`distance > 50 and site in (Voss, Hangur, "bømlo")`, true, false},
+		{`site in (Voss, Hangur, "bømlo")`, true, true},
+		{`class = EN-C or (pilot ~ ola and not validity = invalid)`, true, true},
+		{`pilot ~ kari`, false, true},
+		{`distance <= 30`, false, true},
+		{`distance in (30, 40)`, false, true},
+		{`glider != "Gin Bonanza"`, true, false},
+		{`NOT validity = verified`, false, true},
+	}
+	for _, c := range cases {
+		filter, err := ParseTrackFilter(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if filter.Match(voss, nil) != c.voss || filter.Match(bomlo, nil) != c.bomlo {
+			t.Errorf("%s: expected %v %v", c.expr, c.voss, c.bomlo)
+		}
+	}
+
+	filter, err := ParseTrackFilter("bbox(5.0, 60.0, 7.5, 61.5) and distance > 50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.NeedsTakeoff() || !filter.Match(voss, takeoff) || filter.Match(voss, nil) ||
+		filter.Match(voss, &TrackPoint{Lat: 59.5, Lon: 5.2}) {
+		t.Error("the bbox matched the wrong takeoffs")
+	}
+}
+
+func Test_ParseTrackFilterErrors(t *testing.T) {
+	for _, expr := range []string{"", "altitude > 3000", "distance > far", "site > Voss", "pilot ~", "site in (Voss",
+		"distance > 50 and", "(distance > 50", "bbox(1, 2, 3)", "bbox(5, 61, 7, 60)", `pilot = "Ola`, "site = Voss)",
+		"distance ! 5"} {
+		if _, err := ParseTrackFilter(expr); err == nil {
+			t.Errorf("expected an error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GliderRule maps raw glider strings from the igc header to a glider model.
+// Pattern is a case insensitive regular expression. if it has a group named version its
+// value is added to the model, eg. "Rush" and version 5 becomes "Rush 5"
+type GliderRule struct {
+	Pattern      string `json:"pattern"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	Class        string `json:"class"`
+}
+
+// Glider is a normalised glider
+type Glider struct {
+	Ref          string `json:"ref"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	Size         string `json:"size"`
+	Class        string `json:"class"`
+}
+
+// GliderStats is the aggregated statistics for a glider model
+type GliderStats struct {
+	Ref           string   `json:"ref"`
+	Manufacturer  string   `json:"manufacturer"`
+	Model         string   `json:"model"`
+	Class         string   `json:"class"`
+	Sizes         []string `json:"sizes"`
+	Flights       int      `json:"flights"`
+	AvgXCDistance float64  `json:"avg_xc_distance"` // km, of the furthest point from the takeoff
+	AvgAirtime    int64    `json:"avg_airtime"`     // seconds
+}
+
+// GliderRegistry normalises glider strings using a list of rules. the first matching rule is used
+type GliderRegistry struct {
+	rules    []GliderRule
+	patterns []*regexp.Regexp
+}
+
+// GliderMgr is the manager for the glider endpoints
+type GliderMgr struct {
+	DB       *Database
+	Registry *GliderRegistry
+}
+
+// DefaultGliderRules is used when no rules file is configured. the classes are for the current versions of the models
+var DefaultGliderRules = []GliderRule{
+	{`\bozone\b.*\brush\b\s*(?P<version>\d+)?`, "Ozone", "Rush", "EN-B"},
+	{`\bozone\b.*\bbuzz\b\s*(?P<version>z?\d+)?`, "Ozone", "Buzz", "EN-B"},
+	{`\bozone\b.*\bdelta\b\s*(?P<version>\d+)?`, "Ozone", "Delta", "EN-C"},
+	{`\bozone\b.*\balpina\b\s*(?P<version>\d+)?`, "Ozone", "Alpina", "EN-C"},
+	{`\bozone\b.*\bmantra\b\s*(?P<version>m?\d+)?`, "Ozone", "Mantra", "EN-D"},
+	{`\bozone\b.*\benzo\b\s*(?P<version>\d+)?`, "Ozone", "Enzo", "CCC"},
+	{`\badvance\b.*\balpha\b\s*(?P<version>\d+)?`, "Advance", "Alpha", "EN-A"},
+	{`\badvance\b.*\bepsilon\b\s*(?P<version>\d+)?`, "Advance", "Epsilon", "EN-B"},
+	{`\badvance\b.*\biota\b\s*(?P<version>\d+)?`, "Advance", "Iota", "EN-B"},
+	{`\badvance\b.*\bsigma\b\s*(?P<version>\d+)?`, "Advance", "Sigma", "EN-C"},
+	{`\badvance\b.*\bomega\b\s*(?P<version>x?\w*\d+)?`, "Advance", "Omega", "EN-D"},
+	{`\bgin\b.*\bbolero\b\s*(?P<version>\d+)?`, "Gin", "Bolero", "EN-A"},
+	{`\bgin\b.*\batlas\b\s*(?P<version>\d+)?`, "Gin", "Atlas", "EN-B"},
+	{`\bgin\b.*\bexplorer\b\s*(?P<version>\d+)?`, "Gin", "Explorer", "EN-C"},
+	{`\bgin\b.*\bcarrera\b\s*(?P<version>\+|\d+)?`, "Gin", "Carrera", "EN-C"},
+	{`\bgin\b.*\bboomerang\b\s*(?P<version>\d+)?`, "Gin", "Boomerang", "CCC"},
+	{`\bnova\b.*\bion\b\s*(?P<version>\d+)?`, "Nova", "Ion", "EN-B"},
+	{`\bnova\b.*\bmentor\b\s*(?P<version>\d+)?`, "Nova", "Mentor", "EN-B"},
+	{`\bnova\b.*\btriton\b\s*(?P<version>\d+)?`, "Nova", "Triton", "EN-C"},
+	{`\bniviuk\b.*\bhook\b\s*(?P<version>\d+)?`, "Niviuk", "Hook", "EN-B"},
+	{`\bniviuk\b.*\bartik\b\s*(?P<version>\d+)?`, "Niviuk", "Artik", "EN-C"},
+	{`\bniviuk\b.*\bicepeak\b\s*(?P<version>\w*\d+)?`, "Niviuk", "Icepeak", "EN-D"},
+	{`\bskywalk\b.*\bmescal\b\s*(?P<version>\d+)?`, "Skywalk", "Mescal", "EN-A"},
+	{`\bskywalk\b.*\bchili\b\s*(?P<version>\d+)?`, "Skywalk", "Chili", "EN-B"},
+	{`\bskywalk\b.*\bcayenne\b\s*(?P<version>\d+)?`, "Skywalk", "Cayenne", "EN-C"},
+	{`\bswing\b.*\barcus\b\s*(?P<version>(rs)?\s*\d+)?`, "Swing", "Arcus", "EN-B"},
+	{`\bswing\b.*\bmito\b\s*(?P<version>\d+)?`, "Swing", "Mito", "EN-B"},
+	// models that are usually logged without the manufacturer
+	{`\brush\b\s*(?P<version>\d+)?`, "Ozone", "Rush", "EN-B"},
+	{`\bdelta\b\s*(?P<version>\d+)?`, "Ozone", "Delta", "EN-C"},
+	{`\biota\b\s*(?P<version>\d+)?`, "Advance", "Iota", "EN-B"},
+	{`\bsigma\b\s*(?P<version>\d+)?`, "Advance", "Sigma", "EN-C"},
+	{`\bmentor\b\s*(?P<version>\d+)?`, "Nova", "Mentor", "EN-B"},
+	{`\bchili\b\s*(?P<version>\d+)?`, "Skywalk", "Chili", "EN-B"},
+}
+
+// a size written as a separate word, eg. "ML", "XS" or "23"
+var gliderSizePattern = regexp.MustCompile(`(?i)^(XXS|XS|S|SM|MS|M|ML|L|XL|XXL|1[6-9]|2[0-9]|3[0-4])$`)
+
+// NewGliderRegistry compiles the rules into a registry
+func NewGliderRegistry(rules []GliderRule) (*GliderRegistry, error) {
+	registry := &GliderRegistry{rules: rules}
+	for _, rule := range rules {
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		registry.patterns = append(registry.patterns, pattern)
+	}
+	return registry, nil
+}
+
+// LoadGliderRegistry creates a registry from a json file with an array of rules.
+// if path is empty the default rules are used
+func LoadGliderRegistry(path string) (*GliderRegistry, error) {
+	if path == "" {
+		return NewGliderRegistry(DefaultGliderRules)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []GliderRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, err
+	}
+	return NewGliderRegistry(rules)
+}
+
+// Normalise maps the raw glider type and glider id from the igc header to a glider.
+// returns false if no rule matched
+func (registry *GliderRegistry) Normalise(gliderType string, gliderID string) (Glider, bool) {
+	raw := strings.TrimSpace(gliderType + " " + gliderID)
+	for i, pattern := range registry.patterns {
+		match := pattern.FindStringSubmatchIndex(raw)
+		if match == nil {
+			continue
+		}
+		rule := registry.rules[i]
+		glider := Glider{Manufacturer: rule.Manufacturer, Model: rule.Model, Class: rule.Class}
+		for j, name := range pattern.SubexpNames() {
+			if name == "version" && match[2*j] >= 0 {
+				glider.Model += " " + strings.ToUpper(strings.Join(strings.Fields(raw[match[2*j]:match[2*j+1]]), ""))
+			}
+		}
+		// the size is usually the word right after the model
+		if rest := strings.Fields(raw[match[1]:]); len(rest) > 0 && gliderSizePattern.MatchString(rest[0]) {
+			glider.Size = strings.ToUpper(rest[0])
+		}
+		glider.Ref = gliderRef(glider.Manufacturer, glider.Model)
+		return glider, true
+	}
+	return Glider{}, false
+}
+
+// HandlerGetGliders is the handler for GET /api/gliders.
+// it responds with every known glider model and the statistics of the tracks flown with it
+func (gMgr *GliderMgr) HandlerGetGliders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	tracks, err := gMgr.DB.GetAllTracks()
+	if err != nil {
+		http.Error(w, "Could not receive track list", http.StatusInternalServerError)
+		return
+	}
+	if err := gMgr.addXCDistances(tracks); err != nil {
+		http.Error(w, "Could not receive the points of the tracks", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(gMgr.Registry.Stats(tracks))
+}
+
+// addXCDistances calculates the xc distance of the tracks that were added before it was stored with the track
+func (gMgr *GliderMgr) addXCDistances(tracks []TrackInfo) error {
+	var ids []string
+	missing := map[string]int{}
+	for i, track := range tracks {
+		if track.XCDistance == 0 {
+			ids = append(ids, track.ID.Hex())
+			missing[track.ID.Hex()] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	points, err := gMgr.DB.GetPointsByTrackIDs(ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		tracks[missing[p.ID.Hex()]].XCDistance = CalculateTrackStats(p.Points).MaxDistance
+	}
+	return nil
+}
+
+// Stats aggregates the tracks by glider model. the raw strings are normalised again so changed rules apply to old tracks
+func (registry *GliderRegistry) Stats(tracks []TrackInfo) []GliderStats {
+	byRef := map[string]*GliderStats{}
+	totalDistance := map[string]float64{}
+	totalAirtime := map[string]int64{}
+	for _, track := range tracks {
+		glider, found := registry.Normalise(track.Glider, track.GliderID)
+		if !found {
+			continue
+		}
+		stats, exists := byRef[glider.Ref]
+		if !exists {
+			stats = &GliderStats{Ref: glider.Ref, Manufacturer: glider.Manufacturer, Model: glider.Model, Class: glider.Class, Sizes: []string{}}
+			byRef[glider.Ref] = stats
+		}
+		stats.Flights++
+		totalDistance[glider.Ref] += track.XCDistance
+		totalAirtime[glider.Ref] += track.Airtime
+		if glider.Size != "" && !containsString(stats.Sizes, glider.Size) {
+			stats.Sizes = append(stats.Sizes, glider.Size)
+		}
+	}
+
+	result := []GliderStats{}
+	for ref, stats := range byRef {
+		stats.AvgXCDistance = totalDistance[ref] / float64(stats.Flights)
+		stats.AvgAirtime = totalAirtime[ref] / int64(stats.Flights)
+		sort.Strings(stats.Sizes)
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Flights != result[j].Flights {
+			return result[i].Flights > result[j].Flights
+		}
+		return result[i].Ref < result[j].Ref
+	})
+	return result
+}
+
+// gliderRef creates the reference stored on tracks, eg. "ozone-rush-5"
+func gliderRef(manufacturer string, model string) string {
+	return strings.Join(tokenize(manufacturer+" "+model), "-")
+}
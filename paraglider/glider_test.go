@@ -0,0 +1,51 @@
+package paragliding
+
+import (
+	"testing"
+)
+
+func Test_GliderRegistry_Normalise(t *testing.T) {
+	registry, err := NewGliderRegistry(DefaultGliderRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		gliderType, gliderID, ref, size, class string
+	}{
+		{"OZONE Rush 5 ML", "", "ozone-rush-5", "ML", "EN-B"},
+		{"rush5", "", "", "", ""},
+		{"Ozone  rush", "5", "ozone-rush-5", "", "EN-B"},
+		{"Iota 2 25", "", "advance-iota-2", "25", "EN-B"},
+		{"Gin Carrera+", "M", "gin-carrera", "M", "EN-C"},
+		{"some glider", "XYZ", "", "", ""},
+	}
+	for _, c := range cases {
+		glider, found := registry.Normalise(c.gliderType, c.gliderID)
+		if found != (c.ref != "") || glider.Ref != c.ref || glider.Size != c.size || glider.Class != c.class {
+			t.Errorf("%q %q: got %+v", c.gliderType, c.gliderID, glider)
+		}
+	}
+
+	// broken rules should be reported
+	if _, err := NewGliderRegistry([]GliderRule{{Pattern: "(", Model: "x"}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func Test_GliderRegistry_Stats(t *testing.T) {
+	registry, _ := NewGliderRegistry(DefaultGliderRules)
+	tracks := []TrackInfo{
+		// the xc distance is used, not the length of the path that was flown
+		{Glider: "Ozone Rush 5", GliderID: "ML", TrackLength: "100", XCDistance: 10, Airtime: 100},
+		{Glider: "ozone rush 5", GliderID: "S", TrackLength: "300", XCDistance: 30, Airtime: 300},
+		{Glider: "Nova Mentor 6", TrackLength: "500", XCDistance: 50, Airtime: 500},
+		{Glider: "unknown", TrackLength: "700", XCDistance: 70, Airtime: 700},
+	}
+	stats := registry.Stats(tracks)
+	if len(stats) != 2 || stats[0].Ref != "ozone-rush-5" {
+		t.Fatal("wrong glider models")
+	}
+	if stats[0].Flights != 2 || stats[0].AvgXCDistance != 20 || stats[0].AvgAirtime != 200 || len(stats[0].Sizes) != 2 {
+		t.Errorf("wrong stats: %+v", stats[0])
+	}
+}
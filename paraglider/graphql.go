@@ -0,0 +1,804 @@
+package paragliding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GraphQLMgr is the manager for the graphql endpoint. it implements the part of graphql the frontend needs:
+// queries with arguments, variables, aliases, fragments and the skip/include directives. no mutations
+type GraphQLMgr struct {
+	DB      *Database
+	Ticker  *MgrTicker
+	DevMode bool // introspection (__schema and __type) is only available in dev mode
+
+	schema     map[string]*gqlType
+	schemaOnce sync.Once // the schema is built by the first query
+}
+
+// gqlType is an object type in the schema
+type gqlType struct {
+	Name        string
+	Description string
+	FieldOrder  []string
+	Fields      map[string]*gqlField
+}
+
+// gqlField is a field of an object type. Type is written as in the graphql schema language, eg. "[Track!]!"
+type gqlField struct {
+	Type        string
+	Description string
+	Args        [][2]string // ordered name and type pairs
+	Resolve     func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error)
+	// Batch is called once with every parent before the field is resolved on a list of objects.
+	// it is used to load everything the field needs in a single query
+	Batch func(req *gqlRequest, parents []interface{}) error
+}
+
+// gqlRequest holds the state of a single graphql request
+type gqlRequest struct {
+	mgr       *GraphQLMgr
+	variables map[string]interface{}
+	fragments map[string]*gqlFragment
+	tracks    *gqlLoader
+	points    *gqlLoader
+	errors    []gqlError
+}
+
+// gqlError is an error in the graphql response
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlLoader batches and caches loads by key for the duration of a request, in the style of dataloader
+type gqlLoader struct {
+	fetch func(keys []string) (map[string]interface{}, error)
+	cache map[string]interface{}
+}
+
+// the most tracks the root tracks field returns at a time, and the most a query can cost. see complexity
+const (
+	gqlMaxTracks     = 100
+	gqlMaxComplexity = 100000
+)
+
+// the scalar types of the schema. Timestamp is unix time in milliseconds, which does not fit in a graphql Int
+var gqlScalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true, "Timestamp": true}
+
+// HandlerGraphQL is the handler for POST /paragliding/graphql.
+// it takes a body with query, variables and operationName and responds with the data and errors
+func (gMgr *GraphQLMgr) HandlerGraphQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	var body struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err == io.EOF {
+		gqlWriteErrors(w, http.StatusBadRequest, "POST body is empty")
+		return
+	} else if err != nil {
+		gqlWriteErrors(w, http.StatusBadRequest, "POST body is not valid json")
+		return
+	}
+	data, errs, err := gMgr.Execute(body.Query, body.OperationName, body.Variables)
+	if err != nil {
+		gqlWriteErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Data   interface{} `json:"data"`
+		Errors []gqlError  `json:"errors,omitempty"`
+	}{data, errs})
+}
+
+// Execute runs a query and returns the data and the field errors.
+// the error is set if the query could not be parsed and nothing was executed
+func (gMgr *GraphQLMgr) Execute(query string, operationName string, variables map[string]interface{}) (interface{}, []gqlError, error) {
+	gMgr.schemaOnce.Do(func() { gMgr.schema = gMgr.buildSchema() })
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	op, err := doc.operation(operationName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if op.Type != "query" {
+		return nil, nil, errors.New("only query operations are supported")
+	}
+
+	req := &gqlRequest{mgr: gMgr, variables: map[string]interface{}{}, fragments: doc.Fragments}
+	req.tracks = &gqlLoader{fetch: gMgr.fetchTracks}
+	req.points = &gqlLoader{fetch: gMgr.fetchPoints}
+	for _, v := range op.Variables {
+		value, given := variables[v.Name]
+		if !given {
+			value = v.Default
+		}
+		if value == nil && strings.HasSuffix(v.Type, "!") {
+			return nil, nil, fmt.Errorf("variable $%s of type %s is required", v.Name, v.Type)
+		}
+		req.variables[v.Name] = value
+	}
+	if cost := req.complexity("Query", op.Selections); cost > gqlMaxComplexity {
+		return nil, nil, fmt.Errorf("the query is too complex, it costs %d and the limit is %d", cost, gqlMaxComplexity)
+	}
+	data := req.executeObject("Query", nil, op.Selections, nil)
+	return data, req.errors, nil
+}
+
+// complexity estimates the cost of the selections on the given type. every field costs 1, and the fields selected
+// on a list are counted once for every item, the limit argument of the list or gqlMaxTracks if it has none.
+// so aliasing a list of tracks and their points many times over is rejected before anything is loaded
+func (req *gqlRequest) complexity(typeName string, sels []*gqlSelection) int {
+	objType := req.mgr.schema[typeName]
+	cost := 0
+	for _, sel := range req.collectFields(typeName, sels) {
+		cost++
+		field, exists := objType.Fields[sel.Name]
+		if !exists || strings.HasPrefix(sel.Name, "__") { // the introspection is only in dev mode
+			continue
+		}
+		child := 0
+		if base := strings.Trim(field.Type, "[]!"); req.mgr.schema[base] != nil {
+			child = req.complexity(base, sel.Selections)
+		}
+		if strings.HasPrefix(field.Type, "[") {
+			items := int64(gqlMaxTracks)
+			if limit, given := gqlInt(req.resolveValue(sel.Args["limit"])); given && limit >= 0 && limit < items {
+				items = limit
+			} else if ids, given := req.resolveValue(sel.Args["ids"]).([]interface{}); given && int64(len(ids)) < items {
+				items = int64(len(ids))
+			}
+			child *= int(items)
+		}
+		// stop before the cost overflows
+		if cost += child; cost > gqlMaxComplexity {
+			return cost
+		}
+	}
+	return cost
+}
+
+// executeObject resolves the selections on a value of the given object type
+func (req *gqlRequest) executeObject(typeName string, parent interface{}, sels []*gqlSelection, path []interface{}) *gqlObject {
+	result := &gqlObject{values: map[string]interface{}{}}
+	objType := req.mgr.schema[typeName]
+	for _, sel := range req.collectFields(typeName, sels) {
+		key := sel.key()
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if sel.Name == "__typename" {
+			result.set(key, typeName)
+			continue
+		}
+		field, exists := objType.Fields[sel.Name]
+		if !exists {
+			req.addError(fmt.Sprintf("cannot query field %q on type %q", sel.Name, typeName), fieldPath)
+			result.set(key, nil)
+			continue
+		}
+		args, err := req.coerceArgs(field, sel.Args)
+		if err != nil {
+			req.addError(err.Error(), fieldPath)
+			result.set(key, nil)
+			continue
+		}
+		value, err := field.Resolve(req, parent, args)
+		if err != nil {
+			req.addError(err.Error(), fieldPath)
+			result.set(key, nil)
+			continue
+		}
+		result.set(key, req.completeValue(field.Type, value, sel.Selections, fieldPath))
+	}
+	return result
+}
+
+// completeValue turns a resolved value into what is put in the response, executing sub selections on objects
+func (req *gqlRequest) completeValue(typ string, value interface{}, sels []*gqlSelection, path []interface{}) interface{} {
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		return nil
+	}
+	if m, isMap := value.(map[string]interface{}); isMap { // introspection results
+		return req.executeMap(m, sels)
+	}
+	rv := reflect.ValueOf(value)
+	if strings.HasPrefix(typ, "[") {
+		if rv.Kind() != reflect.Slice {
+			return nil
+		}
+		itemType := typ[1 : len(typ)-1]
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		// let the fields load what they need for all the items at once
+		if base := strings.Trim(itemType, "[]!"); !gqlScalars[base] && req.mgr.schema[base] != nil {
+			for _, sel := range req.collectFields(base, sels) {
+				if field := req.mgr.schema[base].Fields[sel.Name]; field != nil && field.Batch != nil {
+					if err := field.Batch(req, items); err != nil {
+						req.addError(err.Error(), path)
+						return nil
+					}
+				}
+			}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = req.completeValue(itemType, item, sels, append(append([]interface{}{}, path...), i))
+		}
+		return list
+	}
+	if gqlScalars[typ] {
+		return value
+	}
+	if _, isObject := req.mgr.schema[typ]; isObject {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		return req.executeObject(typ, value, sels, path)
+	}
+	return value
+}
+
+// executeMap resolves selections on a plain map. used for the introspection types
+func (req *gqlRequest) executeMap(m map[string]interface{}, sels []*gqlSelection) *gqlObject {
+	result := &gqlObject{values: map[string]interface{}{}}
+	for _, sel := range req.collectFields("", sels) {
+		value := m[sel.Name]
+		switch v := value.(type) {
+		case map[string]interface{}:
+			result.set(sel.key(), req.executeMap(v, sel.Selections))
+		case []map[string]interface{}:
+			list := make([]interface{}, len(v))
+			for i, item := range v {
+				list[i] = req.executeMap(item, sel.Selections)
+			}
+			result.set(sel.key(), list)
+		default:
+			result.set(sel.key(), value)
+		}
+	}
+	return result
+}
+
+// collectFields flattens fragments and applies the skip and include directives
+func (req *gqlRequest) collectFields(typeName string, sels []*gqlSelection) []*gqlSelection {
+	var fields []*gqlSelection
+	seen := map[string]*gqlSelection{}
+	var collect func(sels []*gqlSelection)
+	collect = func(sels []*gqlSelection) {
+		for _, sel := range sels {
+			if !req.included(sel) {
+				continue
+			}
+			switch {
+			case sel.Spread != "":
+				if frag, exists := req.fragments[sel.Spread]; exists && (typeName == "" || frag.On == typeName) {
+					collect(frag.Selections)
+				}
+			case sel.Name == "":
+				if sel.On == "" || typeName == "" || sel.On == typeName {
+					collect(sel.Selections)
+				}
+			default:
+				// the same field asked for twice gets its sub selections merged
+				if prev, exists := seen[sel.key()]; exists {
+					merged := *prev
+					merged.Selections = append(append([]*gqlSelection{}, prev.Selections...), sel.Selections...)
+					for i := range fields {
+						if fields[i] == prev {
+							fields[i] = &merged
+						}
+					}
+					seen[sel.key()] = &merged
+					continue
+				}
+				seen[sel.key()] = sel
+				fields = append(fields, sel)
+			}
+		}
+	}
+	collect(sels)
+	return fields
+}
+
+// included checks the @skip and @include directives of a selection
+func (req *gqlRequest) included(sel *gqlSelection) bool {
+	for _, dir := range sel.Directives {
+		cond, _ := req.resolveValue(dir.Args["if"]).(bool)
+		if (dir.Name == "skip" && cond) || (dir.Name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// coerceArgs resolves the variables in the arguments and checks that the required ones are present
+func (req *gqlRequest) coerceArgs(field *gqlField, raw map[string]interface{}) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, arg := range field.Args {
+		value := req.resolveValue(raw[arg[0]])
+		if value == nil && strings.HasSuffix(arg[1], "!") {
+			return nil, fmt.Errorf("argument %q of type %s is required", arg[0], arg[1])
+		}
+		if value != nil {
+			args[arg[0]] = value
+		}
+	}
+	for name := range raw {
+		if _, known := gqlArgType(field, name); !known {
+			return nil, fmt.Errorf("unknown argument %q", name)
+		}
+	}
+	return args, nil
+}
+
+// resolveValue replaces the variables in a parsed value with their values
+func (req *gqlRequest) resolveValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case gqlVariable:
+		return req.variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = req.resolveValue(v[i])
+		}
+		return list
+	case map[string]interface{}:
+		obj := map[string]interface{}{}
+		for k := range v {
+			obj[k] = req.resolveValue(v[k])
+		}
+		return obj
+	}
+	return value
+}
+
+func (req *gqlRequest) addError(message string, path []interface{}) {
+	req.errors = append(req.errors, gqlError{Message: message, Path: path})
+}
+
+func gqlArgType(field *gqlField, name string) (string, bool) {
+	for _, arg := range field.Args {
+		if arg[0] == name {
+			return arg[1], true
+		}
+	}
+	return "", false
+}
+
+// LoadMany makes sure all the keys are loaded, fetching the missing ones in a single call
+func (loader *gqlLoader) LoadMany(keys []string) ([]interface{}, error) {
+	if loader.cache == nil {
+		loader.cache = map[string]interface{}{}
+	}
+	var missing []string
+	for _, key := range keys {
+		if _, cached := loader.cache[key]; !cached {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		found, err := loader.fetch(missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range missing {
+			loader.cache[key] = found[key] // nil if it was not found, so it is not fetched again
+		}
+	}
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = loader.cache[key]
+	}
+	return values, nil
+}
+
+// Load returns the value for a single key
+func (loader *gqlLoader) Load(key string) (interface{}, error) {
+	values, err := loader.LoadMany([]string{key})
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// Prime adds an already loaded value to the cache
+func (loader *gqlLoader) Prime(key string, value interface{}) {
+	if loader.cache == nil {
+		loader.cache = map[string]interface{}{}
+	}
+	loader.cache[key] = value
+}
+
+func (gMgr *GraphQLMgr) fetchTracks(ids []string) (map[string]interface{}, error) {
+	tracks, err := gMgr.DB.GetTracksByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]interface{}{}
+	for _, track := range tracks {
+		found[track.ID.Hex()] = track
+	}
+	return found, nil
+}
+
+func (gMgr *GraphQLMgr) fetchPoints(ids []string) (map[string]interface{}, error) {
+	points, err := gMgr.DB.GetPointsByTrackIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]interface{}{}
+	for _, p := range points {
+		found[p.ID.Hex()] = p.Points
+	}
+	return found, nil
+}
+
+// loadTracks loads the tracks with the given ids, skipping the ones that do not exist
+func (req *gqlRequest) loadTracks(ids []string) ([]TrackInfo, error) {
+	values, err := req.tracks.LoadMany(ids)
+	if err != nil {
+		return nil, err
+	}
+	tracks := []TrackInfo{}
+	for _, v := range values {
+		if track, ok := v.(TrackInfo); ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks, nil
+}
+
+// trackPoints returns the points of a track, loading them if needed
+func (req *gqlRequest) trackPoints(track TrackInfo) ([]TrackPoint, error) {
+	value, err := req.points.Load(track.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	points, _ := value.([]TrackPoint)
+	return points, nil
+}
+
+// batchTrackPoints loads the points of every track in parents with a single query
+func batchTrackPoints(req *gqlRequest, parents []interface{}) error {
+	var ids []string
+	for _, p := range parents {
+		if track, ok := p.(TrackInfo); ok {
+			ids = append(ids, track.ID.Hex())
+		}
+	}
+	_, err := req.points.LoadMany(ids)
+	return err
+}
+
+// buildSchema creates the types of the schema and their resolvers
+func (gMgr *GraphQLMgr) buildSchema() map[string]*gqlType {
+	schema := map[string]*gqlType{}
+	add := func(name string, description string, fields ...gqlFieldDef) {
+		t := &gqlType{Name: name, Description: description, Fields: map[string]*gqlField{}}
+		for _, f := range fields {
+			t.FieldOrder = append(t.FieldOrder, f.name)
+			t.Fields[f.name] = f.field
+		}
+		schema[name] = t
+	}
+
+	queryFields := []gqlFieldDef{
+		{"track", &gqlField{Type: "Track", Description: "a single track", Args: [][2]string{{"id", "ID!"}},
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				tracks, err := req.loadTracks([]string{gqlString(args["id"])})
+				if err != nil || len(tracks) == 0 {
+					return nil, err
+				}
+				return tracks[0], nil
+			}}},
+		{"tracks", &gqlField{Type: "[Track!]!",
+			Description: fmt.Sprintf("the tracks with the given ids, or a page of every track, the oldest first. at most %d at a time", gqlMaxTracks),
+			Args:        [][2]string{{"ids", "[ID!]"}, {"offset", "Int"}, {"limit", "Int"}},
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				if ids, given := args["ids"].([]interface{}); given {
+					if len(ids) > gqlMaxTracks {
+						return nil, fmt.Errorf("at most %d ids can be given", gqlMaxTracks)
+					}
+					var keys []string
+					for _, id := range ids {
+						keys = append(keys, gqlString(id))
+					}
+					return req.loadTracks(keys)
+				}
+				offset, _ := gqlInt(args["offset"])
+				limit, given := gqlInt(args["limit"])
+				if !given || limit > gqlMaxTracks {
+					limit = gqlMaxTracks
+				}
+				if offset < 0 || limit < 0 {
+					return nil, errors.New("offset and limit can not be negative")
+				}
+				if limit == 0 {
+					return []TrackInfo{}, nil
+				}
+				tracks, err := req.mgr.DB.GetTracks(offset, limit)
+				for _, track := range tracks {
+					req.tracks.Prime(track.ID.Hex(), track)
+				}
+				return tracks, err
+			}}},
+		{"ticker", &gqlField{Type: "Ticker!", Description: "a page of tracks added after the timestamp, like GET /api/ticker/<timestamp>",
+			Args: [][2]string{{"after", "Timestamp"}},
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				after, _ := gqlInt(args["after"])
+				return req.mgr.Ticker.GetTickerByTimeStamp(after)
+			}}},
+		{"webhook", &gqlField{Type: "Webhook", Description: "a registered webhook", Args: [][2]string{{"id", "ID!"}},
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				webhook, found := req.mgr.DB.GetWebhookByID(gqlString(args["id"]))
+				if !found {
+					return nil, nil
+				}
+				return webhook, nil
+			}}},
+	}
+	if gMgr.DevMode {
+		queryFields = append(queryFields,
+			gqlFieldDef{"__schema", &gqlField{Type: "__Schema!",
+				Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+					return introspectSchema(req.mgr.schema), nil
+				}}},
+			gqlFieldDef{"__type", &gqlField{Type: "__Type", Args: [][2]string{{"name", "String!"}},
+				Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+					name := gqlString(args["name"])
+					if t, exists := req.mgr.schema[name]; exists {
+						return introspectType(t), nil
+					}
+					if gqlScalars[name] {
+						return introspectScalar(name), nil
+					}
+					return nil, nil
+				}}})
+	}
+	add("Query", "the root query type", queryFields...)
+
+	add("Track", "a registered track",
+		gqlProp("id", "ID!", func(p interface{}) interface{} { return p.(TrackInfo).ID.Hex() }),
+		gqlProp("H_date", "String", func(p interface{}) interface{} { return p.(TrackInfo).HDate }),
+		gqlProp("pilot", "String", func(p interface{}) interface{} { return p.(TrackInfo).Pilot }),
+		gqlProp("glider", "String", func(p interface{}) interface{} { return p.(TrackInfo).Glider }),
+		gqlProp("glider_id", "String", func(p interface{}) interface{} { return p.(TrackInfo).GliderID }),
+		gqlProp("track_length", "String", func(p interface{}) interface{} { return p.(TrackInfo).TrackLength }),
+		gqlProp("track_url", "String", func(p interface{}) interface{} { return p.(TrackInfo).TrackURL }),
+		gqlProp("timestamp", "Timestamp", func(p interface{}) interface{} { return p.(TrackInfo).Timestamp }),
+		gqlProp("pilot_id", "ID", func(p interface{}) interface{} { return p.(TrackInfo).PilotID }),
+		gqlProp("airtime", "Int", func(p interface{}) interface{} { return p.(TrackInfo).Airtime }),
+		gqlProp("glider_ref", "String", func(p interface{}) interface{} { return p.(TrackInfo).GliderRef }),
+		gqlProp("glider_class", "String", func(p interface{}) interface{} { return p.(TrackInfo).GliderClass }),
+		gqlProp("site_id", "ID", func(p interface{}) interface{} { return p.(TrackInfo).SiteID }),
+		gqlProp("site", "String", func(p interface{}) interface{} { return p.(TrackInfo).Site }),
+		gqlProp("validity", "String", func(p interface{}) interface{} { return p.(TrackInfo).Validity }),
+		gqlFieldDef{"statistics", &gqlField{Type: "TrackStatistics!", Description: "statistics derived from the points",
+			Batch: batchTrackPoints,
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				points, err := req.trackPoints(parent.(TrackInfo))
+				return CalculateTrackStats(points), err
+			}}},
+		gqlFieldDef{"points", &gqlField{Type: "[Point!]!", Args: [][2]string{{"offset", "Int"}, {"limit", "Int"}},
+			Batch: batchTrackPoints,
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				points, err := req.trackPoints(parent.(TrackInfo))
+				if err != nil {
+					return nil, err
+				}
+				if offset, given := gqlInt(args["offset"]); given && offset > 0 {
+					if offset > int64(len(points)) {
+						offset = int64(len(points))
+					}
+					points = points[offset:]
+				}
+				if limit, given := gqlInt(args["limit"]); given && limit >= 0 && limit < int64(len(points)) {
+					points = points[:limit]
+				}
+				return points, nil
+			}}})
+
+	add("TrackStatistics", "statistics derived from the points of a track",
+		gqlProp("takeoff_time", "Timestamp", func(p interface{}) interface{} { return p.(TrackStats).TakeoffTime }),
+		gqlProp("landing_time", "Timestamp", func(p interface{}) interface{} { return p.(TrackStats).LandingTime }),
+		gqlProp("duration", "Int", func(p interface{}) interface{} { return p.(TrackStats).Duration }),
+		gqlProp("max_altitude", "Int", func(p interface{}) interface{} { return p.(TrackStats).MaxAltitude }),
+		gqlProp("min_altitude", "Int", func(p interface{}) interface{} { return p.(TrackStats).MinAltitude }),
+		gqlProp("max_climb", "Float", func(p interface{}) interface{} { return p.(TrackStats).MaxClimb }),
+		gqlProp("max_sink", "Float", func(p interface{}) interface{} { return p.(TrackStats).MaxSink }),
+		gqlProp("total_distance", "Float", func(p interface{}) interface{} { return p.(TrackStats).TotalDistance }),
+		gqlProp("straight_distance", "Float", func(p interface{}) interface{} { return p.(TrackStats).StraightDistance }),
+		gqlProp("max_distance", "Float", func(p interface{}) interface{} { return p.(TrackStats).MaxDistance }))
+
+	add("Point", "a single fix",
+		gqlProp("time", "Timestamp", func(p interface{}) interface{} { return p.(TrackPoint).Time }),
+		gqlProp("lat", "Float", func(p interface{}) interface{} { return p.(TrackPoint).Lat }),
+		gqlProp("lon", "Float", func(p interface{}) interface{} { return p.(TrackPoint).Lon }),
+		gqlProp("pressure_altitude", "Int", func(p interface{}) interface{} { return p.(TrackPoint).PressureAltitude }),
+		gqlProp("gnss_altitude", "Int", func(p interface{}) interface{} { return p.(TrackPoint).GNSSAltitude }))
+
+	add("Ticker", "a page of the ticker. pass t_stop as after to get the next page",
+		gqlProp("t_latest", "Timestamp", func(p interface{}) interface{} { return p.(Response).TLatest }),
+		gqlProp("t_start", "Timestamp", func(p interface{}) interface{} { return p.(Response).TStart }),
+		gqlProp("t_stop", "Timestamp", func(p interface{}) interface{} { return p.(Response).TStop }),
+		gqlProp("processing", "Int", func(p interface{}) interface{} { return p.(Response).Processing }),
+		gqlProp("track_ids", "[ID!]!", func(p interface{}) interface{} {
+			ids := []string{}
+			for _, id := range p.(Response).TrackIDs {
+				ids = append(ids, id.Hex())
+			}
+			return ids
+		}),
+		gqlFieldDef{"tracks", &gqlField{Type: "[Track!]!",
+			Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				var ids []string
+				for _, id := range parent.(Response).TrackIDs {
+					ids = append(ids, id.Hex())
+				}
+				return req.loadTracks(ids)
+			}}})
+
+	add("Webhook", "a registered webhook",
+		gqlProp("id", "ID!", func(p interface{}) interface{} { return p.(WebhookInfo).ID.Hex() }),
+		gqlProp("webhookURL", "String", func(p interface{}) interface{} { return p.(WebhookInfo).WebhookURL }),
+		gqlProp("minTriggerValue", "Int", func(p interface{}) interface{} { return p.(WebhookInfo).MinTriggerValue }),
+		gqlProp("events", "[String!]!", func(p interface{}) interface{} { return webhookEvents(p.(WebhookInfo)) }),
+		gqlProp("filter", "String", func(p interface{}) interface{} { return p.(WebhookInfo).Filter }),
+		gqlProp("schedule", "String", func(p interface{}) interface{} { return p.(WebhookInfo).Schedule }))
+
+	return schema
+}
+
+// gqlFieldDef is a named field, used to keep the order of the fields when building the schema
+type gqlFieldDef struct {
+	name  string
+	field *gqlField
+}
+
+// gqlProp creates a field that just reads a value from the parent
+func gqlProp(name string, typ string, get func(parent interface{}) interface{}) gqlFieldDef {
+	return gqlFieldDef{name, &gqlField{Type: typ,
+		Resolve: func(req *gqlRequest, parent interface{}, args map[string]interface{}) (interface{}, error) {
+			return get(parent), nil
+		}}}
+}
+
+func gqlString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// gqlInt converts an argument to an int. variables decoded from json are float64
+func gqlInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// introspectSchema returns the schema in the shape of the graphql introspection types
+func introspectSchema(schema map[string]*gqlType) map[string]interface{} {
+	types := []map[string]interface{}{}
+	for _, name := range []string{"Int", "Float", "String", "Boolean", "ID", "Timestamp"} {
+		types = append(types, introspectScalar(name))
+	}
+	for _, name := range []string{"Query", "Track", "TrackStatistics", "Point", "Ticker", "Webhook"} {
+		types = append(types, introspectType(schema[name]))
+	}
+	boolArg := []map[string]interface{}{{"name": "if", "description": nil, "type": introspectTypeRef("Boolean!"), "defaultValue": nil}}
+	return map[string]interface{}{
+		"queryType":        map[string]interface{}{"name": "Query"},
+		"mutationType":     nil,
+		"subscriptionType": nil,
+		"types":            types,
+		"directives": []map[string]interface{}{
+			{"name": "skip", "description": nil, "locations": []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, "args": boolArg},
+			{"name": "include", "description": nil, "locations": []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, "args": boolArg},
+		},
+	}
+}
+
+func introspectScalar(name string) map[string]interface{} {
+	return map[string]interface{}{"kind": "SCALAR", "name": name, "description": nil, "fields": nil,
+		"interfaces": nil, "possibleTypes": nil, "enumValues": nil, "inputFields": nil, "ofType": nil}
+}
+
+func introspectType(t *gqlType) map[string]interface{} {
+	fields := []map[string]interface{}{}
+	for _, name := range t.FieldOrder {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		f := t.Fields[name]
+		args := []map[string]interface{}{}
+		for _, arg := range f.Args {
+			args = append(args, map[string]interface{}{"name": arg[0], "description": nil,
+				"type": introspectTypeRef(arg[1]), "defaultValue": nil})
+		}
+		fields = append(fields, map[string]interface{}{"name": name, "description": f.Description, "args": args,
+			"type": introspectTypeRef(f.Type), "isDeprecated": false, "deprecationReason": nil})
+	}
+	return map[string]interface{}{"kind": "OBJECT", "name": t.Name, "description": t.Description, "fields": fields,
+		"interfaces": []map[string]interface{}{}, "possibleTypes": nil, "enumValues": nil, "inputFields": nil, "ofType": nil}
+}
+
+// introspectTypeRef turns a type like "[Track!]!" into nested introspection type references
+func introspectTypeRef(typ string) map[string]interface{} {
+	if strings.HasSuffix(typ, "!") {
+		return map[string]interface{}{"kind": "NON_NULL", "name": nil, "ofType": introspectTypeRef(strings.TrimSuffix(typ, "!"))}
+	}
+	if strings.HasPrefix(typ, "[") {
+		return map[string]interface{}{"kind": "LIST", "name": nil, "ofType": introspectTypeRef(typ[1 : len(typ)-1])}
+	}
+	kind := "OBJECT"
+	if gqlScalars[typ] {
+		kind = "SCALAR"
+	}
+	return map[string]interface{}{"kind": kind, "name": typ, "ofType": nil}
+}
+
+func gqlWriteErrors(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors []gqlError `json:"errors"`
+	}{[]gqlError{{Message: message}}})
+}
+
+// gqlObject is a json object that keeps the order of its keys, as graphql responses should
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (obj *gqlObject) set(key string, value interface{}) {
+	if _, exists := obj.values[key]; !exists {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.values[key] = value
+}
+
+// MarshalJSON writes the object with the keys in the order they were set
+func (obj *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range obj.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(obj.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
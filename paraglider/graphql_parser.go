@@ -0,0 +1,624 @@
+package paragliding
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// gqlDocument is a parsed graphql request document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is a query, mutation or subscription in a document
+type gqlOperation struct {
+	Type       string
+	Name       string
+	Variables  []gqlVarDef
+	Selections []*gqlSelection
+}
+
+// gqlVarDef is a variable definition of an operation
+type gqlVarDef struct {
+	Name    string
+	Type    string
+	Default interface{}
+}
+
+// gqlFragment is a named fragment
+type gqlFragment struct {
+	Name       string
+	On         string
+	Selections []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (Spread is set) or an inline fragment (Name and Spread are empty)
+type gqlSelection struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Directives []gqlDirective
+	Selections []*gqlSelection
+	Spread     string
+	On         string
+}
+
+// gqlDirective is a directive like @skip(if: $var)
+type gqlDirective struct {
+	Name string
+	Args map[string]interface{}
+}
+
+// gqlVariable is a reference to a variable in a parsed value
+type gqlVariable string
+
+// key returns the name the field gets in the response
+func (sel *gqlSelection) key() string {
+	if sel.Alias != "" {
+		return sel.Alias
+	}
+	return sel.Name
+}
+
+// operation returns the operation to execute. the name can only be empty if there is a single operation
+func (doc *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.Operations) != 1 {
+			return nil, errors.New("operationName is required when the document does not have exactly one operation")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// the kinds of tokens
+const (
+	gqlTokEOF = iota
+	gqlTokPunct
+	gqlTokName
+	gqlTokInt
+	gqlTokFloat
+	gqlTokString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+	depth  int // of the selection set that is parsed
+}
+
+// the deepest fields can be nested, and the most fields a query can have, with the fragments spread.
+// enough for the introspection query of graphiql
+const (
+	gqlMaxDepth  = 15
+	gqlMaxFields = 500
+)
+
+// parseGraphQL parses a graphql document
+func parseGraphQL(source string) (*gqlDocument, error) {
+	tokens, err := lexGraphQL(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := &gqlDocument{Fragments: map[string]*gqlFragment{}}
+	for p.peek().kind != gqlTokEOF {
+		switch {
+		case p.peekPunct("{"):
+			sels, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &gqlOperation{Type: "query", Selections: sels})
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peekName("fragment"):
+			frag, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments[frag.Name] = frag
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, errors.New("the document does not contain an operation")
+	}
+	if err := doc.validate(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// validate rejects fragments that spread themselves, directly or through other fragments, and operations that
+// nest deeper than gqlMaxDepth or have more than gqlMaxFields fields once the fragments are spread. the first would
+// make the execution recurse without end, the others make a small query do a lot of work
+func (doc *gqlDocument) validate() error {
+	const walking, done = 1, 2
+	state := map[string]int{}
+	depths := map[string]int{} // the depth of every fragment
+	counts := map[string]int{} // and the number of fields in it
+	var walk func(sels []*gqlSelection) (int, int, error)
+	walk = func(sels []*gqlSelection) (int, int, error) {
+		max, fields := 0, 0
+		for _, sel := range sels {
+			var depth, count int
+			if sel.Spread != "" {
+				frag, exists := doc.Fragments[sel.Spread]
+				if !exists {
+					continue
+				}
+				switch state[sel.Spread] {
+				case walking:
+					return 0, 0, fmt.Errorf("fragment %q spreads itself", sel.Spread)
+				case done:
+					depth, count = depths[sel.Spread], counts[sel.Spread]
+				default:
+					state[sel.Spread] = walking
+					d, c, err := walk(frag.Selections)
+					if err != nil {
+						return 0, 0, err
+					}
+					state[sel.Spread], depths[sel.Spread], counts[sel.Spread] = done, d, c
+					depth, count = d, c
+				}
+			} else {
+				d, c, err := walk(sel.Selections)
+				if err != nil {
+					return 0, 0, err
+				}
+				depth, count = d, c
+				if sel.Name != "" {
+					depth++
+					count++
+				}
+			}
+			if depth > max {
+				max = depth
+			}
+			// stop counting before a fragment spread many times over overflows the count
+			if fields += count; fields > gqlMaxFields {
+				return 0, 0, fmt.Errorf("the query has more than %d fields", gqlMaxFields)
+			}
+		}
+		return max, fields, nil
+	}
+	for name, frag := range doc.Fragments {
+		if state[name] == 0 {
+			state[name] = walking
+			d, c, err := walk(frag.Selections)
+			if err != nil {
+				return err
+			}
+			state[name], depths[name], counts[name] = done, d, c
+		}
+	}
+	for _, op := range doc.Operations {
+		depth, _, err := walk(op.Selections)
+		if err != nil {
+			return err
+		}
+		if depth > gqlMaxDepth {
+			return fmt.Errorf("the query is nested deeper than %d fields", gqlMaxDepth)
+		}
+	}
+	return nil
+}
+
+func (p *gqlParser) parseOperation() (*gqlOperation, error) {
+	op := &gqlOperation{Type: p.next().value}
+	if p.peek().kind == gqlTokName {
+		op.Name = p.next().value
+	}
+	if p.skipPunct("(") {
+		for !p.skipPunct(")") {
+			if err := p.expectPunct("$"); err != nil {
+				return nil, err
+			}
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			typ, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			def := gqlVarDef{Name: name, Type: typ}
+			if p.skipPunct("=") {
+				if def.Default, err = p.parseValue(true); err != nil {
+					return nil, err
+				}
+			}
+			op.Variables = append(op.Variables, def)
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.parseSelectionSet()
+	op.Selections = sels
+	return op, err
+}
+
+func (p *gqlParser) parseFragment() (*gqlFragment, error) {
+	p.next() // fragment
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	p.next()
+	on, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.parseSelectionSet()
+	return &gqlFragment{Name: name, On: on, Selections: sels}, err
+}
+
+func (p *gqlParser) parseSelectionSet() ([]*gqlSelection, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	if p.depth++; p.depth > gqlMaxDepth {
+		return nil, fmt.Errorf("the query is nested deeper than %d fields", gqlMaxDepth)
+	}
+	defer func() { p.depth-- }()
+	var sels []*gqlSelection
+	for !p.skipPunct("}") {
+		if p.peek().kind == gqlTokEOF {
+			return nil, p.unexpected()
+		}
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) parseSelection() (*gqlSelection, error) {
+	var err error
+	sel := &gqlSelection{}
+	if p.skipPunct("...") {
+		if p.peek().kind == gqlTokName && !p.peekName("on") {
+			sel.Spread = p.next().value
+			sel.Directives, err = p.parseDirectives()
+			return sel, err
+		}
+		if p.peekName("on") {
+			p.next()
+			if sel.On, err = p.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if sel.Directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		sel.Selections, err = p.parseSelectionSet()
+		return sel, err
+	}
+
+	if sel.Name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if p.skipPunct(":") {
+		sel.Alias = sel.Name
+		if sel.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if sel.Args, err = p.parseArguments(); err != nil {
+		return nil, err
+	}
+	if sel.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peekPunct("{") {
+		sel.Selections, err = p.parseSelectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) parseArguments() (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if !p.skipPunct("(") {
+		return args, nil
+	}
+	for !p.skipPunct(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.parseValue(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func (p *gqlParser) parseDirectives() ([]gqlDirective, error) {
+	var dirs []gqlDirective
+	for p.skipPunct("@") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, gqlDirective{Name: name, Args: args})
+	}
+	return dirs, nil
+}
+
+// parseType parses a type reference like [ID!]! and returns it as written
+func (p *gqlParser) parseType() (string, error) {
+	var typ string
+	if p.skipPunct("[") {
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.skipPunct("!") {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+// parseValue parses a value. constants can not contain variables
+func (p *gqlParser) parseValue(constant bool) (interface{}, error) {
+	tok := p.next()
+	switch tok.kind {
+	case gqlTokInt:
+		return strconv.ParseInt(tok.value, 10, 64)
+	case gqlTokFloat:
+		return strconv.ParseFloat(tok.value, 64)
+	case gqlTokString:
+		return tok.value, nil
+	case gqlTokName:
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return tok.value, nil // enum values are treated as strings
+	case gqlTokPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				break
+			}
+			name, err := p.expectName()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.skipPunct("]") {
+				v, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, nil
+		case "{":
+			obj := map[string]interface{}{}
+			for !p.skipPunct("}") {
+				name, err := p.expectName()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectPunct(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.parseValue(constant); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}
+	}
+	p.pos--
+	return nil, p.unexpected()
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != gqlTokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *gqlParser) peekPunct(value string) bool {
+	return p.peek().kind == gqlTokPunct && p.peek().value == value
+}
+
+func (p *gqlParser) peekName(value string) bool {
+	return p.peek().kind == gqlTokName && p.peek().value == value
+}
+
+func (p *gqlParser) skipPunct(value string) bool {
+	if p.peekPunct(value) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectPunct(value string) error {
+	if !p.skipPunct(value) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *gqlParser) expectName() (string, error) {
+	if p.peek().kind != gqlTokName {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func (p *gqlParser) unexpected() error {
+	tok := p.peek()
+	if tok.kind == gqlTokEOF {
+		return errors.New("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at position %d", tok.value, tok.pos)
+}
+
+// lexGraphQL splits the source into tokens. commas, whitespace and comments are ignored
+func lexGraphQL(source string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(source[i:], "..."):
+			tokens = append(tokens, gqlToken{gqlTokPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+			tokens = append(tokens, gqlToken{gqlTokPunct, string(c), i})
+			i++
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			start := i
+			for i < len(source) && (source[i] == '_' || (source[i] >= 'a' && source[i] <= 'z') ||
+				(source[i] >= 'A' && source[i] <= 'Z') || (source[i] >= '0' && source[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{gqlTokName, source[start:i], start})
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			kind := gqlTokInt
+			i++
+			for i < len(source) && strings.IndexByte("0123456789.eE+-", source[i]) >= 0 {
+				if strings.IndexByte(".eE", source[i]) >= 0 {
+					kind = gqlTokFloat
+				}
+				i++
+			}
+			tokens = append(tokens, gqlToken{kind, source[start:i], start})
+		case strings.HasPrefix(source[i:], `"""`):
+			end := strings.Index(source[i+3:], `"""`)
+			if end < 0 {
+				return nil, fmt.Errorf("syntax error: unterminated string at position %d", i)
+			}
+			tokens = append(tokens, gqlToken{gqlTokString, source[i+3 : i+3+end], i})
+			i += end + 6
+		case c == '"':
+			value, n, err := lexGraphQLString(source[i:])
+			if err != nil {
+				return nil, fmt.Errorf("syntax error: %v at position %d", err, i)
+			}
+			tokens = append(tokens, gqlToken{gqlTokString, value, i})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(source[i:])
+			return nil, fmt.Errorf("syntax error: unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, gqlToken{gqlTokEOF, "", len(source)}), nil
+}
+
+// lexGraphQLString reads a quoted string and returns its value and the number of bytes read
+func lexGraphQLString(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\n':
+			return "", 0, errors.New("unterminated string")
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'u':
+				if i+4 >= len(s) {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				sb.WriteRune(rune(code))
+				i += 4
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
@@ -0,0 +1,143 @@
+package paragliding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_parseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		query Page($after: Timestamp = 0, $ids: [ID!]) {
+			ticker(after: $after) { t_stop ...TrackFields }
+			other: tracks(ids: $ids) @skip(if: true) { id }
+		}
+		fragment TrackFields on Ticker { tracks { pilot, statistics { duration } } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := doc.operation("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Name != "Page" || len(op.Variables) != 2 || op.Variables[0].Default != int64(0) {
+		t.Error("variables were not parsed correctly")
+	}
+	if len(op.Selections) != 2 || op.Selections[1].Alias != "other" || op.Selections[1].Directives[0].Name != "skip" {
+		t.Error("selections were not parsed correctly")
+	}
+	if op.Selections[0].Args["after"] != gqlVariable("after") {
+		t.Error("variable argument was not parsed")
+	}
+	if frag := doc.Fragments["TrackFields"]; frag == nil || frag.On != "Ticker" {
+		t.Error("fragment was not parsed")
+	}
+
+	// a few broken documents
+	for _, query := range []string{`{ track(id: "1") `, `{ track(id: ) { id } }`, `query { track(id: "a\q) }`} {
+		if _, err := parseGraphQL(query); err == nil {
+			t.Error("expected syntax error for: " + query)
+		}
+	}
+
+	// fragments that spread themselves, and queries that are too deep
+	deep := strings.Repeat("{ a ", gqlMaxDepth+1) + strings.Repeat("}", gqlMaxDepth+1)
+	deepFragments := "{ ...F0 }"
+	for i := 0; i <= gqlMaxDepth; i++ {
+		deepFragments += fmt.Sprintf(" fragment F%d on Query { a { ...F%d } }", i, i+1)
+	}
+	for _, query := range []string{`{ ...A } fragment A on Query { ...A }`,
+		`{ ...A } fragment A on Query { id ...B } fragment B on Query { track { ...A } }`,
+		`{ id } fragment A on Query { ... on Query { ...A } }`, deep, deepFragments,
+		"{" + strings.Repeat(" a", gqlMaxFields+1) + " }"} {
+		if _, err := parseGraphQL(query); err == nil {
+			t.Error("expected error for: " + query)
+		}
+	}
+}
+
+func Test_GraphQLComplexity(t *testing.T) {
+	gMgr := GraphQLMgr{}
+	// every alias loads every track and their points, but the fields are few enough to get past the parser
+	var aliased strings.Builder
+	aliased.WriteString("{")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&aliased, " t%d: tracks { id points { lat lon } }", i)
+	}
+	aliased.WriteString(" }")
+	if _, _, err := gMgr.Execute(aliased.String(), "", nil); err == nil || !strings.Contains(err.Error(), "too complex") {
+		t.Errorf("expected the aliased query to be too complex, got %v", err)
+	}
+	// a fragment spread many times over has too many fields
+	spread := "{" + strings.Repeat(" ...F", 30) + " } fragment F on Query { tracks {" + strings.Repeat(" id", 20) + " } }"
+	if _, _, err := gMgr.Execute(spread, "", nil); err == nil || !strings.Contains(err.Error(), "fields") {
+		t.Errorf("expected too many fields, got %v", err)
+	}
+
+	gMgr.schemaOnce.Do(func() { gMgr.schema = gMgr.buildSchema() })
+	req := &gqlRequest{mgr: &gMgr, variables: map[string]interface{}{"n": int64(10)}}
+	doc, err := parseGraphQL(`query($n: Int) { tracks(limit: $n) { id pilot } ticker { t_stop } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost := req.complexity("Query", doc.Operations[0].Selections); cost != 1+10*2+1+1 {
+		t.Errorf("expected a cost of 23, got %d", cost)
+	}
+}
+
+func Test_gqlLoader(t *testing.T) {
+	calls := 0
+	loader := &gqlLoader{fetch: func(keys []string) (map[string]interface{}, error) {
+		calls++
+		found := map[string]interface{}{}
+		for _, k := range keys {
+			if k != "missing" {
+				found[k] = "value " + k
+			}
+		}
+		return found, nil
+	}}
+	values, err := loader.LoadMany([]string{"a", "b", "missing"})
+	if err != nil || values[0] != "value a" || values[2] != nil {
+		t.Error("wrong values loaded")
+	}
+	// everything is cached now, including what was not found
+	if v, _ := loader.Load("b"); v != "value b" || calls != 1 {
+		t.Error("expected the value to be cached")
+	}
+	loader.Load("missing")
+	if calls != 1 {
+		t.Error("missing keys should be cached")
+	}
+}
+
+func Test_HandlerGraphQL(t *testing.T) {
+	gMgr := GraphQLMgr{DevMode: true}
+	body, _ := json.Marshal(map[string]string{"query": `{ __typename, __schema { queryType { name } } }`})
+	req, err := http.NewRequest("POST", "/paragliding/graphql", bytes.NewReader(body))
+	if err != nil {
+		t.Error(err)
+	}
+	res := httptest.NewRecorder()
+	http.HandlerFunc(gMgr.HandlerGraphQL).ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status response: expected %d got %d", http.StatusOK, res.Code)
+	}
+	if res.Body.String() != `{"data":{"__typename":"Query","__schema":{"queryType":{"name":"Query"}}}}`+"\n" {
+		t.Error("unexpected response: " + res.Body.String())
+	}
+
+	// introspection should not be available outside dev mode
+	gMgr = GraphQLMgr{}
+	data, errs, err := gMgr.Execute(`{ __schema { queryType { name } } }`, "", nil)
+	if err != nil || len(errs) != 1 {
+		t.Error("expected an error when introspecting outside dev mode")
+	}
+	if data.(*gqlObject).values["__schema"] != nil {
+		t.Error("introspection returned data outside dev mode")
+	}
+}
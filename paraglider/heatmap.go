@@ -0,0 +1,562 @@
+package paragliding
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the layers of the heatmap
+const (
+	HeatmapFixes    = "fixes"    // every fix of every track
+	HeatmapThermals = "thermals" // one point per thermal, see DetectThermals
+)
+
+// HeatmapMgr is the manager for the heatmap tiles. the fixes are counted per pixel at zoom 14 when a track is added,
+// grouped by layer, day and glider class so the filters do not need the stored points. the counts are stored in the
+// database so they are not counted again when the server starts. rendered tiles are cached on disk and the cached
+// tiles a new track touches are removed
+type HeatmapMgr struct {
+	DB       *Database // the counts are only kept in memory if it is nil
+	CacheDir string
+
+	mutex  sync.RWMutex
+	counts heatCounts
+}
+
+// heatCounts are the counts of the cells, key -> index tile -> cell -> count
+type heatCounts map[heatKey]map[uint64]map[uint64]int32
+
+// heatKey is what the counts are grouped by
+type heatKey struct {
+	Layer string
+	Day   string // 2006-01-02, empty if the track has no valid date
+	Class string
+}
+
+// heatFilter is the filter of a tile request
+type heatFilter struct {
+	Layer string
+	From  string // 2006-01-02, inclusive. empty means no limit
+	To    string
+	Class string
+}
+
+// the zoom levels of the heatmap
+const (
+	heatmapBaseZoom  = 14 // the zoom the cells are counted at
+	heatmapIndexZoom = 8  // the cells are grouped by their tile at this zoom
+	heatmapMaxZoom   = 18
+	heatmapTileSize  = 256
+	mvtExtent        = 4096
+)
+
+// the count that gets the hottest colour at the base zoom. it doubles for every zoom level out,
+// since a track crosses about twice as many cells when the cells are half the size
+const heatmapSaturation = 100
+
+// the thermal detection, a thermal is at least this long climbing at least this fast on average
+const (
+	thermalMinDuration = 20000 // milliseconds
+	thermalMinClimb    = 1.0   // m/s
+)
+
+// the gradient of the png tiles, from the coldest to the hottest
+var heatmapGradient = []struct {
+	at    float64
+	color color.NRGBA
+}{
+	{0, color.NRGBA{0, 0, 255, 0}},
+	{0.2, color.NRGBA{0, 0, 255, 160}},
+	{0.5, color.NRGBA{0, 255, 0, 200}},
+	{0.8, color.NRGBA{255, 255, 0, 230}},
+	{1, color.NRGBA{255, 0, 0, 255}},
+}
+
+// NewHeatmapMgr creates a heatmap manager that stores the counts in the database and caches the tiles in the
+// directory. if it is empty a directory in the temp directory is used
+func NewHeatmapMgr(db *Database, cacheDir string) *HeatmapMgr {
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "paragliding-heatmap")
+	}
+	return &HeatmapMgr{DB: db, CacheDir: cacheDir, counts: heatCounts{}}
+}
+
+// Load reads the stored counts. used when the server starts, the disk cache is cleared since it can be from other
+// data. if no counts are stored the fixes of the tracks are counted and stored once, for the tracks that were added
+// before the counts were stored
+func (hMgr *HeatmapMgr) Load(tracks []TrackInfo) error {
+	hMgr.Reset()
+	if hMgr.DB == nil {
+		return nil
+	}
+	stored, err := hMgr.DB.GetHeatmapCounts()
+	if err != nil {
+		return err
+	}
+	counts := heatCounts{}
+	if len(stored) == 0 && len(tracks) > 0 {
+		var ids []string
+		for _, track := range tracks {
+			ids = append(ids, track.ID.Hex())
+		}
+		points, err := hMgr.DB.GetPointsByTrackIDs(ids)
+		if err != nil {
+			return err
+		}
+		byID := map[string][]TrackPoint{}
+		for _, p := range points {
+			byID[p.ID.Hex()] = p.Points
+		}
+		for _, track := range tracks {
+			counts.add(countTrack(track, byID[track.ID.Hex()]))
+		}
+		if err := hMgr.DB.AddHeatmapCounts(counts.documents()); err != nil {
+			return err
+		}
+	}
+	for _, doc := range stored {
+		counts.addDocument(doc)
+	}
+	hMgr.mutex.Lock()
+	defer hMgr.mutex.Unlock()
+	hMgr.counts = counts
+	return nil
+}
+
+// AddTrack counts the fixes and thermals of a new track, stores the counts and removes the cached tiles they are in.
+// if the counts could not be stored they are not added at all, so the tiles match what is loaded after a restart
+func (hMgr *HeatmapMgr) AddTrack(track TrackInfo, points []TrackPoint) error {
+	counts := countTrack(track, points)
+	if hMgr.DB != nil {
+		if err := hMgr.DB.AddHeatmapCounts(counts.documents()); err != nil {
+			return err
+		}
+	}
+	// the lock is held while the tiles are removed so a tile rendered before the new counts is not cached after
+	hMgr.mutex.Lock()
+	defer hMgr.mutex.Unlock()
+	cells := hMgr.counts.add(counts)
+
+	// the tiles at every zoom that have one of the cells
+	tiles := map[[3]uint64]bool{}
+	for _, cell := range cells {
+		x, y := cell>>32, cell&0xffffffff
+		for z := uint64(0); z <= heatmapMaxZoom; z++ {
+			if z <= heatmapBaseZoom {
+				tiles[[3]uint64{z, (x >> (heatmapBaseZoom - z)) / heatmapTileSize, (y >> (heatmapBaseZoom - z)) / heatmapTileSize}] = true
+			} else {
+				tiles[[3]uint64{z, (x << (z - heatmapBaseZoom)) / heatmapTileSize, (y << (z - heatmapBaseZoom)) / heatmapTileSize}] = true
+			}
+		}
+	}
+	for tile := range tiles {
+		matches, _ := filepath.Glob(filepath.Join(hMgr.CacheDir, "*", "*", strconv.FormatUint(tile[0], 10),
+			strconv.FormatUint(tile[1], 10), strconv.FormatUint(tile[2], 10)+".*"))
+		for _, path := range matches {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// Reset removes all the counts and the cached tiles. used when the admin deletes the tracks, the stored counts are
+// deleted with the tracks. only the directories of the layers are removed, the cache directory can have other files
+func (hMgr *HeatmapMgr) Reset() {
+	hMgr.mutex.Lock()
+	defer hMgr.mutex.Unlock()
+	hMgr.counts = heatCounts{}
+	for _, layer := range []string{HeatmapFixes, HeatmapThermals} {
+		os.RemoveAll(filepath.Join(hMgr.CacheDir, layer))
+	}
+}
+
+// countTrack counts the fixes and the thermals of the track
+func countTrack(track TrackInfo, points []TrackPoint) heatCounts {
+	counts := heatCounts{}
+	day := ""
+	if date, err := time.Parse(hDateLayout, track.HDate); err == nil {
+		day = date.Format("2006-01-02")
+	}
+	add := func(layer string, p TrackPoint) {
+		key := heatKey{Layer: layer, Day: day, Class: track.GliderClass}
+		x, y := worldPixel(p.Lat, p.Lon, heatmapBaseZoom)
+		cell := uint64(x)<<32 | uint64(y)
+		index := uint64(x>>(heatmapBaseZoom-heatmapIndexZoom)/heatmapTileSize)<<32 | uint64(y>>(heatmapBaseZoom-heatmapIndexZoom)/heatmapTileSize)
+		counts.addCell(key, index, cell, 1)
+	}
+	for _, p := range points {
+		add(HeatmapFixes, p)
+	}
+	for _, p := range DetectThermals(points) {
+		add(HeatmapThermals, p)
+	}
+	return counts
+}
+
+func (counts heatCounts) addCell(key heatKey, index uint64, cell uint64, n int32) {
+	if counts[key] == nil {
+		counts[key] = map[uint64]map[uint64]int32{}
+	}
+	if counts[key][index] == nil {
+		counts[key][index] = map[uint64]int32{}
+	}
+	counts[key][index][cell] += n
+}
+
+// add adds the other counts and returns the cells that changed
+func (counts heatCounts) add(other heatCounts) []uint64 {
+	seen := map[uint64]bool{}
+	var changed []uint64
+	for key, indexes := range other {
+		for index, cells := range indexes {
+			for cell, n := range cells {
+				counts.addCell(key, index, cell, n)
+				if !seen[cell] {
+					seen[cell] = true
+					changed = append(changed, cell)
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// documents returns the counts as they are stored, one document per index tile
+func (counts heatCounts) documents() []HeatmapCounts {
+	var docs []HeatmapCounts
+	for key, indexes := range counts {
+		for index, cells := range indexes {
+			doc := HeatmapCounts{Layer: key.Layer, Day: key.Day, Class: key.Class, Index: int64(index), Cells: map[string]int32{}}
+			for cell, n := range cells {
+				doc.Cells[strconv.FormatUint(cell, 10)] = n
+			}
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// addDocument adds the counts of a stored document
+func (counts heatCounts) addDocument(doc HeatmapCounts) {
+	key := heatKey{Layer: doc.Layer, Day: doc.Day, Class: doc.Class}
+	for cell, n := range doc.Cells {
+		if c, err := strconv.ParseUint(cell, 10, 64); err == nil {
+			counts.addCell(key, uint64(doc.Index), c, n)
+		}
+	}
+}
+
+// the path of a tile, eg. /paragliding/api/heatmap/10/536/297.png
+var heatmapTilePattern = regexp.MustCompile(`/(\d+)/(\d+)/(\d+)\.(png|mvt)$`)
+
+// the glider classes that can be used in the cache path
+var heatmapClassPattern = regexp.MustCompile(`^[a-zA-Z0-9+-]*$`)
+
+// HandlerGetTile is the handler for GET /api/heatmap/<z>/<x>/<y>.png and .mvt. the parameters are layer (fixes or
+// thermals, default fixes), from and to (2006-01-02, inclusive) and class (the glider class, eg. EN-B)
+func (hMgr *HeatmapMgr) HandlerGetTile(w http.ResponseWriter, r *http.Request) {
+	m := heatmapTilePattern.FindStringSubmatch(r.URL.Path) // guaranteed to match cause of regex in server.go
+	z, _ := strconv.ParseUint(m[1], 10, 64)
+	x, _ := strconv.ParseUint(m[2], 10, 64)
+	y, _ := strconv.ParseUint(m[3], 10, 64)
+	format := m[4]
+	if z > heatmapMaxZoom || x >= 1<<z || y >= 1<<z {
+		http.Error(w, "the tile does not exist", http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	filter := heatFilter{Layer: params.Get("layer"), From: params.Get("from"), To: params.Get("to"), Class: params.Get("class")}
+	if filter.Layer == "" {
+		filter.Layer = HeatmapFixes
+	}
+	if filter.Layer != HeatmapFixes && filter.Layer != HeatmapThermals {
+		http.Error(w, "layer must be fixes or thermals", http.StatusBadRequest)
+		return
+	}
+	for _, day := range []string{filter.From, filter.To} {
+		if _, err := time.Parse("2006-01-02", day); day != "" && err != nil {
+			http.Error(w, "from and to must be dates like 2006-01-02", http.StatusBadRequest)
+			return
+		}
+	}
+	if !heatmapClassPattern.MatchString(filter.Class) {
+		http.Error(w, "invalid class", http.StatusBadRequest)
+		return
+	}
+
+	contentType := "image/png"
+	if format == "mvt" {
+		contentType = "application/vnd.mapbox-vector-tile"
+	}
+	hMgr.mutex.RLock()
+	defer hMgr.mutex.RUnlock()
+	path := filepath.Join(hMgr.CacheDir, filter.Layer, filter.cacheName(), m[1], m[2], m[3]+"."+format)
+	if content, err := ioutil.ReadFile(path); err == nil {
+		w.Header().Add("content-type", contentType)
+		w.Write(content)
+		return
+	}
+
+	grid := hMgr.grid(filter, z, x, y)
+	var content []byte
+	var err error
+	if format == "png" {
+		content, err = RenderHeatmapPNG(grid, z)
+	} else {
+		content = EncodeHeatmapMVT(grid, filter.Layer)
+	}
+	if err != nil {
+		http.Error(w, "could not render the tile", http.StatusInternalServerError)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		ioutil.WriteFile(path, content, 0644)
+	}
+	w.Header().Add("content-type", contentType)
+	w.Write(content)
+}
+
+// cacheName is the directory of the filter in the cache
+func (filter heatFilter) cacheName() string {
+	name := []string{filter.From, filter.To, filter.Class}
+	for i := range name {
+		if name[i] == "" {
+			name[i] = "all"
+		}
+	}
+	return strings.Join(name, "_")
+}
+
+func (filter heatFilter) matches(key heatKey) bool {
+	if key.Layer != filter.Layer || (filter.Class != "" && !strings.EqualFold(key.Class, filter.Class)) {
+		return false
+	}
+	if (filter.From != "" || filter.To != "") && key.Day == "" {
+		return false
+	}
+	// the days compare as strings
+	return (filter.From == "" || key.Day >= filter.From) && (filter.To == "" || key.Day <= filter.To)
+}
+
+// grid returns the counts of the pixels of the tile, row by row. the caller holds the lock
+func (hMgr *HeatmapMgr) grid(filter heatFilter, z uint64, x uint64, y uint64) []float64 {
+	grid := make([]float64, heatmapTileSize*heatmapTileSize)
+	// the tile in cells at the base zoom, and how many pixels a cell is
+	shift, scale := uint64(0), uint64(1)
+	if z <= heatmapBaseZoom {
+		shift = heatmapBaseZoom - z
+	} else {
+		scale = 1 << (z - heatmapBaseZoom)
+	}
+	for key, indexes := range hMgr.counts {
+		if !filter.matches(key) {
+			continue
+		}
+		for index, cells := range indexes {
+			// skip the index tiles that are not in the tile
+			ix, iy := index>>32, index&0xffffffff
+			if !tilesOverlap(z, x, y, heatmapIndexZoom, ix, iy) {
+				continue
+			}
+			for cell, count := range cells {
+				cx, cy := cell>>32, cell&0xffffffff
+				px, py := int64(cx>>shift)*int64(scale)-int64(x*heatmapTileSize), int64(cy>>shift)*int64(scale)-int64(y*heatmapTileSize)
+				for dy := int64(0); dy < int64(scale); dy++ {
+					for dx := int64(0); dx < int64(scale); dx++ {
+						if px+dx >= 0 && px+dx < heatmapTileSize && py+dy >= 0 && py+dy < heatmapTileSize {
+							grid[(py+dy)*heatmapTileSize+px+dx] += float64(count)
+						}
+					}
+				}
+			}
+		}
+	}
+	return grid
+}
+
+// tilesOverlap returns true if the two tiles at different zooms cover some of the same area
+func tilesOverlap(z1 uint64, x1 uint64, y1 uint64, z2 uint64, x2 uint64, y2 uint64) bool {
+	if z1 < z2 {
+		return x2>>(z2-z1) == x1 && y2>>(z2-z1) == y1
+	}
+	return x1>>(z1-z2) == x2 && y1>>(z1-z2) == y2
+}
+
+// RenderHeatmapPNG blurs the counts and colours them on a logarithmic scale
+func RenderHeatmapPNG(grid []float64, z uint64) ([]byte, error) {
+	blurred := blurGrid(grid)
+	saturation := float64(heatmapSaturation)
+	if z < heatmapBaseZoom {
+		saturation *= math.Pow(2, float64(heatmapBaseZoom-z))
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, heatmapTileSize, heatmapTileSize))
+	for i, v := range blurred {
+		if v <= 0 {
+			continue
+		}
+		img.SetNRGBA(i%heatmapTileSize, i/heatmapTileSize, heatColor(math.Min(1, math.Log1p(v)/math.Log1p(saturation))))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blurGrid spreads the counts with a 5x5 binomial kernel so single fixes are visible
+func blurGrid(grid []float64) []float64 {
+	kernel := []float64{1.0 / 16, 4.0 / 16, 6.0 / 16, 4.0 / 16, 1.0 / 16}
+	pass := func(src []float64, dx int, dy int) []float64 {
+		dst := make([]float64, len(src))
+		for y := 0; y < heatmapTileSize; y++ {
+			for x := 0; x < heatmapTileSize; x++ {
+				v := src[y*heatmapTileSize+x]
+				if v == 0 {
+					continue
+				}
+				for k, weight := range kernel {
+					nx, ny := x+(k-2)*dx, y+(k-2)*dy
+					if nx >= 0 && nx < heatmapTileSize && ny >= 0 && ny < heatmapTileSize {
+						dst[ny*heatmapTileSize+nx] += v * weight
+					}
+				}
+			}
+		}
+		return dst
+	}
+	return pass(pass(grid, 1, 0), 0, 1)
+}
+
+// heatColor returns the colour of the gradient at the position between 0 and 1
+func heatColor(at float64) color.NRGBA {
+	for i := 1; i < len(heatmapGradient); i++ {
+		a, b := heatmapGradient[i-1], heatmapGradient[i]
+		if at <= b.at {
+			f := (at - a.at) / (b.at - a.at)
+			mix := func(x uint8, y uint8) uint8 { return uint8(float64(x) + f*(float64(y)-float64(x)) + 0.5) }
+			return color.NRGBA{mix(a.color.R, b.color.R), mix(a.color.G, b.color.G), mix(a.color.B, b.color.B), mix(a.color.A, b.color.A)}
+		}
+	}
+	return heatmapGradient[len(heatmapGradient)-1].color
+}
+
+// EncodeHeatmapMVT encodes the counts as a mapbox vector tile with one point per pixel that has fixes.
+// the layer is named after the heatmap layer and the points have the attribute count
+func EncodeHeatmapMVT(grid []float64, layerName string) []byte {
+	const scale = mvtExtent / heatmapTileSize
+	var layer []byte
+	layer = appendProtoVarintField(layer, 15, 2) // version
+	layer = appendProtoBytesField(layer, 1, []byte(layerName))
+	valueIndex := map[uint64]uint64{}
+	var values [][]byte
+	id := uint64(0)
+	for i, v := range grid {
+		if v <= 0 {
+			continue
+		}
+		count := uint64(v)
+		index, exists := valueIndex[count]
+		if !exists {
+			index = uint64(len(values))
+			valueIndex[count] = index
+			values = append(values, appendProtoVarintField(nil, 5, count)) // uint_value
+		}
+		id++
+		x, y := int64(i%heatmapTileSize)*scale+scale/2, int64(i/heatmapTileSize)*scale+scale/2
+		var feature []byte
+		feature = appendProtoVarintField(feature, 1, id)
+		feature = appendProtoBytesField(feature, 2, appendVarint(appendVarint(nil, 0), index)) // tags: key 0 = value
+		feature = appendProtoVarintField(feature, 3, 1)                                        // point
+		geometry := appendVarint(nil, 1|1<<3)                                                  // move to, one point
+		geometry = appendVarint(appendVarint(geometry, zigzag(x)), zigzag(y))
+		feature = appendProtoBytesField(feature, 4, geometry)
+		layer = appendProtoBytesField(layer, 2, feature)
+	}
+	layer = appendProtoBytesField(layer, 3, []byte("count"))
+	for _, value := range values {
+		layer = appendProtoBytesField(layer, 4, value)
+	}
+	layer = appendProtoVarintField(layer, 5, mvtExtent)
+	return appendProtoBytesField(nil, 3, layer)
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendProtoVarintField(buf []byte, field uint64, v uint64) []byte {
+	return appendVarint(appendVarint(buf, field<<3), v)
+}
+
+func appendProtoBytesField(buf []byte, field uint64, v []byte) []byte {
+	buf = appendVarint(appendVarint(buf, field<<3|2), uint64(len(v)))
+	return append(buf, v...)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// worldPixel returns the web mercator pixel of the position at the zoom
+func worldPixel(lat float64, lon float64, zoom uint) (uint32, uint32) {
+	const maxLat = 85.05112878
+	lat = math.Max(-maxLat, math.Min(maxLat, lat))
+	size := float64(uint64(heatmapTileSize) << zoom)
+	rad := lat * math.Pi / 180
+	x := (lon + 180) / 360 * size
+	y := (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * size
+	return uint32(math.Max(0, math.Min(size-1, x))), uint32(math.Max(0, math.Min(size-1, y)))
+}
+
+// DetectThermals returns the middle of every climb of at least 20 seconds at 1 m/s or more on average
+func DetectThermals(points []TrackPoint) []TrackPoint {
+	climbing := make([]bool, len(points))
+	j := 0
+	for i := range points {
+		for j < len(points) && points[j].Time-points[i].Time < thermalMinDuration {
+			j++
+		}
+		if j == len(points) {
+			break
+		}
+		seconds := float64(points[j].Time-points[i].Time) / 1000
+		if float64(trackAltitude(points[j])-trackAltitude(points[i]))/seconds >= thermalMinClimb {
+			for k := i; k <= j; k++ {
+				climbing[k] = true
+			}
+		}
+	}
+	var thermals []TrackPoint
+	for i := 0; i < len(points); {
+		if !climbing[i] {
+			i++
+			continue
+		}
+		thermal := TrackPoint{Time: points[i].Time}
+		n := 0
+		for ; i < len(points) && climbing[i]; i++ {
+			thermal.Lat += points[i].Lat
+			thermal.Lon += points[i].Lon
+			n++
+		}
+		thermal.Lat, thermal.Lon = thermal.Lat/float64(n), thermal.Lon/float64(n)
+		thermals = append(thermals, thermal)
+	}
+	return thermals
+}
@@ -0,0 +1,126 @@
+package paragliding
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_DetectThermals(t *testing.T) {
+	var points []TrackPoint
+	// 30 s of gliding, 40 s climbing 2 m/s around 60.01,5 and 30 s of gliding
+	for i := int64(0); i < 100; i++ {
+		p := TrackPoint{Time: i * 1000, Lat: 60, Lon: 5 + float64(i)*0.0001, GNSSAltitude: 1000 - i}
+		if i >= 30 && i < 70 {
+			p.Lat, p.Lon, p.GNSSAltitude = 60.01, 5, 970+(i-30)*2
+		}
+		if i >= 70 {
+			p.GNSSAltitude = 1050 - (i - 70)
+		}
+		points = append(points, p)
+	}
+	thermals := DetectThermals(points)
+	if len(thermals) != 1 || thermals[0].Lat < 60.005 || thermals[0].Lon > 5.002 {
+		t.Errorf("expected one thermal near 60.01,5: %+v", thermals)
+	}
+}
+
+func Test_HeatmapMgr(t *testing.T) {
+	hMgr := NewHeatmapMgr(nil, t.TempDir())
+	track := TrackInfo{ID: objectid.New(), HDate: "2026-07-01 00:00:00 +0000 UTC", GliderClass: "EN-B"}
+	points := []TrackPoint{{Time: 0, Lat: 60.4, Lon: 5.3}, {Time: 1000, Lat: 60.4, Lon: 5.3}}
+	hMgr.AddTrack(track, points)
+
+	// the tile at zoom 10 with the fixes
+	x, y := worldPixel(60.4, 5.3, 10)
+	tx, ty := uint64(x/heatmapTileSize), uint64(y/heatmapTileSize)
+	pixel := int(y%heatmapTileSize)*heatmapTileSize + int(x%heatmapTileSize)
+	filter := heatFilter{Layer: HeatmapFixes}
+	grid := hMgr.grid(filter, 10, tx, ty)
+	if v := grid[pixel]; v != 2 {
+		t.Errorf("expected 2 fixes in the pixel, got %f", v)
+	}
+	for _, filter := range []heatFilter{{Layer: HeatmapFixes, Class: "EN-C"}, {Layer: HeatmapFixes, From: "2026-07-02"},
+		{Layer: HeatmapThermals}} {
+		for _, v := range hMgr.grid(filter, 10, tx, ty) {
+			if v != 0 {
+				t.Fatalf("the filter %+v should not match the track", filter)
+			}
+		}
+	}
+	// zoomed in past the base zoom a cell covers several pixels
+	zx, zy := worldPixel(60.4, 5.3, 16)
+	total := 0.0
+	for _, v := range hMgr.grid(filter, 16, uint64(zx/heatmapTileSize), uint64(zy/heatmapTileSize)) {
+		total += v
+	}
+	if total != 2*16 {
+		t.Errorf("expected the cell to cover 4x4 pixels, got %f", total)
+	}
+
+	content, err := RenderHeatmapPNG(grid, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(pixel%heatmapTileSize, pixel/heatmapTileSize).RGBA(); a == 0 {
+		t.Error("the pixel with fixes is transparent")
+	}
+
+	// a new track removes the cached tiles it is in
+	cached := filepath.Join(hMgr.CacheDir, HeatmapFixes, filter.cacheName(), "10", strconv.FormatUint(tx, 10),
+		strconv.FormatUint(ty, 10)+".png")
+	os.MkdirAll(filepath.Dir(cached), 0755)
+	ioutil.WriteFile(cached, content, 0644)
+	hMgr.AddTrack(track, points)
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Error("the cached tile was not removed")
+	}
+	// the stored counts are the same counts
+	stored := heatCounts{}
+	for _, doc := range hMgr.counts.documents() {
+		stored.addDocument(doc)
+	}
+	if !reflect.DeepEqual(stored, hMgr.counts) {
+		t.Errorf("the stored counts are different: %v", stored)
+	}
+
+	// reset only removes the tiles
+	other := filepath.Join(hMgr.CacheDir, "other.txt")
+	ioutil.WriteFile(other, nil, 0644)
+	ioutil.WriteFile(cached, content, 0644)
+	hMgr.Reset()
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Error("the cached tile was not removed by reset")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("reset removed a file that is not a tile")
+	}
+}
+
+func Test_EncodeHeatmapMVT(t *testing.T) {
+	grid := make([]float64, heatmapTileSize*heatmapTileSize)
+	grid[1] = 3
+	mvt := EncodeHeatmapMVT(grid, "fixes")
+	// tile.layers, then the version and the name of the layer
+	if mvt[0] != 3<<3|2 || !bytes.Contains(mvt, []byte{15 << 3, 2, 1<<3 | 2, 5, 'f', 'i', 'x', 'e', 's'}) {
+		t.Errorf("wrong layer header: %v", mvt)
+	}
+	// the point is at 1*16+8, 0*16+8 in the 4096 extent
+	if !bytes.Contains(mvt, []byte{4<<3 | 2, 3, 9, 48, 16}) {
+		t.Errorf("wrong geometry: %v", mvt)
+	}
+	if !bytes.Contains(mvt, []byte{5 << 3, 3}) {
+		t.Errorf("the count is missing: %v", mvt)
+	}
+}
@@ -0,0 +1,308 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the scoring rules of the leaderboards
+const (
+	LeaderboardRuleBest  = "best"  // the sum of the best n flights
+	LeaderboardRuleTotal = "total" // the sum of all flights
+)
+
+// LeaderboardMgr is the manager for the leaderboards. it keeps the flights of every pilot in memory and
+// caches the computed leaderboards. when a track is added only the standing of that pilot is recomputed
+// in the cached leaderboards the track counts towards
+type LeaderboardMgr struct {
+	Rule  string // the default rule, LeaderboardRuleBest or LeaderboardRuleTotal
+	BestN int    // the default number of flights counted with LeaderboardRuleBest
+	// only the tracks with a verified security record count, see validity.go
+	VerifiedOnly bool
+
+	mutex   sync.Mutex
+	flights map[string][]lbFlight // pilot key -> flights
+	boards  map[lbQuery]*Leaderboard
+	recent  []lbQuery // the cached leaderboards, the least recently used first
+}
+
+// the most leaderboards that are cached, every combination of the parameters is its own leaderboard
+const leaderboardMaxCached = 100
+
+// Leaderboard is the response for GET /api/leaderboards
+type Leaderboard struct {
+	Season  int                `json:"season,omitempty"`
+	Class   string             `json:"class,omitempty"`
+	Site    string             `json:"site,omitempty"`
+	Rule    string             `json:"rule"`
+	N       int                `json:"n,omitempty"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// LeaderboardEntry is the standing of a pilot
+type LeaderboardEntry struct {
+	Rank     int      `json:"rank"`
+	PilotID  string   `json:"pilot_id"`
+	Pilot    string   `json:"pilot"`
+	Score    float64  `json:"score"`   // km
+	Flights  int      `json:"flights"` // the number of flights that counted
+	TrackIDs []string `json:"tracks"`
+
+	key string // the pilot key of the flights
+}
+
+// lbQuery is the filter and rule of a leaderboard. zero values means no filter
+type lbQuery struct {
+	Season int
+	Class  string
+	Site   string
+	Rule   string
+	N      int
+}
+
+// lbFlight is what the leaderboards need to know about a track
+type lbFlight struct {
+	TrackID  string
+	PilotID  string
+	Pilot    string
+	Season   int
+	Class    string
+	Site     string
+	Distance float64
+}
+
+// NewLeaderboardMgr creates a leaderboard manager with the given default rule
+func NewLeaderboardMgr(rule string, bestN int) *LeaderboardMgr {
+	if rule != LeaderboardRuleTotal {
+		rule = LeaderboardRuleBest
+	}
+	if bestN <= 0 {
+		bestN = 3
+	}
+	return &LeaderboardMgr{Rule: rule, BestN: bestN, flights: map[string][]lbFlight{}, boards: map[lbQuery]*Leaderboard{}}
+}
+
+// Load replaces the flights with the passed tracks. used when the server starts
+func (lMgr *LeaderboardMgr) Load(tracks []TrackInfo) {
+	lMgr.mutex.Lock()
+	defer lMgr.mutex.Unlock()
+	lMgr.flights = map[string][]lbFlight{}
+	lMgr.boards, lMgr.recent = map[lbQuery]*Leaderboard{}, nil
+	for _, track := range tracks {
+		if lMgr.VerifiedOnly && track.Validity != ValidityVerified {
+			continue
+		}
+		flight := newLBFlight(track)
+		lMgr.flights[flight.pilotKey()] = append(lMgr.flights[flight.pilotKey()], flight)
+	}
+}
+
+// AddTrack adds a new track and updates the standing of its pilot in the cached leaderboards
+func (lMgr *LeaderboardMgr) AddTrack(track TrackInfo) {
+	if lMgr.VerifiedOnly && track.Validity != ValidityVerified {
+		return
+	}
+	lMgr.mutex.Lock()
+	defer lMgr.mutex.Unlock()
+	flight := newLBFlight(track)
+	key := flight.pilotKey()
+	lMgr.flights[key] = append(lMgr.flights[key], flight)
+	for query, board := range lMgr.boards {
+		if !query.matches(flight) {
+			continue
+		}
+		entry, counts := query.score(lMgr.flights[key])
+		if !counts {
+			continue
+		}
+		replaced := false
+		for i := range board.Entries {
+			if board.Entries[i].key == key {
+				board.Entries[i], replaced = entry, true
+			}
+		}
+		if !replaced {
+			board.Entries = append(board.Entries, entry)
+		}
+		rankEntries(board.Entries)
+	}
+}
+
+// UpdateTracks replaces the flights of stored tracks that were changed, eg. linked to another pilot or tagged with a
+// site. the cached leaderboards are dropped since the flights can move between pilots
+func (lMgr *LeaderboardMgr) UpdateTracks(tracks []TrackInfo) {
+	if len(tracks) == 0 {
+		return
+	}
+	lMgr.mutex.Lock()
+	defer lMgr.mutex.Unlock()
+	changed := map[string]bool{}
+	for _, track := range tracks {
+		changed[track.ID.Hex()] = true
+	}
+	for key, pilotFlights := range lMgr.flights {
+		var kept []lbFlight
+		for _, flight := range pilotFlights {
+			if !changed[flight.TrackID] {
+				kept = append(kept, flight)
+			}
+		}
+		if len(kept) == 0 {
+			delete(lMgr.flights, key)
+		} else {
+			lMgr.flights[key] = kept
+		}
+	}
+	for _, track := range tracks {
+		if lMgr.VerifiedOnly && track.Validity != ValidityVerified {
+			continue
+		}
+		flight := newLBFlight(track)
+		lMgr.flights[flight.pilotKey()] = append(lMgr.flights[flight.pilotKey()], flight)
+	}
+	lMgr.boards, lMgr.recent = map[lbQuery]*Leaderboard{}, nil
+}
+
+// Reset removes every flight. used when the admin deletes the tracks
+func (lMgr *LeaderboardMgr) Reset() {
+	lMgr.Load(nil)
+}
+
+// Get returns the leaderboard for the query, computing it if it is not cached. the least recently used leaderboard
+// is dropped from the cache when it is full
+func (lMgr *LeaderboardMgr) Get(query lbQuery) Leaderboard {
+	lMgr.mutex.Lock()
+	defer lMgr.mutex.Unlock()
+	board, cached := lMgr.boards[query]
+	if !cached {
+		board = &Leaderboard{Season: query.Season, Class: query.Class, Site: query.Site, Rule: query.Rule, Entries: []LeaderboardEntry{}}
+		if query.Rule == LeaderboardRuleBest {
+			board.N = query.N
+		}
+		for _, pilotFlights := range lMgr.flights {
+			if entry, counts := query.score(pilotFlights); counts {
+				board.Entries = append(board.Entries, entry)
+			}
+		}
+		rankEntries(board.Entries)
+		lMgr.boards[query] = board
+		if len(lMgr.recent) >= leaderboardMaxCached {
+			delete(lMgr.boards, lMgr.recent[0])
+			lMgr.recent = lMgr.recent[1:]
+		}
+	} else {
+		for i, q := range lMgr.recent {
+			if q == query {
+				lMgr.recent = append(lMgr.recent[:i], lMgr.recent[i+1:]...)
+				break
+			}
+		}
+	}
+	lMgr.recent = append(lMgr.recent, query)
+	// copy so the caller does not see later updates
+	result := *board
+	result.Entries = append([]LeaderboardEntry{}, board.Entries...)
+	return result
+}
+
+// HandlerGetLeaderboard is the handler for GET /api/leaderboards.
+// the parameters season, class and site filter the flights, and rule and n override the default scoring
+func (lMgr *LeaderboardMgr) HandlerGetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := lbQuery{Class: params.Get("class"), Site: params.Get("site"), Rule: lMgr.Rule, N: lMgr.BestN}
+	if s := params.Get("season"); s != "" {
+		season, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "season is not a year", http.StatusBadRequest)
+			return
+		}
+		query.Season = season
+	}
+	if rule := params.Get("rule"); rule != "" {
+		if rule != LeaderboardRuleBest && rule != LeaderboardRuleTotal {
+			http.Error(w, "rule must be best or total", http.StatusBadRequest)
+			return
+		}
+		query.Rule = rule
+	}
+	if s := params.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "n is not a positive number", http.StatusBadRequest)
+			return
+		}
+		query.N = n
+	}
+	if query.Rule == LeaderboardRuleTotal {
+		query.N = 0
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(lMgr.Get(query))
+}
+
+func newLBFlight(track TrackInfo) lbFlight {
+	flight := lbFlight{TrackID: track.ID.Hex(), PilotID: track.PilotID, Pilot: track.Pilot, Class: track.GliderClass, Site: track.SiteID}
+	flight.Distance, _ = strconv.ParseFloat(track.TrackLength, 64)
+	if date, err := time.Parse(hDateLayout, track.HDate); err == nil {
+		flight.Season = date.Year()
+	}
+	return flight
+}
+
+// pilotKey is the pilot id, or the normalised name for tracks that are not linked to a pilot
+func (flight lbFlight) pilotKey() string {
+	if flight.PilotID != "" {
+		return flight.PilotID
+	}
+	return "name:" + NormalizePilotName(flight.Pilot)
+}
+
+func (query lbQuery) matches(flight lbFlight) bool {
+	return (query.Season == 0 || flight.Season == query.Season) &&
+		(query.Class == "" || flight.Class == query.Class) &&
+		(query.Site == "" || flight.Site == query.Site)
+}
+
+// score computes the standing of a pilot from all the flights of the pilot. returns false if no flight counts
+func (query lbQuery) score(flights []lbFlight) (LeaderboardEntry, bool) {
+	var matching []lbFlight
+	for _, flight := range flights {
+		if query.matches(flight) {
+			matching = append(matching, flight)
+		}
+	}
+	if len(matching) == 0 {
+		return LeaderboardEntry{}, false
+	}
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].Distance > matching[j].Distance })
+	if query.Rule == LeaderboardRuleBest && len(matching) > query.N {
+		matching = matching[:query.N]
+	}
+	entry := LeaderboardEntry{PilotID: matching[0].PilotID, Pilot: matching[0].Pilot, Flights: len(matching),
+		TrackIDs: []string{}, key: matching[0].pilotKey()}
+	for _, flight := range matching {
+		entry.Score += flight.Distance
+		entry.TrackIDs = append(entry.TrackIDs, flight.TrackID)
+	}
+	return entry, true
+}
+
+// rankEntries sorts the entries by score and sets the ranks. pilots with the same score share the rank
+func rankEntries(entries []LeaderboardEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Pilot < entries[j].Pilot
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if i > 0 && entries[i].Score == entries[i-1].Score {
+			entries[i].Rank = entries[i-1].Rank
+		}
+	}
+}
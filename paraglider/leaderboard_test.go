@@ -0,0 +1,102 @@
+package paragliding
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_LeaderboardMgr(t *testing.T) {
+	lMgr := NewLeaderboardMgr(LeaderboardRuleBest, 2)
+	track := func(pilotID string, distance string, date string, class string) TrackInfo {
+		return TrackInfo{ID: objectid.New(), PilotID: pilotID, Pilot: pilotID, TrackLength: distance,
+			HDate: date + " 00:00:00 +0000 UTC", GliderClass: class}
+	}
+	lMgr.Load([]TrackInfo{
+		track("ole", "10", "2026-05-01", "EN-B"),
+		track("ole", "20", "2026-06-01", "EN-B"),
+		track("ole", "30", "2026-07-01", "EN-B"),
+		track("kari", "45", "2026-05-01", "EN-C"),
+		track("kari", "100", "2025-05-01", "EN-C"),
+	})
+
+	// best 2 flights in 2026: ole 50, kari 45
+	board := lMgr.Get(lbQuery{Season: 2026, Rule: LeaderboardRuleBest, N: 2})
+	if len(board.Entries) != 2 || board.Entries[0].Pilot != "ole" || board.Entries[0].Score != 50 || board.Entries[0].Flights != 2 {
+		t.Fatalf("wrong leaderboard: %+v", board.Entries)
+	}
+	total := lMgr.Get(lbQuery{Season: 2026, Rule: LeaderboardRuleTotal})
+	if total.Entries[0].Score != 60 {
+		t.Error("wrong total score")
+	}
+	if classB := lMgr.Get(lbQuery{Class: "EN-C", Rule: LeaderboardRuleTotal}); len(classB.Entries) != 1 || classB.Entries[0].Score != 145 {
+		t.Error("class filter failed")
+	}
+
+	// a new track should update the cached leaderboard
+	lMgr.AddTrack(track("kari", "15", "2026-08-01", "EN-C"))
+	board = lMgr.Get(lbQuery{Season: 2026, Rule: LeaderboardRuleBest, N: 2})
+	if board.Entries[0].Pilot != "kari" || board.Entries[0].Score != 60 || board.Entries[1].Rank != 2 {
+		t.Errorf("leaderboard was not updated: %+v", board.Entries)
+	}
+	lMgr.AddTrack(track("per", "60", "2026-08-01", "EN-A"))
+	board = lMgr.Get(lbQuery{Season: 2026, Rule: LeaderboardRuleBest, N: 2})
+	if len(board.Entries) != 3 || board.Entries[0].Rank != 1 || board.Entries[1].Rank != 1 {
+		t.Errorf("new pilot or shared rank is wrong: %+v", board.Entries)
+	}
+
+	// the cache keeps the most recently used leaderboards
+	for n := 1; n <= leaderboardMaxCached; n++ {
+		lMgr.Get(lbQuery{Rule: LeaderboardRuleBest, N: n})
+	}
+	lMgr.Get(lbQuery{Rule: LeaderboardRuleBest, N: 1})
+	lMgr.Get(lbQuery{Rule: LeaderboardRuleBest, N: leaderboardMaxCached + 1})
+	_, first := lMgr.boards[lbQuery{Rule: LeaderboardRuleBest, N: 1}]
+	_, second := lMgr.boards[lbQuery{Rule: LeaderboardRuleBest, N: 2}]
+	if len(lMgr.boards) != leaderboardMaxCached || !first || second {
+		t.Errorf("expected the least recently used leaderboard to be dropped, %d cached", len(lMgr.boards))
+	}
+
+	lMgr.Reset()
+	if board := lMgr.Get(lbQuery{Rule: LeaderboardRuleTotal}); len(board.Entries) != 0 {
+		t.Error("leaderboard not empty after reset")
+	}
+}
+
+func Test_LeaderboardMgrUpdateTracks(t *testing.T) {
+	lMgr := NewLeaderboardMgr(LeaderboardRuleTotal, 0)
+	tracks := []TrackInfo{
+		{ID: objectid.New(), Pilot: "Ole Hansen", TrackLength: "10"},
+		{ID: objectid.New(), PilotID: "ole", Pilot: "Ole", TrackLength: "20"},
+	}
+	lMgr.Load(tracks)
+	if board := lMgr.Get(lbQuery{Rule: LeaderboardRuleTotal}); len(board.Entries) != 2 {
+		t.Fatalf("expected two pilots before the track is linked: %+v", board.Entries)
+	}
+
+	// the track is linked to the pilot and tagged with a site
+	tracks[0].PilotID, tracks[0].SiteID = "ole", "voss"
+	lMgr.UpdateTracks(tracks[:1])
+	board := lMgr.Get(lbQuery{Rule: LeaderboardRuleTotal})
+	if len(board.Entries) != 1 || board.Entries[0].PilotID != "ole" || board.Entries[0].Score != 30 {
+		t.Errorf("the track did not move to the pilot: %+v", board.Entries)
+	}
+	if site := lMgr.Get(lbQuery{Site: "voss", Rule: LeaderboardRuleTotal}); len(site.Entries) != 1 || site.Entries[0].Score != 10 {
+		t.Errorf("the tagged track is not on the site leaderboard: %+v", site.Entries)
+	}
+}
+
+func Test_LeaderboardMgrVerifiedOnly(t *testing.T) {
+	lMgr := NewLeaderboardMgr(LeaderboardRuleTotal, 0)
+	lMgr.VerifiedOnly = true
+	lMgr.Load([]TrackInfo{
+		{ID: objectid.New(), PilotID: "ole", TrackLength: "10", Validity: ValidityVerified},
+		{ID: objectid.New(), PilotID: "ole", TrackLength: "20", Validity: ValidityUnsigned},
+	})
+	lMgr.AddTrack(TrackInfo{ID: objectid.New(), PilotID: "ole", TrackLength: "30", Validity: ValidityInvalid})
+	lMgr.AddTrack(TrackInfo{ID: objectid.New(), PilotID: "ole", TrackLength: "5", Validity: ValidityVerified})
+	board := lMgr.Get(lbQuery{Rule: LeaderboardRuleTotal})
+	if len(board.Entries) != 1 || board.Entries[0].Score != 15 {
+		t.Errorf("only the verified tracks should count: %+v", board.Entries)
+	}
+}
@@ -0,0 +1,307 @@
+package paragliding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/einarkb/asign2-Para/webhooksig"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// the states of a delivery in the outbox
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead" // failed too many times, or with a response that will not change. admins can replay it
+)
+
+// DeliveryInfo is a webhook request in the outbox. it is stored before it is sent, so the pending deliveries
+// are resumed after a restart
+type DeliveryInfo struct {
+	ID          objectid.ObjectID `bson:"_id" json:"id"`
+	WebhookID   string            `bson:"webhook_id" json:"webhook_id"`
+	URL         string            `bson:"url" json:"url"` // of the last attempt, every attempt uses the current url of the webhook
+	Payload     string            `bson:"payload" json:"payload"`
+	Status      string            `bson:"status" json:"status"`
+	Attempts    int               `bson:"attempts" json:"attempts"`
+	CreatedAt   int64             `bson:"created_at" json:"created_at"`     // unix time in milliseconds
+	NextAttempt int64             `bson:"next_attempt" json:"next_attempt"` // the delivery is not sent before this time
+	DeliveredAt int64             `bson:"delivered_at" json:"delivered_at,omitempty"`
+	LastStatus  int               `bson:"last_status" json:"last_status,omitempty"` // the http status of the last attempt
+	LastError   string            `bson:"last_error" json:"last_error,omitempty"`
+}
+
+// how often the deliveries of a paused webhook are checked. they are sent right away when it is resumed
+const pausedDelay = time.Minute
+
+// Dispatcher sends the deliveries in the outbox with a bounded pool of workers, so a slow webhook only
+// holds up a worker and not the request that triggered it. network errors, 5xx and 429 responses are retried
+// with exponential backoff and jitter, or after the Retry-After of the response
+type Dispatcher struct {
+	DB           *Database
+	Workers      int
+	Timeout      time.Duration // of every request
+	PollInterval time.Duration // how often the outbox is checked for deliveries that are due
+	MaxAttempts  int           // a delivery is dead after this many attempts
+	BaseDelay    time.Duration // the delay before the first retry, it doubles for every attempt
+	MaxDelay     time.Duration
+	Retention    time.Duration // how long the delivery log is kept
+	AllowPrivate bool          // allow sending to private and loopback addresses, which are blocked like in Fetcher
+
+	client   *http.Client
+	queue    chan DeliveryInfo
+	wake     chan struct{}
+	mutex    sync.Mutex
+	inFlight map[objectid.ObjectID]bool
+}
+
+// NewDispatcher creates a dispatcher with the given number of workers and request timeout
+func NewDispatcher(db *Database, workers int, timeout time.Duration) *Dispatcher {
+	if workers <= 0 {
+		workers = 4
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	d := &Dispatcher{DB: db, Workers: workers, Timeout: timeout, PollInterval: time.Second,
+		MaxAttempts: 8, BaseDelay: 2 * time.Second, MaxDelay: time.Hour, Retention: 30 * 24 * time.Hour,
+		queue: make(chan DeliveryInfo, workers), wake: make(chan struct{}, 1), inFlight: map[objectid.ObjectID]bool{}}
+	// the address is checked when it is dialed, so a host that resolves to a private address, or a redirect to
+	// one, is blocked as well
+	dialer := &net.Dialer{Timeout: timeout, Control: func(network string, address string, conn syscall.RawConn) error {
+		return (&Fetcher{AllowPrivate: d.AllowPrivate}).checkAddress(network, address, conn)
+	}}
+	d.client = &http.Client{Timeout: timeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	return d
+}
+
+// checkURL returns an error if the url is not a http or https url, or its host is a private or loopback address
+func (d *Dispatcher) checkURL(rawURL string) error {
+	if !validWebhookURL(rawURL) {
+		return errors.New("webhookURL must be a http or https url")
+	}
+	if d.AllowPrivate {
+		return nil
+	}
+	u, _ := url.Parse(rawURL)
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && blockedIP(ip)) {
+		return errors.New("webhookURL can not be a private or loopback address")
+	}
+	return nil
+}
+
+// Start starts the workers and the poller of the outbox. the deliveries that were pending when the server
+// stopped are picked up by the first poll
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.Workers; i++ {
+		go d.worker()
+	}
+	go d.poll()
+}
+
+// Enqueue stores a delivery of the payload to the webhook in the outbox and wakes the poller
+func (d *Dispatcher) Enqueue(webhook WebhookInfo, payload []byte) error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	delivery := DeliveryInfo{ID: objectid.New(), WebhookID: webhook.ID.Hex(), URL: webhook.WebhookURL,
+		Payload: string(payload), Status: DeliveryPending, CreatedAt: now, NextAttempt: now}
+	if _, added := d.DB.Insert("outbox", delivery); !added {
+		return fmt.Errorf("could not add the delivery to the outbox")
+	}
+	d.wakeUp()
+	return nil
+}
+
+// poll hands the due deliveries to the workers, and removes the old entries of the delivery log once an hour
+func (d *Dispatcher) poll() {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		if time.Since(pruned) > time.Hour {
+			cutoff := time.Now().Add(-d.Retention).UnixNano() / int64(time.Millisecond)
+			if err := d.DB.DeleteDeliveryAttemptsBefore(cutoff); err != nil {
+				fmt.Println(err)
+			}
+			pruned = time.Now()
+		}
+		deliveries, err := d.DB.GetDueDeliveries(time.Now().UnixNano()/int64(time.Millisecond), 100)
+		if err != nil {
+			fmt.Println(err)
+		}
+		for _, delivery := range deliveries {
+			d.mutex.Lock()
+			busy := d.inFlight[delivery.ID]
+			d.inFlight[delivery.ID] = true
+			d.mutex.Unlock()
+			if !busy {
+				d.queue <- delivery // blocks while every worker is busy
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) worker() {
+	for delivery := range d.queue {
+		start := time.Now()
+		webhook, found := d.DB.GetWebhookByID(delivery.WebhookID)
+		switch {
+		case found && webhook.Paused:
+			// held until the webhook is resumed, without using an attempt
+			delivery.NextAttempt = start.Add(pausedDelay).UnixNano() / int64(time.Millisecond)
+		case found:
+			// the url may have been changed since the delivery was added
+			delivery.URL = webhook.WebhookURL
+			delivery = d.attempt(delivery, signingSecrets(webhook, start))
+			d.DB.Insert("delivery_log", newDeliveryAttempt(delivery, start, time.Since(start)))
+		default:
+			delivery.Status, delivery.LastError = DeliveryDead, "the webhook was deleted"
+			d.DB.Insert("delivery_log", newDeliveryAttempt(delivery, start, time.Since(start)))
+		}
+		if err := d.DB.UpdateDelivery(delivery); err != nil {
+			fmt.Println(err)
+		}
+		d.mutex.Lock()
+		delete(d.inFlight, delivery.ID)
+		d.mutex.Unlock()
+	}
+}
+
+// attempt sends the delivery signed with the secrets once and returns it with the result. a failed delivery
+// is scheduled for a retry, or is dead if it can not be retried
+func (d *Dispatcher) attempt(delivery DeliveryInfo, secrets []string) DeliveryInfo {
+	status, retryAfter, err := d.send(delivery, secrets)
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	if err == nil {
+		delivery.Status, delivery.LastError = DeliveryDelivered, ""
+		delivery.DeliveredAt = now.UnixNano() / int64(time.Millisecond)
+		return delivery
+	}
+	delivery.LastError = err.Error()
+	retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+	if !retryable || delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = DeliveryDead
+		return delivery
+	}
+	delay := retryAfter
+	if delay <= 0 {
+		delay = d.backoff(delivery.Attempts)
+	}
+	delivery.Status = DeliveryPending
+	delivery.NextAttempt = now.Add(delay).UnixNano() / int64(time.Millisecond)
+	return delivery
+}
+
+// backoff returns the delay before the next attempt, a random time between half and all of
+// BaseDelay * 2^(attempts-1), at most MaxDelay
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.MaxDelay
+	if attempts < 32 && d.BaseDelay<<uint(attempts-1) < d.MaxDelay {
+		delay = d.BaseDelay << uint(attempts-1)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// send posts the payload with a signature for every secret. a response outside 2xx is an error, and the
+// Retry-After of the response is returned
+func (d *Dispatcher) send(delivery DeliveryInfo, secrets []string) (int, time.Duration, error) {
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("content-type", "application/json")
+	if len(secrets) > 0 {
+		req.Header.Set(webhooksig.HeaderName, webhooksig.Header(secrets, time.Now().Unix(), []byte(delivery.Payload)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16)) // so the connection can be reused
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, parseRetryAfter(resp.Header.Get("retry-after"), time.Now(), d.MaxDelay),
+			fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, 0, nil
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, which is either seconds or a http date.
+// it is at most max, and 0 if the header is missing or invalid
+func parseRetryAfter(header string, now time.Time, max time.Duration) time.Duration {
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		delay = date.Sub(now)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// HandlerGetDeadLetters is the handler for GET /admin/api/webhooks/dead_letters. it responds with the deliveries
+// that failed for good
+func (d *Dispatcher) HandlerGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := d.DB.GetDeliveriesByStatus(DeliveryDead)
+	if err != nil {
+		http.Error(w, "could not get the dead letters", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []DeliveryInfo{}
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// HandlerReplayDeadLetter is the handler for POST /admin/api/webhooks/dead_letters/<id>/replay. it puts the
+// delivery back in the outbox with a fresh set of attempts and responds with it
+func (d *Dispatcher) HandlerReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	delivery, found := d.DB.GetDeliveryByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found || delivery.Status != DeliveryDead {
+		http.Error(w, "the dead letter does not exist", http.StatusNotFound)
+		return
+	}
+	delivery.Status, delivery.Attempts = DeliveryPending, 0
+	delivery.NextAttempt = time.Now().UnixNano() / int64(time.Millisecond)
+	if err := d.DB.UpdateDelivery(delivery); err != nil {
+		http.Error(w, "could not replay the dead letter", http.StatusInternalServerError)
+		return
+	}
+	d.wakeUp()
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// wakeUp makes the poller check the outbox now
+func (d *Dispatcher) wakeUp() {
+	select {
+	case d.wake <- struct{}{}:
+	default: // the poller is already woken
+	}
+}
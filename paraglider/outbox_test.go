@@ -0,0 +1,107 @@
+package paragliding
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_DispatcherAttempt(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			body, _ := ioutil.ReadAll(r.Body)
+			received = string(body)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/busy":
+			w.Header().Set("retry-after", "120")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case "/gone":
+			http.Error(w, "gone", http.StatusGone)
+		default:
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	d := NewDispatcher(nil, 1, 100*time.Millisecond)
+	// the test server is on a loopback address
+	if delivery := d.attempt(DeliveryInfo{URL: server.URL + "/ok", Status: DeliveryPending}, nil); delivery.LastStatus != 0 ||
+		!strings.Contains(delivery.LastError, "not allowed") || received != "" {
+		t.Errorf("the delivery to a loopback address should be blocked: %+v", delivery)
+	}
+	d.AllowPrivate = true
+
+	delivery := d.attempt(DeliveryInfo{URL: server.URL + "/ok", Payload: `{"content":"hi"}`, Status: DeliveryPending}, nil)
+	if delivery.Status != DeliveryDelivered || delivery.Attempts != 1 || delivery.LastStatus != 200 || received != `{"content":"hi"}` {
+		t.Errorf("the delivery failed: %+v", delivery)
+	}
+	// a 500 is retried with backoff until the last attempt
+	d.BaseDelay = time.Minute
+	delivery = d.attempt(DeliveryInfo{URL: server.URL + "/broken", Status: DeliveryPending}, nil)
+	wait := delivery.NextAttempt - time.Now().UnixNano()/int64(time.Millisecond)
+	if delivery.Status != DeliveryPending || delivery.LastStatus != 500 || delivery.LastError == "" || wait < 29000 || wait > 60000 {
+		t.Errorf("the delivery should be retried in 30 to 60 seconds: %+v", delivery)
+	}
+	delivery.Attempts = d.MaxAttempts - 1
+	if delivery = d.attempt(delivery, nil); delivery.Status != DeliveryDead {
+		t.Errorf("the delivery should be dead after the last attempt: %+v", delivery)
+	}
+	delivery = d.attempt(DeliveryInfo{URL: server.URL + "/slow", Status: DeliveryPending}, nil)
+	if delivery.Status != DeliveryPending || delivery.LastStatus != 0 {
+		t.Errorf("the delivery should time out and be retried: %+v", delivery)
+	}
+	delivery = d.attempt(DeliveryInfo{URL: server.URL + "/busy", Status: DeliveryPending}, nil)
+	wait = delivery.NextAttempt - time.Now().UnixNano()/int64(time.Millisecond)
+	if delivery.Status != DeliveryPending || wait < 119000 || wait > 120000 {
+		t.Errorf("the delivery should be retried after the Retry-After: %+v", delivery)
+	}
+	if delivery = d.attempt(DeliveryInfo{URL: server.URL + "/gone", Status: DeliveryPending}, nil); delivery.Status != DeliveryDead {
+		t.Errorf("a 410 should not be retried: %+v", delivery)
+	}
+}
+
+func Test_DispatcherCheckURL(t *testing.T) {
+	d := NewDispatcher(nil, 1, time.Second)
+	for _, rawURL := range []string{"https://example.com/hook", "http://93.184.216.34:8080/hook"} {
+		if err := d.checkURL(rawURL); err != nil {
+			t.Errorf("%s should be allowed: %v", rawURL, err)
+		}
+	}
+	for _, rawURL := range []string{"ftp://example.com", "http://localhost:8080/hook", "http://127.0.0.1/hook",
+		"http://[::1]/hook", "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://api.localhost/"} {
+		if err := d.checkURL(rawURL); err == nil {
+			t.Errorf("%s should not be allowed", rawURL)
+		}
+	}
+	d.AllowPrivate = true
+	if err := d.checkURL("http://127.0.0.1/hook"); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_DispatcherBackoff(t *testing.T) {
+	d := NewDispatcher(nil, 1, time.Second)
+	for attempts, max := range map[int]time.Duration{1: 2 * time.Second, 3: 8 * time.Second, 40: time.Hour} {
+		for i := 0; i < 20; i++ {
+			if delay := d.backoff(attempts); delay < max/2 || delay > max {
+				t.Fatalf("the delay after %d attempts should be between %v and %v, got %v", attempts, max/2, max, delay)
+			}
+		}
+	}
+}
+
+func Test_ParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{"30": 30 * time.Second, "Wed, 01 Jul 2026 12:01:00 GMT": time.Minute,
+		"99999": time.Hour, "soon": 0, "": 0, "Wed, 01 Jul 2026 11:00:00 GMT": 0}
+	for header, want := range tests {
+		if delay := parseRetryAfter(header, now, time.Hour); delay != want {
+			t.Errorf("expected %v for %q, got %v", want, header, delay)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the payload formats of a webhook
+const (
+	FormatDiscord = "discord" // the default
+	FormatSlack   = "slack"
+	FormatTeams   = "teams"
+	FormatJSON    = "json"
+)
+
+// the event types
+const (
+	EventTrackCreated         = "track.created"
+	EventTrackDeleted         = "track.deleted"
+	EventTracksPurged         = "admin.tracks_purged"   // an admin deleted every track
+	EventRecordBroken         = "record.broken"         // a new track is the longest from its site or of its season
+	EventAirspaceInfringement = "airspace.infringement" // a new track infringed airspace
+	EventWebhookTest          = "webhook.test"          // sent by POST /api/webhooks/<id>/test, it can not be subscribed to
+)
+
+// EventTypes are the event types a webhook can subscribe to
+var EventTypes = []string{EventTrackCreated, EventTrackDeleted, EventTracksPurged, EventRecordBroken,
+	EventAirspaceInfringement}
+
+// WebhookEvent is what a webhook is told about. the json format sends it as it is
+type WebhookEvent struct {
+	Type          string         `json:"type"`
+	Timestamp     int64          `json:"timestamp"` // unix time in milliseconds the event happened
+	Ticker        *Response      `json:"ticker,omitempty"`
+	Tracks        []TrackSummary `json:"tracks"`
+	Infringements []Infringement `json:"infringements,omitempty"`
+	Records       []Record       `json:"records,omitempty"`
+	Count         int64          `json:"count,omitempty"` // the number of tracks that were purged
+	Processing    float64        `json:"processing"`      // milliseconds
+	Text          string         `json:"text"`            // the message, from the template of the webhook or the default text
+}
+
+// TrackSummary is a track in an event
+type TrackSummary struct {
+	ID          string  `json:"id"`
+	Pilot       string  `json:"pilot"`
+	Glider      string  `json:"glider"`
+	GliderClass string  `json:"glider_class,omitempty"`
+	Distance    float64 `json:"distance"` // km
+	Date        string  `json:"date"`
+	Site        string  `json:"site,omitempty"`
+	Validity    string  `json:"validity,omitempty"`
+	Timestamp   int64   `json:"timestamp"` // unix time in milliseconds the track was added
+}
+
+// PayloadRenderer turns an event into the body of a webhook request
+type PayloadRenderer func(event WebhookEvent) ([]byte, error)
+
+// the renderer of every format
+var payloadRenderers = map[string]PayloadRenderer{
+	FormatDiscord: renderDiscord,
+	FormatSlack:   renderSlack,
+	FormatTeams:   renderTeams,
+	FormatJSON:    func(event WebhookEvent) ([]byte, error) { return json.Marshal(event) },
+}
+
+// RenderPayload renders the event in the format, the default format if it is empty. the text of the event
+// is the default text if it is not set
+func RenderPayload(format string, event WebhookEvent) ([]byte, error) {
+	if event.Text == "" {
+		event.Text = eventText(event)
+	}
+	if format == "" {
+		format = FormatDiscord
+	}
+	renderer, found := payloadRenderers[format]
+	if !found {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return renderer(event)
+}
+
+// validFormat returns true if the format can be rendered. empty is the default format
+func validFormat(format string) bool {
+	_, found := payloadRenderers[format]
+	return format == "" || found
+}
+
+// newTrackSummary summarises a track for an event
+func newTrackSummary(track TrackInfo) TrackSummary {
+	distance, _ := strconv.ParseFloat(track.TrackLength, 64)
+	return TrackSummary{ID: track.ID.Hex(), Pilot: track.Pilot, Glider: track.Glider, GliderClass: track.GliderClass,
+		Distance: distance, Date: track.HDate, Site: track.Site, Validity: track.Validity, Timestamp: track.Timestamp}
+}
+
+// eventText is the event as a line of text
+func eventText(event WebhookEvent) string {
+	var ids []string
+	for _, track := range event.Tracks {
+		ids = append(ids, track.ID)
+	}
+	switch event.Type {
+	case EventAirspaceInfringement:
+		var names []string
+		for _, infringement := range event.Infringements {
+			names = append(names, infringement.Airspace+" (class "+infringement.Class+", "+
+				strconv.FormatFloat(infringement.MaxPenetration, 'f', 0, 64)+"m inside)")
+		}
+		pilot := ""
+		if len(event.Tracks) > 0 {
+			pilot = event.Tracks[0].Pilot
+		}
+		return "track " + strings.Join(ids, ", ") + " by " + pilot + " infringed " + strings.Join(names, ", ")
+	case EventRecordBroken:
+		var records []string
+		for _, record := range event.Records {
+			records = append(records, "the "+record.Kind+" record of "+record.Name+" ("+
+				strconv.FormatFloat(record.Distance, 'f', 1, 64)+" km, was "+strconv.FormatFloat(record.Previous, 'f', 1, 64)+" km)")
+		}
+		pilot := ""
+		if len(event.Tracks) > 0 {
+			pilot = event.Tracks[0].Pilot
+		}
+		return "track " + strings.Join(ids, ", ") + " by " + pilot + " broke " + strings.Join(records, " and ")
+	case EventTrackDeleted:
+		return strconv.Itoa(len(ids)) + " tracks were deleted: " + strings.Join(ids, ", ")
+	case EventWebhookTest:
+		return "this is a test of the webhook, the track is an example"
+	case EventTracksPurged:
+		return "an admin deleted all " + strconv.FormatInt(event.Count, 10) + " tracks"
+	default:
+		areOrIs := "is: "
+		if len(ids) > 1 {
+			areOrIs = "are: "
+		}
+		latest := int64(0)
+		if event.Ticker != nil {
+			latest = event.Ticker.TLatest
+		}
+		return "latest timestamp: " + strconv.FormatInt(latest, 10) + ", " + strconv.Itoa(len(ids)) + " new tracks " +
+			areOrIs + strings.Join(ids, ", ") + ". (processing: " + strconv.FormatFloat(event.Processing, 'f', 2, 64) + "ms)"
+	}
+}
+
+// trackFacts are the name and value pairs shown for a track
+func trackFacts(track TrackSummary) [][2]string {
+	facts := [][2]string{{"Pilot", track.Pilot}, {"Glider", track.Glider},
+		{"Distance", strconv.FormatFloat(track.Distance, 'f', 1, 64) + " km"}}
+	if track.Site != "" {
+		facts = append(facts, [2]string{"Site", track.Site})
+	}
+	if track.Validity != "" {
+		facts = append(facts, [2]string{"Validity", track.Validity})
+	}
+	return facts
+}
+
+// renderDiscord renders the event as a discord message with an embed for every track, at most 10. the content is
+// cut to the 2000 characters discord allows
+func renderDiscord(event WebhookEvent) ([]byte, error) {
+	type field struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline"`
+	}
+	type embed struct {
+		Title  string  `json:"title"`
+		Fields []field `json:"fields"`
+	}
+	embeds := []embed{}
+	for i, track := range event.Tracks {
+		if i == 10 {
+			break
+		}
+		e := embed{Title: "Track " + track.ID}
+		for _, fact := range trackFacts(track) {
+			e.Fields = append(e.Fields, field{fact[0], fact[1], true})
+		}
+		embeds = append(embeds, e)
+	}
+	return json.Marshal(struct {
+		Content string  `json:"content"`
+		Embeds  []embed `json:"embeds"`
+	}{truncateMessage(event.Text), embeds})
+}
+
+// renderSlack renders the event as a slack message with block kit blocks
+func renderSlack(event WebhookEvent) ([]byte, error) {
+	type text struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	type block struct {
+		Type   string `json:"type"`
+		Text   *text  `json:"text,omitempty"`
+		Fields []text `json:"fields,omitempty"`
+	}
+	blocks := []block{{Type: "section", Text: &text{"mrkdwn", event.Text}}}
+	for _, track := range event.Tracks {
+		b := block{Type: "section", Text: &text{"mrkdwn", "*Track " + track.ID + "*"}}
+		for _, fact := range trackFacts(track) {
+			b.Fields = append(b.Fields, text{"mrkdwn", "*" + fact[0] + "*\n" + fact[1]})
+		}
+		blocks = append(blocks, block{Type: "divider"}, b)
+	}
+	if len(blocks) > 50 { // the most blocks a message can have
+		blocks = blocks[:50]
+	}
+	return json.Marshal(struct {
+		Text   string  `json:"text"`
+		Blocks []block `json:"blocks"`
+	}{event.Text, blocks})
+}
+
+// renderTeams renders the event as a microsoft teams message with an adaptive card
+func renderTeams(event WebhookEvent) ([]byte, error) {
+	type fact struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+	}
+	type element struct {
+		Type   string `json:"type"`
+		Text   string `json:"text,omitempty"`
+		Weight string `json:"weight,omitempty"`
+		Wrap   bool   `json:"wrap,omitempty"`
+		Facts  []fact `json:"facts,omitempty"`
+	}
+	body := []element{{Type: "TextBlock", Text: event.Text, Wrap: true}}
+	for _, track := range event.Tracks {
+		facts := element{Type: "FactSet"}
+		for _, f := range trackFacts(track) {
+			facts.Facts = append(facts.Facts, fact{f[0], f[1]})
+		}
+		body = append(body, element{Type: "TextBlock", Text: "Track " + track.ID, Weight: "Bolder"}, facts)
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	})
+}
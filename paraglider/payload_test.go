@@ -0,0 +1,81 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testEvent() WebhookEvent {
+	return WebhookEvent{Type: EventTrackCreated, Ticker: &Response{TLatest: 1530000000000}, Processing: 1.5,
+		Tracks: []TrackSummary{{ID: "a1", Pilot: `Ole "the eagle"`, Glider: "Rush 5", Distance: 52.31, Site: "Voss"},
+			{ID: "b2", Pilot: "Kari", Glider: "Zeno", Distance: 101}}}
+}
+
+func Test_RenderPayload(t *testing.T) {
+	for _, format := range []string{"", FormatDiscord, FormatSlack, FormatTeams, FormatJSON} {
+		payload, err := RenderPayload(format, testEvent())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(payload, &decoded); err != nil {
+			t.Errorf("the %q payload is not valid json: %s", format, payload)
+		}
+		// the quotes in the pilot name must be escaped
+		if !strings.Contains(string(payload), `Ole \"the eagle\"`) {
+			t.Errorf("the %q payload is missing the pilot: %s", format, payload)
+		}
+	}
+	if _, err := RenderPayload("irc", testEvent()); err == nil || validFormat("irc") {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func Test_RenderDiscord(t *testing.T) {
+	payload, _ := RenderPayload(FormatDiscord, testEvent())
+	var message struct {
+		Content string `json:"content"`
+		Embeds  []struct {
+			Title  string `json:"title"`
+			Fields []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"fields"`
+		} `json:"embeds"`
+	}
+	json.Unmarshal(payload, &message)
+	if message.Content != "latest timestamp: 1530000000000, 2 new tracks are: a1, b2. (processing: 1.50ms)" {
+		t.Errorf("wrong content %q", message.Content)
+	}
+	if len(message.Embeds) != 2 || message.Embeds[0].Fields[2].Value != "52.3 km" || message.Embeds[0].Fields[3].Value != "Voss" {
+		t.Errorf("wrong embeds %+v", message.Embeds)
+	}
+	event := testEvent()
+	event.Text = strings.Repeat("ø", maxMessageLength+10)
+	payload, _ = RenderPayload(FormatDiscord, event)
+	json.Unmarshal(payload, &message)
+	if message.Content != strings.Repeat("ø", maxMessageLength) {
+		t.Errorf("the content was not cut to %d characters", maxMessageLength)
+	}
+}
+
+func Test_EventTextAirspace(t *testing.T) {
+	event := WebhookEvent{Type: EventAirspaceInfringement, Tracks: []TrackSummary{{ID: "a1", Pilot: "Ole"}},
+		Infringements: []Infringement{{Airspace: "Bergen TMA", Class: "C", MaxPenetration: 120.4}}}
+	if text := eventText(event); text != "track a1 by Ole infringed Bergen TMA (class C, 120m inside)" {
+		t.Errorf("wrong text %q", text)
+	}
+}
+
+func Test_EventTextRecord(t *testing.T) {
+	event := WebhookEvent{Type: EventRecordBroken, Tracks: []TrackSummary{{ID: "a1", Pilot: "Ole"}},
+		Records: []Record{{Kind: RecordSite, Name: "Voss", Distance: 52.34, Previous: 48}}}
+	if text := eventText(event); text != "track a1 by Ole broke the site record of Voss (52.3 km, was 48.0 km)" {
+		t.Errorf("wrong text %q", text)
+	}
+	event = WebhookEvent{Type: EventTracksPurged, Count: 12}
+	if text := eventText(event); text != "an admin deleted all 12 tracks" {
+		t.Errorf("wrong text %q", text)
+	}
+}
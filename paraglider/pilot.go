@@ -0,0 +1,260 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// PilotMgr is the manager for pilots
+type PilotMgr struct {
+	DB     *Database
+	Boards *LeaderboardMgr
+}
+
+// PilotProfile is the response for GET /api/pilots/<id>
+type PilotProfile struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases"`
+	Club         string   `json:"club"`
+	Nationality  string   `json:"nationality"`
+	Flights      int      `json:"flights"`
+	Airtime      int64    `json:"airtime"`       // seconds
+	BestDistance float64  `json:"best_distance"` // km
+	Gliders      []string `json:"gliders"`
+	Tracks       []string `json:"tracks"`
+}
+
+// HandlerPostPilot is the handler for POST /api/pilots. it registers a pilot and replies with the id.
+// the body has name and optionaly aliases, club and nationality
+func (pMgr *PilotMgr) HandlerPostPilot(w http.ResponseWriter, r *http.Request) {
+	var postData struct {
+		Name        string   `json:"name"`
+		Aliases     []string `json:"aliases"`
+		Club        string   `json:"club"`
+		Nationality string   `json:"nationality"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(postData.Name) == "" {
+		http.Error(w, "name is missing", http.StatusBadRequest)
+		return
+	}
+
+	// the canonical name is always an alias, and an alias can only belong to one pilot. the aliases of the pilots
+	// that were created for the name of a track are moved to the new pilot
+	pilot := PilotInfo{ID: objectid.New(), Name: strings.TrimSpace(postData.Name), Club: postData.Club, Nationality: postData.Nationality}
+	var owners []PilotInfo // the pilot the alias is moved from, per alias
+	for _, alias := range append([]string{postData.Name}, postData.Aliases...) {
+		norm := NormalizePilotName(alias)
+		if norm == "" || containsString(pilot.Aliases, norm) {
+			continue
+		}
+		other, exists := pMgr.DB.GetPilotByAlias(norm)
+		if exists && !other.Auto {
+			http.Error(w, "the alias "+alias+" already belongs to pilot "+other.ID.Hex(), http.StatusConflict)
+			return
+		}
+		pilot.Aliases = append(pilot.Aliases, norm)
+		owners = append(owners, other)
+	}
+	for i, owner := range owners {
+		if owner.Auto {
+			if err := pMgr.DB.RemovePilotAlias(owner.ID, pilot.Aliases[i]); err != nil {
+				http.Error(w, "could not move the alias "+pilot.Aliases[i], http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	id, added := pMgr.DB.Insert("pilots", pilot)
+	if !added {
+		http.Error(w, "could not add pilot", http.StatusInternalServerError)
+		return
+	}
+	if err := pMgr.linkExistingTracks(pilot); err != nil {
+		http.Error(w, "could not link tracks to the pilot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{id})
+}
+
+// HandlerGetAllPilots is the handler for GET /api/pilots. it replies with an array of all pilot ids
+func (pMgr *PilotMgr) HandlerGetAllPilots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	pilots, err := pMgr.DB.GetAllPilots()
+	if err != nil {
+		http.Error(w, "Could not receive pilot list", http.StatusInternalServerError)
+		return
+	}
+	ids := []string{}
+	for _, pilot := range pilots {
+		ids = append(ids, pilot.ID.Hex())
+	}
+	json.NewEncoder(w).Encode(ids)
+}
+
+// HandlerGetPilotByID is the handler for GET /api/pilots/<id>.
+// it responds with the pilot, its totals and its tracks
+func (pMgr *PilotMgr) HandlerGetPilotByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	parts := strings.Split(r.URL.Path, "/")
+	pilot, found := pMgr.DB.GetPilotByID(parts[len(parts)-1]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	tracks, err := pMgr.DB.GetTracksByPilot(pilot.ID.Hex())
+	if err != nil {
+		http.Error(w, "could not get the tracks of the pilot", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(CalculatePilotProfile(pilot, tracks))
+}
+
+// HandlerAddPilotAlias is the handler for POST /api/pilots/<id>/aliases. it takes a body with alias,
+// adds it to the pilot and links the tracks already flown under that name. an alias of a pilot that was created for
+// the name of a track is moved, and that pilot is deleted when it has no aliases left
+func (pMgr *PilotMgr) HandlerAddPilotAlias(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	pilot, found := pMgr.DB.GetPilotByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	var postData map[string]string
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	norm := NormalizePilotName(postData["alias"])
+	if norm == "" {
+		http.Error(w, "alias is missing", http.StatusBadRequest)
+		return
+	}
+	if other, exists := pMgr.DB.GetPilotByAlias(norm); exists && other.ID != pilot.ID {
+		if !other.Auto {
+			http.Error(w, "the alias already belongs to pilot "+other.ID.Hex(), http.StatusConflict)
+			return
+		}
+		// the pilot was created for the name of a track, the alias and its tracks move to this pilot
+		if err := pMgr.DB.RemovePilotAlias(other.ID, norm); err != nil {
+			http.Error(w, "could not move the alias", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := pMgr.DB.AddPilotAlias(pilot.ID, norm); err != nil {
+		http.Error(w, "could not add alias", http.StatusInternalServerError)
+		return
+	}
+	if !containsString(pilot.Aliases, norm) {
+		pilot.Aliases = append(pilot.Aliases, norm)
+	}
+	if err := pMgr.linkExistingTracks(pilot); err != nil {
+		http.Error(w, "could not link tracks to the pilot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(pilot)
+}
+
+// LinkTrack returns the id of the pilot the track should be linked to. if no pilot has the
+// track's pilot name as an alias, a new pilot is created with that name. the name can be taken by a registered pilot
+// later
+func (pMgr *PilotMgr) LinkTrack(track TrackInfo) string {
+	norm := NormalizePilotName(track.Pilot)
+	if norm == "" {
+		return ""
+	}
+	if pilot, exists := pMgr.DB.GetPilotByAlias(norm); exists {
+		return pilot.ID.Hex()
+	}
+	pilot := PilotInfo{ID: objectid.New(), Name: strings.Join(strings.Fields(track.Pilot), " "), Aliases: []string{norm}, Auto: true}
+	id, added := pMgr.DB.Insert("pilots", pilot)
+	if !added {
+		// a track flown under the same name created the pilot first, the unique index on the aliases keeps it to one
+		if pilot, exists := pMgr.DB.GetPilotByAlias(norm); exists {
+			return pilot.ID.Hex()
+		}
+		return ""
+	}
+	return id
+}
+
+// linkExistingTracks links the stored tracks flown under one of the pilot's aliases to the pilot
+func (pMgr *PilotMgr) linkExistingTracks(pilot PilotInfo) error {
+	tracks, err := pMgr.DB.GetAllTracks()
+	if err != nil {
+		return err
+	}
+	var linked []TrackInfo
+	var ids []string
+	for _, track := range tracks {
+		if track.PilotID != pilot.ID.Hex() && containsString(pilot.Aliases, NormalizePilotName(track.Pilot)) {
+			track.PilotID = pilot.ID.Hex()
+			linked = append(linked, track)
+			ids = append(ids, track.ID.Hex())
+		}
+	}
+	if err := pMgr.DB.SetTracksPilot(ids, pilot.ID.Hex()); err != nil {
+		return err
+	}
+	// the flights move to the pilot in the leaderboards
+	pMgr.Boards.UpdateTracks(linked)
+	return nil
+}
+
+// CalculatePilotProfile sums up the tracks of a pilot
+func CalculatePilotProfile(pilot PilotInfo, tracks []TrackInfo) PilotProfile {
+	profile := PilotProfile{ID: pilot.ID.Hex(), Name: pilot.Name, Aliases: pilot.Aliases, Club: pilot.Club,
+		Nationality: pilot.Nationality, Gliders: []string{}, Tracks: []string{}}
+	if profile.Aliases == nil {
+		profile.Aliases = []string{}
+	}
+	for _, track := range tracks {
+		profile.Flights++
+		profile.Airtime += track.Airtime
+		if d, err := strconv.ParseFloat(track.TrackLength, 64); err == nil && d > profile.BestDistance {
+			profile.BestDistance = d
+		}
+		if glider := strings.TrimSpace(track.Glider); glider != "" && !containsString(profile.Gliders, glider) {
+			profile.Gliders = append(profile.Gliders, glider)
+		}
+		profile.Tracks = append(profile.Tracks, track.ID.Hex())
+	}
+	sort.Strings(profile.Gliders)
+	return profile
+}
+
+// NormalizePilotName returns the form of a pilot name used for aliases. case, accents and
+// extra whitespace are ignored so "Ole  Hansen" and "ole hansen" become the same pilot
+func NormalizePilotName(name string) string {
+	return strings.Join(tokenize(name), " ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
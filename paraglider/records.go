@@ -0,0 +1,100 @@
+package paragliding
+
+import (
+	"strconv"
+	"time"
+)
+
+// the kinds of records
+const (
+	RecordSite   = "site"   // the longest flight from a takeoff site
+	RecordLeague = "league" // the longest flight of a season
+)
+
+// Record is a record a new track broke
+type Record struct {
+	Kind            string  `json:"kind"`
+	Name            string  `json:"name"` // the site, or the season
+	Distance        float64 `json:"distance"`
+	TrackID         string  `json:"track_id"`
+	Previous        float64 `json:"previous"`
+	PreviousTrackID string  `json:"previous_track_id"`
+}
+
+// BrokenRecords returns the records the track broke, compared to the other tracks. only records that
+// existed are broken, so the first flight from a site is not a record
+func BrokenRecords(track TrackInfo, others []TrackInfo, verifiedOnly bool) []Record {
+	distance, err := strconv.ParseFloat(track.TrackLength, 64)
+	if err != nil {
+		return nil
+	}
+	season := trackSeason(track)
+	var siteBest, leagueBest *TrackInfo
+	var siteDistance, leagueDistance float64
+	for i, other := range others {
+		if other.ID == track.ID {
+			continue
+		}
+		d, err := strconv.ParseFloat(other.TrackLength, 64)
+		if err != nil {
+			continue
+		}
+		if track.SiteID != "" && other.SiteID == track.SiteID && (siteBest == nil || d > siteDistance) {
+			siteBest, siteDistance = &others[i], d
+		}
+		counts := !verifiedOnly || other.Validity == ValidityVerified
+		if season != 0 && trackSeason(other) == season && counts && (leagueBest == nil || d > leagueDistance) {
+			leagueBest, leagueDistance = &others[i], d
+		}
+	}
+
+	var records []Record
+	if siteBest != nil && distance > siteDistance {
+		records = append(records, Record{Kind: RecordSite, Name: track.Site, Distance: distance, TrackID: track.ID.Hex(),
+			Previous: siteDistance, PreviousTrackID: siteBest.ID.Hex()})
+	}
+	if leagueBest != nil && distance > leagueDistance && (!verifiedOnly || track.Validity == ValidityVerified) {
+		records = append(records, Record{Kind: RecordLeague, Name: strconv.Itoa(season), Distance: distance,
+			TrackID: track.ID.Hex(), Previous: leagueDistance, PreviousTrackID: leagueBest.ID.Hex()})
+	}
+	return records
+}
+
+// trackSeason returns the year of the flight, 0 if the date is not valid
+func trackSeason(track TrackInfo) int {
+	date, err := time.Parse(hDateLayout, track.HDate)
+	if err != nil {
+		return 0
+	}
+	return date.Year()
+}
+
+// checkRecords sends a record.broken event if the new track broke a site or league record. only the longest
+// track from the site and the longest of the season are compared with
+func (tMgr *TrackMgr) checkRecords(track TrackInfo) {
+	var others []TrackInfo
+	if track.SiteID != "" {
+		best, found, err := tMgr.DB.GetLongestSiteTrack(track.SiteID, track.ID)
+		if err != nil {
+			return
+		}
+		if found {
+			others = append(others, best)
+		}
+	}
+	if season := trackSeason(track); season != 0 {
+		best, found, err := tMgr.DB.GetLongestSeasonTrack(season, tMgr.Boards.VerifiedOnly, track.ID)
+		if err != nil {
+			return
+		}
+		if found {
+			others = append(others, best)
+		}
+	}
+	records := BrokenRecords(track, others, tMgr.Boards.VerifiedOnly)
+	if len(records) == 0 {
+		return
+	}
+	tMgr.WHMgr.InvokeEvent(WebhookEvent{Type: EventRecordBroken, Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Tracks: []TrackSummary{newTrackSummary(track)}, Records: records})
+}
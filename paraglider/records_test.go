@@ -0,0 +1,36 @@
+package paragliding
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_BrokenRecords(t *testing.T) {
+	date := "2018-05-02 00:00:00 +0000 UTC"
+	best := TrackInfo{ID: objectid.New(), SiteID: "voss", HDate: date, TrackLength: "40", Validity: ValidityVerified}
+	other := TrackInfo{ID: objectid.New(), SiteID: "hang", HDate: date, TrackLength: "60", Validity: ValidityUnsigned}
+	old := TrackInfo{ID: objectid.New(), SiteID: "voss", HDate: "2017-05-02 00:00:00 +0000 UTC", TrackLength: "90"}
+
+	// beats the site record of 40, but the best of the season is the unsigned 60
+	track := TrackInfo{ID: objectid.New(), SiteID: "voss", Site: "Voss", HDate: date, TrackLength: "50",
+		Validity: ValidityVerified}
+	records := BrokenRecords(track, []TrackInfo{best, other, track}, false)
+	if len(records) != 1 || records[0].Kind != RecordSite || records[0].Name != "Voss" {
+		t.Errorf("expected the site record, got %v", records)
+	}
+	records = BrokenRecords(track, []TrackInfo{best, other, track}, true)
+	if len(records) != 2 || records[0].Kind != RecordSite || records[0].Previous != 40 ||
+		records[0].PreviousTrackID != best.ID.Hex() || records[1].Kind != RecordLeague || records[1].Name != "2018" {
+		t.Errorf("expected the site and verified league records, got %v", records)
+	}
+	records = BrokenRecords(track, []TrackInfo{best, other, old, track}, true)
+	if len(records) != 1 || records[0].Kind != RecordLeague {
+		t.Errorf("the 90 km from last season is the site record, got %v", records)
+	}
+
+	// the first track of a site is not a record
+	if records := BrokenRecords(track, []TrackInfo{track}, false); len(records) != 0 {
+		t.Errorf("expected no records, got %v", records)
+	}
+}
@@ -0,0 +1,301 @@
+package paragliding
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayMgr is the manager for flight replays. a replay is streamed as server-sent events, and controlled
+// by posting to the control endpoint of its session
+type ReplayMgr struct {
+	DB *Database
+
+	mutex    sync.Mutex
+	sessions map[string]*replaySession
+}
+
+// ReplayControl is the body of POST /api/replay/<session>/control. action is pause, resume, seek (to time)
+// or speed (to speed)
+type ReplayControl struct {
+	Action string  `json:"action"`
+	Time   int64   `json:"time"` // unix time in milliseconds
+	Speed  float64 `json:"speed"`
+}
+
+// ReplayState is the state of a replay, sent when it starts and as the response to a control message
+type ReplayState struct {
+	Session string         `json:"session"`
+	Start   int64          `json:"start"` // unix time in milliseconds of the first fix
+	End     int64          `json:"end"`
+	Time    int64          `json:"time"` // the current replay time
+	Speed   float64        `json:"speed"`
+	Paused  bool           `json:"paused"`
+	Tracks  []CompareTrack `json:"tracks"`
+}
+
+// ReplayFix is a fix sent in a replay
+type ReplayFix struct {
+	Track string `json:"track"`
+	TrackPoint
+}
+
+// the limits of a replay
+const (
+	replayMaxSpeed = 1000
+	replayTick     = 250 * time.Millisecond // how often fixes are sent
+)
+
+// replaySession is a running replay
+type replaySession struct {
+	id     string
+	tracks []CompareTrack
+	points [][]TrackPoint
+	start  int64
+	end    int64
+
+	mutex  sync.Mutex
+	time   int64 // the fixes up to and including this time have been sent
+	speed  float64
+	paused bool
+	seeked bool // a seek has happened since the last step
+}
+
+// HandlerReplayTrack is the handler for GET /api/track/<id>/replay. it streams the fixes of a single track,
+// see HandlerReplay
+func (rMgr *ReplayMgr) HandlerReplayTrack(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	rMgr.replay(w, r, []string{parts[len(parts)-2]}) // guaranteed to be valid cause of regex in server.go
+}
+
+// HandlerReplay is the handler for GET /api/replay?ids=a,b,c. it streams the fixes of the tracks as server-sent events
+// at the speed parameter times real time (default 1). the first event is a session event with the state of the replay,
+// followed by fix events in time order and an end event when the last fix is sent. after a seek a seek event is sent
+// followed by the position of every track at the new time
+func (rMgr *ReplayMgr) HandlerReplay(w http.ResponseWriter, r *http.Request) {
+	ids := parseIDsParam(r.URL.Query().Get("ids"))
+	if len(ids) == 0 || len(ids) > compareMaxTracks {
+		http.Error(w, "ids must have between 1 and "+strconv.Itoa(compareMaxTracks)+" track ids", http.StatusBadRequest)
+		return
+	}
+	rMgr.replay(w, r, ids)
+}
+
+// HandlerReplayControl is the handler for POST /api/replay/<session>/control. it pauses, resumes, seeks or changes
+// the speed of a running replay and responds with its new state
+func (rMgr *ReplayMgr) HandlerReplayControl(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	rMgr.mutex.Lock()
+	session, found := rMgr.sessions[parts[len(parts)-2]] // guaranteed to be valid cause of regex in server.go
+	rMgr.mutex.Unlock()
+	if !found {
+		http.Error(w, "the replay session does not exist", http.StatusNotFound)
+		return
+	}
+	var control ReplayControl
+	err := json.NewDecoder(r.Body).Decode(&control)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	if err := session.control(control); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(session.state())
+}
+
+func (rMgr *ReplayMgr) replay(w http.ResponseWriter, r *http.Request, ids []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	speed := 1.0
+	if s := r.URL.Query().Get("speed"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > replayMaxSpeed {
+			http.Error(w, "speed must be a number above 0 and at most "+strconv.Itoa(replayMaxSpeed), http.StatusBadRequest)
+			return
+		}
+		speed = v
+	}
+	tracks, points, ok := loadTracksWithPoints(w, rMgr.DB, ids)
+	if !ok {
+		return
+	}
+	session := newReplaySession(newReplaySessionID(), tracks, points, speed)
+	rMgr.mutex.Lock()
+	if rMgr.sessions == nil {
+		rMgr.sessions = map[string]*replaySession{}
+	}
+	rMgr.sessions[session.id] = session
+	rMgr.mutex.Unlock()
+	defer func() {
+		rMgr.mutex.Lock()
+		delete(rMgr.sessions, session.id)
+		rMgr.mutex.Unlock()
+	}()
+
+	w.Header().Add("content-type", "text/event-stream")
+	w.Header().Add("cache-control", "no-cache")
+	writeEvent(w, "session", session.state())
+	flusher.Flush()
+	ticker := time.NewTicker(replayTick)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-ticker.C:
+			running := session.step(w, now.Sub(last))
+			last = now
+			flusher.Flush()
+			if !running {
+				return
+			}
+		}
+	}
+}
+
+// newReplaySessionID returns a random id, so the sessions of others can not be guessed and controlled
+func newReplaySessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // the system has no randomness left
+	}
+	return hex.EncodeToString(b)
+}
+
+func newReplaySession(id string, tracks []TrackInfo, points [][]TrackPoint, speed float64) *replaySession {
+	session := &replaySession{id: id, points: points, speed: speed, start: -1}
+	for i, track := range tracks {
+		session.tracks = append(session.tracks, CompareTrack{ID: track.ID.Hex(), Pilot: track.Pilot, Glider: track.Glider,
+			Color: compareColors[i%len(compareColors)]})
+		if len(points[i]) == 0 {
+			continue
+		}
+		if session.start < 0 || points[i][0].Time < session.start {
+			session.start = points[i][0].Time
+		}
+		if points[i][len(points[i])-1].Time > session.end {
+			session.end = points[i][len(points[i])-1].Time
+		}
+	}
+	session.time = session.start - 1
+	return session
+}
+
+func (s *replaySession) state() ReplayState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return ReplayState{Session: s.id, Start: s.start, End: s.end, Time: s.time, Speed: s.speed, Paused: s.paused, Tracks: s.tracks}
+}
+
+func (s *replaySession) control(control ReplayControl) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch control.Action {
+	case "pause":
+		s.paused = true
+	case "resume":
+		s.paused = false
+	case "seek":
+		if control.Time < s.start || control.Time > s.end {
+			return errors.New("time must be between the start and the end of the replay")
+		}
+		s.time, s.seeked = control.Time, true
+	case "speed":
+		if control.Speed <= 0 || control.Speed > replayMaxSpeed {
+			return fmt.Errorf("speed must be above 0 and at most %d", replayMaxSpeed)
+		}
+		s.speed = control.Speed
+	default:
+		return errors.New("action must be pause, resume, seek or speed")
+	}
+	return nil
+}
+
+// replayEvent is an event of a step, written after the lock of the session is released
+type replayEvent struct {
+	name string
+	data interface{}
+}
+
+// step moves the replay forward by the real time elapsed and writes the events. returns false when the replay is over.
+// the events are written without holding the lock, so a slow client does not hold up the control requests
+func (s *replaySession) step(w io.Writer, elapsed time.Duration) bool {
+	events, paused, running := s.advance(elapsed)
+	for _, event := range events {
+		writeEvent(w, event.name, event.data)
+	}
+	if paused {
+		fmt.Fprint(w, ": paused\n\n") // a comment keeps the connection alive
+	}
+	return running
+}
+
+// advance moves the replay forward by the real time elapsed and returns the events to send, wether it is paused
+// and wether it is still running
+func (s *replaySession) advance(elapsed time.Duration) ([]replayEvent, bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var events []replayEvent
+	if s.seeked {
+		// the position of every track at the new time, so the screen does not have to wait for the next fixes
+		events = append(events, replayEvent{"seek", struct {
+			Time int64 `json:"time"`
+		}{s.time}})
+		for i, points := range s.points {
+			j := sort.Search(len(points), func(j int) bool { return points[j].Time > s.time })
+			if j > 0 {
+				events = append(events, replayEvent{"fix", ReplayFix{Track: s.tracks[i].ID, TrackPoint: points[j-1]}})
+			}
+		}
+		s.seeked = false
+	}
+	if s.paused {
+		return events, true, true
+	}
+
+	from, to := s.time, s.time+int64(float64(elapsed/time.Millisecond)*s.speed)
+	var fixes []ReplayFix
+	for i, points := range s.points {
+		j := sort.Search(len(points), func(j int) bool { return points[j].Time > from })
+		for ; j < len(points) && points[j].Time <= to; j++ {
+			fixes = append(fixes, ReplayFix{Track: s.tracks[i].ID, TrackPoint: points[j]})
+		}
+	}
+	sort.SliceStable(fixes, func(i, j int) bool { return fixes[i].Time < fixes[j].Time })
+	for _, fix := range fixes {
+		events = append(events, replayEvent{"fix", fix})
+	}
+	s.time = to
+	if s.time >= s.end {
+		events = append(events, replayEvent{"end", struct {
+			Time int64 `json:"time"`
+		}{s.end}})
+		return events, false, false
+	}
+	return events, false, true
+}
+
+// writeEvent writes a server-sent event with the data as json
+func writeEvent(w io.Writer, event string, data interface{}) {
+	content, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, content)
+}
@@ -0,0 +1,93 @@
+package paragliding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_replaySession(t *testing.T) {
+	tracks := []TrackInfo{{ID: objectid.New(), Pilot: "a"}, {ID: objectid.New(), Pilot: "b"}}
+	points := [][]TrackPoint{
+		{{Time: 1000, Lat: 60}, {Time: 3000, Lat: 60.1}, {Time: 5000, Lat: 60.2}},
+		{{Time: 2000, Lat: 61}, {Time: 4000, Lat: 61.1}},
+	}
+	session := newReplaySession("s", tracks, points, 2)
+	if state := session.state(); state.Start != 1000 || state.End != 5000 || len(state.Tracks) != 2 {
+		t.Fatalf("wrong state: %+v", state)
+	}
+
+	// 1 second at double speed sends the fixes up to 2999
+	var buf bytes.Buffer
+	if !session.step(&buf, time.Second) || strings.Count(buf.String(), "event: fix") != 2 {
+		t.Fatalf("expected two fixes: %s", buf.String())
+	}
+	if strings.Index(buf.String(), `"lat":60,`) > strings.Index(buf.String(), `"lat":61,`) {
+		t.Error("the fixes are not in time order")
+	}
+
+	// nothing is sent while paused
+	session.control(ReplayControl{Action: "pause"})
+	buf.Reset()
+	if !session.step(&buf, time.Second) || strings.Contains(buf.String(), "event: fix") {
+		t.Errorf("fixes sent while paused: %s", buf.String())
+	}
+
+	// seeking sends the position of every track at the new time
+	if err := session.control(ReplayControl{Action: "seek", Time: 4500}); err != nil {
+		t.Fatal(err)
+	}
+	session.control(ReplayControl{Action: "resume"})
+	buf.Reset()
+	if session.step(&buf, time.Second) {
+		t.Error("the replay should be over")
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "event: seek") || strings.Count(out, "event: fix") != 3 || !strings.Contains(out, "event: end") {
+		t.Errorf("wrong events after seek: %s", out)
+	}
+
+	if err := session.control(ReplayControl{Action: "speed", Speed: 5000}); err == nil {
+		t.Error("expected error for too high speed")
+	}
+	if err := session.control(ReplayControl{Action: "seek", Time: 100}); err == nil {
+		t.Error("expected error for seeking before the start")
+	}
+}
+
+// slowWriter blocks every write until it is released, like a client that does not read
+type slowWriter struct {
+	writing, release chan struct{}
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func Test_replaySessionSlowClient(t *testing.T) {
+	session := newReplaySession("s", []TrackInfo{{ID: objectid.New()}}, [][]TrackPoint{{{Time: 1000}, {Time: 2000}}}, 1)
+	w := slowWriter{writing: make(chan struct{}, 1), release: make(chan struct{})}
+	go session.step(w, time.Second)
+	<-w.writing
+
+	// the session can be controlled while the events are written
+	done := make(chan error)
+	go func() { done <- session.control(ReplayControl{Action: "pause"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the control waited for the client")
+	}
+	close(w.release)
+}
@@ -0,0 +1,302 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// SearchIndex is a small in-process inverted index over the searchable fields of the tracks.
+// it is kept up to date on insert and delete so it does not depend on the storage backend
+type SearchIndex struct {
+	mutex    sync.RWMutex
+	postings map[string]map[string]map[string]bool // token -> track id -> fields containing the token
+	docs     map[string]map[string]string          // track id -> field -> original value
+}
+
+// SearchResult is a single ranked hit returned from the index
+type SearchResult struct {
+	ID         string            `json:"id"`
+	Score      float64           `json:"score"`
+	Fields     map[string]string `json:"fields"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// SearchMgr is the manager for the search endpoint
+type SearchMgr struct {
+	Index *SearchIndex
+}
+
+// the scores given to the different kinds of token matches
+const (
+	searchScoreExact  = 3.0
+	searchScorePrefix = 2.0
+	searchScoreFuzzy  = 1.0
+)
+
+// NewSearchIndex creates an empty search index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{postings: make(map[string]map[string]map[string]bool), docs: make(map[string]map[string]string)}
+}
+
+// Build clears the index and adds all the passed tracks to it. used when the server starts
+func (index *SearchIndex) Build(tracks []TrackInfo) {
+	index.Clear()
+	for _, track := range tracks {
+		index.Add(track.ID.Hex(), trackSearchFields(track))
+	}
+}
+
+// Add indexes the fields of a document. if the id is already indexed it is replaced
+func (index *SearchIndex) Add(id string, fields map[string]string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.remove(id)
+	index.docs[id] = fields
+	for field, value := range fields {
+		for _, token := range tokenize(value) {
+			docs, exists := index.postings[token]
+			if !exists {
+				docs = make(map[string]map[string]bool)
+				index.postings[token] = docs
+			}
+			if docs[id] == nil {
+				docs[id] = make(map[string]bool)
+			}
+			docs[id][field] = true
+		}
+	}
+}
+
+// Remove removes a document from the index
+func (index *SearchIndex) Remove(id string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.remove(id)
+}
+
+// Clear removes every document from the index
+func (index *SearchIndex) Clear() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.postings = make(map[string]map[string]map[string]bool)
+	index.docs = make(map[string]map[string]string)
+}
+
+// remove does the actual removal. the caller must hold the lock
+func (index *SearchIndex) remove(id string) {
+	fields, exists := index.docs[id]
+	if !exists {
+		return
+	}
+	for _, value := range fields {
+		for _, token := range tokenize(value) {
+			if docs, found := index.postings[token]; found {
+				delete(docs, id)
+				if len(docs) == 0 {
+					delete(index.postings, token)
+				}
+			}
+		}
+	}
+	delete(index.docs, id)
+}
+
+// Search returns up to limit documents matching the query, best match first.
+// every query token is matched exactly, as a prefix or with a small edit distance
+func (index *SearchIndex) Search(query string, limit int) []SearchResult {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	scores := make(map[string]float64)
+	matched := make(map[string]map[string]bool) // track id -> index tokens that matched
+	for _, qToken := range tokenize(query) {
+		best := make(map[string]float64) // the best score this query token gave each document
+		for token, docs := range index.postings {
+			score := matchScore(qToken, token)
+			if score == 0 {
+				continue
+			}
+			for id, fields := range docs {
+				// a hit in more than one field counts a little more than a hit in one
+				s := score + 0.1*float64(len(fields)-1)
+				if s > best[id] {
+					best[id] = s
+				}
+				if matched[id] == nil {
+					matched[id] = make(map[string]bool)
+				}
+				matched[id][token] = true
+			}
+		}
+		for id, s := range best {
+			scores[id] += s
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		fields := index.docs[id]
+		highlights := make(map[string]string)
+		for field, value := range fields {
+			if h, changed := highlight(value, matched[id]); changed {
+				highlights[field] = h
+			}
+		}
+		results = append(results, SearchResult{ID: id, Score: score, Fields: fields, Highlights: highlights})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// HandlerSearch is the handler for GET /api/search?q=<query>.
+// it responds with the ranked tracks matching the query and the highlighted fields
+func (sMgr *SearchMgr) HandlerSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		http.Error(w, "the q parameter is missing", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if limitS := r.URL.Query().Get("limit"); limitS != "" {
+		l, err := strconv.Atoi(limitS)
+		if err != nil || l <= 0 {
+			http.Error(w, "limit is not a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}{query, sMgr.Index.Search(query, limit)})
+}
+
+// trackSearchFields returns the fields of a track that should be searchable
+func trackSearchFields(track TrackInfo) map[string]string {
+	return map[string]string{"pilot": track.Pilot, "glider": track.Glider, "glider_id": track.GliderID, "site": track.Site}
+}
+
+// matchScore returns how well a query token matches an index token. 0 means no match
+func matchScore(qToken string, token string) float64 {
+	if qToken == token {
+		return searchScoreExact
+	}
+	if strings.HasPrefix(token, qToken) {
+		return searchScorePrefix
+	}
+	// allow one typo in short words and two in longer ones
+	maxDist := 1
+	if len(qToken) >= 6 {
+		maxDist = 2
+	}
+	if len(qToken) < 3 || abs(len(qToken)-len(token)) > maxDist {
+		return 0
+	}
+	if editDistance(qToken, token) <= maxDist {
+		return searchScoreFuzzy
+	}
+	return 0
+}
+
+// highlight wraps the words of value that are among the matched tokens in <em></em>. the rest of value is html
+// escaped, since the pilot and glider names come from the igc files. returns the result and wether anything was
+// highlighted
+func highlight(value string, matched map[string]bool) (string, bool) {
+	var sb strings.Builder
+	changed := false
+	word := []rune{}
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if matched[foldToken(string(word))] {
+			sb.WriteString("<em>" + html.EscapeString(string(word)) + "</em>")
+			changed = true
+		} else {
+			sb.WriteString(html.EscapeString(string(word)))
+		}
+		word = word[:0]
+	}
+	for _, c := range value {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			word = append(word, c)
+			continue
+		}
+		flush()
+		sb.WriteString(html.EscapeString(string(c)))
+	}
+	flush()
+	return sb.String(), changed
+}
+
+// tokenize splits a string into lowercase tokens on everything that is not a letter or a digit
+func tokenize(s string) []string {
+	words := strings.FieldsFunc(s, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		tokens = append(tokens, foldToken(w))
+	}
+	return tokens
+}
+
+// the letters folded so that eg. "Bjørn" and "bjorn" match
+var foldReplacer = strings.NewReplacer(
+	"æ", "ae", "ø", "o", "å", "a", "ä", "a", "ö", "o", "ü", "u", "ß", "ss",
+	"é", "e", "è", "e", "ê", "e", "á", "a", "à", "a", "â", "a", "í", "i", "ó", "o", "ô", "o", "ú", "u", "ñ", "n", "ç", "c")
+
+// foldToken lowercases a word and folds its accented letters
+func foldToken(word string) string {
+	return foldReplacer.Replace(strings.ToLower(word))
+}
+
+// editDistance returns the levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,89 @@
+package paragliding
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_SearchIndex(t *testing.T) {
+	index := NewSearchIndex()
+	index.Add("a", map[string]string{"pilot": "Ole Hansen", "glider": "Ozone Rush 5", "glider_id": "OZ1"})
+	index.Add("b", map[string]string{"pilot": "Bjørn Olsen", "glider": "Advance Iota", "glider_id": "ADV"})
+	index.Add("c", map[string]string{"pilot": "Kari Nordmann", "glider": "Ozone Delta 3", "glider_id": "OZ2"})
+
+	// exact match should give one result with the highlighted field
+	res := index.Search("hansen", 10)
+	if len(res) != 1 || res[0].ID != "a" {
+		t.Fatal("expected only track a for 'hansen'")
+	}
+	if res[0].Highlights["pilot"] != "Ole <em>Hansen</em>" {
+		t.Error("wrong highlight: " + res[0].Highlights["pilot"])
+	}
+
+	// the pilot names come from the igc files, so the highlights are escaped
+	index.Add("x", map[string]string{"pilot": "<script>alert(1)</script> Hansen"})
+	escaped := ""
+	for _, r := range index.Search("hansen", 10) {
+		if r.ID == "x" {
+			escaped = r.Highlights["pilot"]
+		}
+	}
+	if escaped != "&lt;script&gt;alert(1)&lt;/script&gt; <em>Hansen</em>" {
+		t.Error("the highlight was not escaped: " + escaped)
+	}
+	if h, _ := highlight(`<script src="x">`, map[string]bool{"script": true}); h != "&lt;<em>script</em> src=&#34;x&#34;&gt;" {
+		t.Error("the highlight was not escaped: " + h)
+	}
+	index.Remove("x")
+
+	// a typo and a folded letter should still match
+	if res := index.Search("hanson", 10); len(res) != 1 || res[0].ID != "a" {
+		t.Error("fuzzy match failed")
+	}
+	if res := index.Search("bjorn", 10); len(res) != 1 || res[0].ID != "b" {
+		t.Error("folded match failed")
+	}
+
+	// the track matching both words should be ranked first
+	res = index.Search("ozone delta", 10)
+	if len(res) != 2 || res[0].ID != "c" {
+		t.Error("wrong ranking for 'ozone delta'")
+	}
+
+	// removed tracks should not be found
+	index.Remove("c")
+	if res := index.Search("delta", 10); len(res) != 0 {
+		t.Error("removed track was found")
+	}
+	index.Clear()
+	if res := index.Search("ozone", 10); len(res) != 0 {
+		t.Error("cleared index returned results")
+	}
+}
+
+func Test_HandlerSearch(t *testing.T) {
+	sMgr := SearchMgr{Index: NewSearchIndex()}
+	sMgr.Index.Add("a", map[string]string{"pilot": "Ole Hansen"})
+
+	// missing query should be a bad request
+	req, err := http.NewRequest("GET", "/paragliding/api/search", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	res := httptest.NewRecorder()
+	http.HandlerFunc(sMgr.HandlerSearch).ServeHTTP(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Bad status response: expected %d got %d", http.StatusBadRequest, res.Code)
+	}
+
+	req, err = http.NewRequest("GET", "/paragliding/api/search?q=ole", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	res = httptest.NewRecorder()
+	http.HandlerFunc(sMgr.HandlerSearch).ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("Bad status response: expected %d got %d", http.StatusOK, res.Code)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +19,18 @@ type Server struct {
 	mgrWebhooks *WebHookMgr
 	mgrTrack    *TrackMgr
 	mgrAdmin    *AdminMgr
+	mgrSearch   *SearchMgr
+	mgrGraphQL  *GraphQLMgr
+	mgrPilot    *PilotMgr
+	mgrGlider   *GliderMgr
+	mgrSite     *SiteMgr
+	mgrBoards   *LeaderboardMgr
+	mgrTask     *TaskMgr
+	mgrWaypoint *WaypointMgr
+	mgrAirspace *AirspaceMgr
+	mgrCompare  *CompareMgr
+	mgrReplay   *ReplayMgr
+	mgrHeatmap  *HeatmapMgr
 	startTime   time.Time
 	//map request type (eg. GET/POST) that contains map of acceptable urls and the function to handle each url
 	urlHandlers map[string]map[string]func(http.ResponseWriter, *http.Request)
@@ -40,10 +53,74 @@ func (server *Server) Start() {
 	server.startTime = time.Now()
 	server.db = &Database{URI: os.Getenv("DB_URI"), Name: os.Getenv("DB_NAME")}
 	server.db.Connect()
+	if err := server.db.EnsureIndexes(); err != nil {
+		log.Println("could not create the indexes:", err)
+	}
 	server.mgrTicker = &MgrTicker{DB: server.db, PageCap: nPerPage}
-	server.mgrWebhooks = &WebHookMgr{DB: server.db, Ticker: server.mgrTicker}
-	server.mgrTrack = &TrackMgr{DB: server.db, WHMgr: server.mgrWebhooks}
-	server.mgrAdmin = &AdminMgr{DB: server.db}
+	// the webhooks are sent by 4 workers with a timeout of 10 seconds by default
+	workers, _ := strconv.Atoi(os.Getenv("WEBHOOK_WORKERS"))
+	timeout, _ := strconv.Atoi(os.Getenv("WEBHOOK_TIMEOUT"))
+	dispatcher := NewDispatcher(server.db, workers, time.Duration(timeout)*time.Second)
+	if days, err := strconv.Atoi(os.Getenv("WEBHOOK_LOG_RETENTION")); err == nil && days > 0 {
+		dispatcher.Retention = time.Duration(days) * 24 * time.Hour
+	}
+	dispatcher.AllowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+	dispatcher.Start()
+	server.mgrWebhooks = &WebHookMgr{DB: server.db, Ticker: server.mgrTicker, Dispatcher: dispatcher}
+	server.mgrWebhooks.StartScheduler(time.Minute)
+	server.mgrSearch = &SearchMgr{Index: NewSearchIndex()}
+	// the leaderboards default to the sum of the best 3 flights
+	bestN, _ := strconv.Atoi(os.Getenv("LEADERBOARD_BEST_N"))
+	server.mgrBoards = NewLeaderboardMgr(os.Getenv("LEADERBOARD_RULE"), bestN)
+	server.mgrBoards.VerifiedOnly = os.Getenv("LEADERBOARD_VERIFIED_ONLY") == "true"
+	server.mgrPilot = &PilotMgr{DB: server.db, Boards: server.mgrBoards}
+	gliders, err := LoadGliderRegistry(os.Getenv("GLIDER_RULES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.mgrGlider = &GliderMgr{DB: server.db, Registry: gliders}
+	server.mgrSite = &SiteMgr{DB: server.db, Search: server.mgrSearch.Index, Boards: server.mgrBoards}
+	validator, err := LoadIGCValidator(os.Getenv("IGC_VALIDATORS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	validator.MaxConcurrent, _ = strconv.Atoi(os.Getenv("IGC_MAX_VERIFICATIONS"))
+	// the igc files are fetched with the default limits, from any public host
+	fetcher := NewFetcher()
+	if hosts := os.Getenv("FETCH_ALLOWED_HOSTS"); hosts != "" {
+		fetcher.AllowedHosts = strings.Split(hosts, ",")
+	}
+	fetcher.AllowPrivate = os.Getenv("FETCH_ALLOW_PRIVATE") == "true"
+	if maxBytes, err := strconv.ParseInt(os.Getenv("FETCH_MAX_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		fetcher.MaxBytes = maxBytes
+	}
+	server.mgrTask = &TaskMgr{DB: server.db}
+	server.mgrWaypoint = &WaypointMgr{DB: server.db}
+	server.mgrCompare = &CompareMgr{DB: server.db}
+	server.mgrReplay = &ReplayMgr{DB: server.db}
+	server.mgrHeatmap = NewHeatmapMgr(server.db, os.Getenv("HEATMAP_CACHE_DIR"))
+	server.mgrAirspace = &AirspaceMgr{DB: server.db, WHMgr: server.mgrWebhooks}
+	if err := server.mgrAirspace.Load(); err != nil {
+		log.Fatal(err)
+	}
+	server.mgrTrack = &TrackMgr{DB: server.db, WHMgr: server.mgrWebhooks, Search: server.mgrSearch.Index,
+		Pilots: server.mgrPilot, Gliders: gliders, Sites: server.mgrSite, Boards: server.mgrBoards, Tasks: server.mgrTask,
+		Airspace: server.mgrAirspace, Heatmap: server.mgrHeatmap, Validity: validator,
+		Fetcher: fetcher}
+	server.mgrAdmin = &AdminMgr{DB: server.db, Search: server.mgrSearch.Index, Boards: server.mgrBoards, Heatmap: server.mgrHeatmap,
+		WHMgr: server.mgrWebhooks}
+	server.mgrGraphQL = &GraphQLMgr{DB: server.db, Ticker: server.mgrTicker, DevMode: os.Getenv("DEV_MODE") == "true"}
+
+	// fill the search index, the leaderboards and the heatmap with the tracks already stored
+	tracks, err := server.db.GetAllTracks()
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.mgrSearch.Index.Build(tracks)
+	server.mgrBoards.Load(tracks)
+	if err := server.mgrHeatmap.Load(tracks); err != nil {
+		log.Fatal(err)
+	}
 	server.initHandlers()
 
 	http.HandleFunc("/", server.urlHandler)
@@ -58,6 +135,7 @@ func (server *Server) initHandlers() {
 	server.urlHandlers["GET"] = make(map[string]func(http.ResponseWriter, *http.Request))
 	server.urlHandlers["POST"] = make(map[string]func(http.ResponseWriter, *http.Request))
 	server.urlHandlers["DELETE"] = make(map[string]func(http.ResponseWriter, *http.Request))
+	server.urlHandlers["PATCH"] = make(map[string]func(http.ResponseWriter, *http.Request))
 
 	// registering handlers
 	server.urlHandlers["GET"]["^/paragliding$"] = func(w http.ResponseWriter, r *http.Request) {
@@ -81,25 +159,76 @@ func (server *Server) initHandlers() {
 	server.urlHandlers["POST"]["^/paragliding/api/track$"] = server.mgrTrack.HandlerPostTrack
 	server.urlHandlers["GET"]["^/paragliding/api/track$"] = server.mgrTrack.HandlerGetAllTracks
 	server.urlHandlers["GET"]["^/paragliding/api/track/[a-zA-Z0-9]{1,100}$"] = server.mgrTrack.HandlerGetTrackByID
-	server.urlHandlers["GET"]["^/paragliding/api/track/[a-zA-Z0-9]{1,50}/[a-zA-Z0-9_.-]{1,50}$"] = server.mgrTrack.HandlerGetTrackFieldByID
+	// the fields are listed so they do not collide with the other track sub resources, like airspace
+	server.urlHandlers["GET"]["^/paragliding/api/track/[a-zA-Z0-9]{1,50}/(pilot|glider|glider_id|H_date|track_length|glider_ref|site|pilot_id|track_src_url|validity)$"] = server.mgrTrack.HandlerGetTrackFieldByID
+	server.urlHandlers["GET"]["^/paragliding/api/track/[a-zA-Z0-9]{1,50}/airspace$"] = server.mgrAirspace.HandlerGetTrackAirspace
+	server.urlHandlers["GET"]["^/paragliding/api/track/[a-zA-Z0-9]{1,50}/replay$"] = server.mgrReplay.HandlerReplayTrack
 	// ticker handlers
 	server.urlHandlers["GET"]["^/paragliding/api/ticker/latest$"] = server.mgrTicker.HandlerLatestTick
 	server.urlHandlers["GET"]["^/paragliding/api/ticker/$"] = server.mgrTicker.HandlerTicker
 	server.urlHandlers["GET"]["^/paragliding/api/ticker/[0-9]{1,20}$"] = server.mgrTicker.HandlerTickerByTimestamp
-	// webhook handlers
+	// pilot handlers
+	server.urlHandlers["POST"]["^/paragliding/api/pilots$"] = server.mgrPilot.HandlerPostPilot
+	server.urlHandlers["GET"]["^/paragliding/api/pilots$"] = server.mgrPilot.HandlerGetAllPilots
+	server.urlHandlers["GET"]["^/paragliding/api/pilots/[a-zA-Z0-9]{1,100}$"] = server.mgrPilot.HandlerGetPilotByID
+	server.urlHandlers["POST"]["^/paragliding/api/pilots/[a-zA-Z0-9]{1,100}/aliases$"] = server.mgrPilot.HandlerAddPilotAlias
+	// glider handlers
+	server.urlHandlers["GET"]["^/paragliding/api/gliders$"] = server.mgrGlider.HandlerGetGliders
+	// site handlers
+	server.urlHandlers["GET"]["^/paragliding/api/sites$"] = server.mgrSite.HandlerGetAllSites
+	server.urlHandlers["GET"]["^/paragliding/api/sites/[a-zA-Z0-9]{1,100}$"] = server.mgrSite.HandlerGetSiteByID
+	// leaderboard handlers
+	server.urlHandlers["GET"]["^/paragliding/api/leaderboards$"] = server.mgrBoards.HandlerGetLeaderboard
+	// task handlers
+	server.urlHandlers["GET"]["^/paragliding/api/tasks$"] = server.mgrTask.HandlerGetAllTasks
+	server.urlHandlers["GET"]["^/paragliding/api/tasks/[a-zA-Z0-9]{1,100}$"] = server.mgrTask.HandlerGetTaskByID
+	server.urlHandlers["GET"]["^/paragliding/api/tasks/[a-zA-Z0-9]{1,100}/results$"] = server.mgrTask.HandlerGetTaskResults
+	// waypoint handlers
+	server.urlHandlers["GET"]["^/paragliding/api/waypoints$"] = server.mgrWaypoint.HandlerGetWaypoints
+	// compare handlers
+	server.urlHandlers["GET"]["^/paragliding/api/compare$"] = server.mgrCompare.HandlerCompare
+	// replay handlers
+	server.urlHandlers["GET"]["^/paragliding/api/replay$"] = server.mgrReplay.HandlerReplay
+	server.urlHandlers["POST"]["^/paragliding/api/replay/[a-zA-Z0-9]{1,100}/control$"] = server.mgrReplay.HandlerReplayControl
+	// heatmap handlers
+	server.urlHandlers["GET"]["^/paragliding/api/heatmap/[0-9]{1,2}/[0-9]{1,9}/[0-9]{1,9}\\.(png|mvt)$"] = server.mgrHeatmap.HandlerGetTile
+	// search handlers
+	server.urlHandlers["GET"]["^/paragliding/api/search$"] = server.mgrSearch.HandlerSearch
+	// webhook handlers. the new_track routes are the old names of the webhooks routes
+	server.urlHandlers["POST"]["^/paragliding/api/webhooks$"] = server.mgrWebhooks.HandlerNewWebhook
+	server.urlHandlers["PATCH"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}$"] = server.mgrWebhooks.HandlerPatchWebhook
+	server.urlHandlers["POST"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}/pause$"] = server.mgrWebhooks.HandlerPauseWebhook
+	server.urlHandlers["POST"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}/resume$"] = server.mgrWebhooks.HandlerResumeWebhook
+	server.urlHandlers["POST"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}/test$"] = server.mgrWebhooks.HandlerTestWebhook
+	server.urlHandlers["GET"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}$"] = server.mgrWebhooks.HandlerGetWebhookHookByID
+	server.urlHandlers["DELETE"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}$"] = server.mgrWebhooks.HandlerDeleteWebhookHookByID
+	server.urlHandlers["GET"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}/deliveries$"] = server.mgrWebhooks.HandlerGetWebhookDeliveries
+	server.urlHandlers["POST"]["^/paragliding/api/webhooks/[a-zA-Z0-9]{1,100}/secret$"] = server.mgrWebhooks.HandlerRotateWebhookSecret
 	server.urlHandlers["POST"]["^/paragliding/api/webhook/new_track/$"] = server.mgrWebhooks.HandlerNewTrackWebHook
 	server.urlHandlers["GET"]["^/paragliding/api/webhook/new_track/[a-zA-Z0-9]{1,100}$"] = server.mgrWebhooks.HandlerGetWebhookHookByID
 	server.urlHandlers["DELETE"]["^/paragliding/api/webhook/new_track/[a-zA-Z0-9]{1,100}$"] = server.mgrWebhooks.HandlerDeleteWebhookHookByID
+	server.urlHandlers["GET"]["^/paragliding/api/webhook/new_track/[a-zA-Z0-9]{1,100}/deliveries$"] = server.mgrWebhooks.HandlerGetWebhookDeliveries
+	server.urlHandlers["POST"]["^/paragliding/api/webhook/new_track/[a-zA-Z0-9]{1,100}/secret$"] = server.mgrWebhooks.HandlerRotateWebhookSecret
+	server.urlHandlers["POST"]["^/paragliding/api/webhook/preview$"] = server.mgrWebhooks.HandlerPreviewWebhook
+	// graphql handler
+	server.urlHandlers["POST"]["^/paragliding/graphql$"] = server.mgrGraphQL.HandlerGraphQL
 	// admin handlers
 	server.urlHandlers["GET"]["^/paragliding/admin/api/tracks_count$"] = server.mgrAdmin.HandlerTrackCount
 	server.urlHandlers["DELETE"]["^/paragliding/admin/api/tracks$"] = server.mgrAdmin.HandlerDeleteAllTracks
+	server.urlHandlers["POST"]["^/paragliding/admin/api/sites$"] = server.mgrSite.HandlerImportSites
+	server.urlHandlers["POST"]["^/paragliding/admin/api/tasks$"] = server.mgrTask.HandlerPostTask
+	server.urlHandlers["POST"]["^/paragliding/admin/api/waypoints$"] = server.mgrWaypoint.HandlerImportWaypoints
+	server.urlHandlers["POST"]["^/paragliding/admin/api/airspace$"] = server.mgrAirspace.HandlerImportAirspace
+	server.urlHandlers["GET"]["^/paragliding/admin/api/webhooks$"] = server.mgrWebhooks.HandlerGetWebhooks
+	server.urlHandlers["GET"]["^/paragliding/admin/api/webhooks/dead_letters$"] = server.mgrWebhooks.Dispatcher.HandlerGetDeadLetters
+	server.urlHandlers["POST"]["^/paragliding/admin/api/webhooks/dead_letters/[a-zA-Z0-9]{1,100}/replay$"] = server.mgrWebhooks.Dispatcher.HandlerReplayDeadLetter
 
 }
 
 // urHandler is reponsible for routing the different requests to the correct handler
 func (server *Server) urlHandler(w http.ResponseWriter, r *http.Request) {
 	handlerMap, exists := server.urlHandlers[r.Method]
-	if !exists { // if not a request type we will handle (not GET, POST or DELETE in this case)
+	if !exists { // if not a request type we will handle (not GET, POST, PATCH or DELETE in this case)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
@@ -0,0 +1,84 @@
+package paragliding
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how long the previous secret of a webhook still signs the requests after it is rotated,
+// so the consumer has time to switch
+const secretGracePeriod = 24 * time.Hour
+
+// the shortest secret a webhook can be registered with
+const minSecretLength = 16
+
+// newWebhookSecret returns a random secret
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // the system has no randomness left, nothing can be signed safely
+	}
+	return hex.EncodeToString(b)
+}
+
+// webhookSecret returns the secret given at registration or rotation, or a new one if none was given
+func webhookSecret(given string) (string, error) {
+	if given == "" {
+		return newWebhookSecret(), nil
+	}
+	if len(given) < minSecretLength {
+		return "", errors.New("the secret must be at least 16 characters")
+	}
+	return given, nil
+}
+
+// signingSecrets returns the secrets the requests to the webhook are signed with at the time
+func signingSecrets(webhook WebhookInfo, now time.Time) []string {
+	var secrets []string
+	if webhook.Secret != "" {
+		secrets = append(secrets, webhook.Secret)
+	}
+	if webhook.PreviousSecret != "" && now.UnixNano()/int64(time.Millisecond) < webhook.PreviousExpires {
+		secrets = append(secrets, webhook.PreviousSecret)
+	}
+	return secrets
+}
+
+// HandlerRotateWebhookSecret is the handler for POST /api/webhook/new_track/<id>/secret. it replaces the secret
+// of the webhook with the secret in the body, or a new one if the body has none, and responds with it. the old
+// secret also signs the requests for 24 hours
+func (whMgr *WebHookMgr) HandlerRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	webhook, found := whMgr.DB.GetWebhookByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	var postData map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&postData); err != nil && err != io.EOF {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	secret, err := webhookSecret(postData["secret"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expires := time.Now().Add(secretGracePeriod).UnixNano() / int64(time.Millisecond)
+	webhook.Secret, webhook.PreviousSecret, webhook.PreviousExpires = secret, webhook.Secret, expires
+	if err := whMgr.DB.SetWebhookSecret(webhook); err != nil {
+		http.Error(w, "could not rotate the secret", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Secret          string `json:"secret"`
+		PreviousExpires int64  `json:"previous_expires"`
+	}{secret, expires})
+}
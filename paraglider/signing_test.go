@@ -0,0 +1,52 @@
+package paragliding
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/einarkb/asign2-Para/webhooksig"
+)
+
+func Test_WebhookSecret(t *testing.T) {
+	if secret, err := webhookSecret(""); err != nil || len(secret) != 64 {
+		t.Errorf("expected a generated secret, got %q %v", secret, err)
+	}
+	if secret, err := webhookSecret("a long enough secret"); err != nil || secret != "a long enough secret" {
+		t.Errorf("expected the given secret, got %q %v", secret, err)
+	}
+	if _, err := webhookSecret("short"); err == nil {
+		t.Error("expected an error for a short secret")
+	}
+}
+
+func Test_SigningSecrets(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	ms := now.UnixNano() / int64(time.Millisecond)
+	webhook := WebhookInfo{Secret: "new", PreviousSecret: "old", PreviousExpires: ms + 1000}
+	if secrets := signingSecrets(webhook, now); len(secrets) != 2 || secrets[0] != "new" || secrets[1] != "old" {
+		t.Errorf("expected both secrets during the grace period, got %v", secrets)
+	}
+	webhook.PreviousExpires = ms
+	if secrets := signingSecrets(webhook, now); len(secrets) != 1 {
+		t.Errorf("expected only the new secret after the grace period, got %v", secrets)
+	}
+	if secrets := signingSecrets(WebhookInfo{}, now); len(secrets) != 0 {
+		t.Errorf("expected no secrets for an old webhook, got %v", secrets)
+	}
+}
+
+func Test_DispatcherSignsDeliveries(t *testing.T) {
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = webhooksig.VerifyRequest(r, "old secret", webhooksig.DefaultTolerance)
+	}))
+	defer server.Close()
+	d := NewDispatcher(nil, 1, time.Second)
+	d.AllowPrivate = true
+	delivery := d.attempt(DeliveryInfo{URL: server.URL, Payload: `{"content":"hi"}`}, []string{"new secret", "old secret"})
+	if delivery.Status != DeliveryDelivered || verifyErr != nil {
+		t.Errorf("the delivery should be signed with both secrets: %v", verifyErr)
+	}
+}
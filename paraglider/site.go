@@ -0,0 +1,415 @@
+package paragliding
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// SiteMgr is the manager for takeoff sites
+type SiteMgr struct {
+	DB     *Database
+	Search *SearchIndex
+	Boards *LeaderboardMgr
+}
+
+// SiteDetails is the response for GET /api/sites/<id>
+type SiteDetails struct {
+	SiteInfo
+	ID          string       `json:"id"`
+	Flights     int          `json:"flights"`
+	BestFlights []SiteFlight `json:"best_flights"`
+	Months      [12]int      `json:"months"` // number of flights in each month, january first
+}
+
+// SiteFlight is a flight in the site details
+type SiteFlight struct {
+	ID       string  `json:"id"`
+	Pilot    string  `json:"pilot"`
+	Distance float64 `json:"distance"`
+	Date     string  `json:"date"`
+}
+
+// the number of flights listed in best_flights
+const siteBestFlights = 5
+
+// the layout of TrackInfo.HDate, which is time.Time.String()
+const hDateLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// HandlerImportSites is the handler for POST /admin/api/sites. the body is either csv with the columns
+// name,lat,lon,radius,orientation or an openair like file (see ParseOpenAirSites). waypoint files (cup, gpx and wpt)
+// can be imported as sites with a 500 meter radius by setting the format parameter. without the parameter the format is
+// guessed from the content. it responds with the ids of the new sites
+func (sMgr *SiteMgr) HandlerImportSites(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read the body", http.StatusBadRequest)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = guessSiteFormat(string(body))
+	}
+	var sites []SiteInfo
+	switch format {
+	case "csv":
+		sites, err = ParseSitesCSV(string(body))
+	case "openair":
+		sites, err = ParseOpenAirSites(string(body))
+	case "cup", "gpx", "wpt":
+		var waypoints []WaypointInfo
+		waypoints, err = ParseWaypoints(format, string(body))
+		sites = waypointSites(waypoints)
+	default:
+		http.Error(w, "unknown format: "+format, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ids := []string{}
+	for _, site := range sites {
+		site.ID = objectid.New()
+		id, added := sMgr.DB.Insert("sites", site)
+		if !added {
+			http.Error(w, "could not add site "+site.Name, http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err := sMgr.tagExistingTracks(); err != nil {
+		http.Error(w, "could not tag the existing tracks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+// HandlerGetAllSites is the handler for GET /api/sites. it replies with all the sites
+func (sMgr *SiteMgr) HandlerGetAllSites(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	sites, err := sMgr.DB.GetAllSites()
+	if err != nil {
+		http.Error(w, "Could not receive site list", http.StatusInternalServerError)
+		return
+	}
+	ids := []string{}
+	for _, site := range sites {
+		ids = append(ids, site.ID.Hex())
+	}
+	json.NewEncoder(w).Encode(ids)
+}
+
+// HandlerGetSiteByID is the handler for GET /api/sites/<id>.
+// it responds with the site, the number of flights, the best flights and the flights per month
+func (sMgr *SiteMgr) HandlerGetSiteByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	parts := strings.Split(r.URL.Path, "/")
+	site, found := sMgr.DB.GetSiteByID(parts[len(parts)-1]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	tracks, err := sMgr.DB.GetTracksBySite(site.ID.Hex())
+	if err != nil {
+		http.Error(w, "could not get the tracks of the site", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(CalculateSiteDetails(site, tracks))
+}
+
+// TagTrack sets the takeoff site of a track from its first fix. a takeoff that is not at a site, but within 500 meters
+// of an imported waypoint, gets the name of the waypoint as its site and no site id. it is tagged again if a site is
+// imported there later
+func (sMgr *SiteMgr) TagTrack(track *TrackInfo, points []TrackPoint) {
+	if len(points) == 0 {
+		return
+	}
+	sites, err := sMgr.DB.GetAllSites()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	waypoints, err := sMgr.DB.GetAllWaypoints()
+	if err != nil {
+		fmt.Println(err)
+	}
+	if site, found := FindTakeoffSite(sites, points[0].Lat, points[0].Lon); found {
+		track.SiteID, track.Site = site.ID.Hex(), site.Name
+	} else if site, found := FindTakeoffSite(waypointSites(waypoints), points[0].Lat, points[0].Lon); found {
+		track.Site = site.Name
+	}
+}
+
+// tagExistingTracks tags the stored tracks that do not have a site yet. used after sites are imported
+func (sMgr *SiteMgr) tagExistingTracks() error {
+	sites, err := sMgr.DB.GetAllSites()
+	if err != nil {
+		return err
+	}
+	tracks, err := sMgr.DB.GetAllTracks()
+	if err != nil {
+		return err
+	}
+	untagged := map[string]TrackInfo{}
+	var ids []string
+	for _, track := range tracks {
+		if track.SiteID == "" {
+			untagged[track.ID.Hex()] = track
+			ids = append(ids, track.ID.Hex())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	points, err := sMgr.DB.GetPointsByTrackIDs(ids)
+	if err != nil {
+		return err
+	}
+	var tagged []TrackInfo
+	// the site leaderboards get the tagged tracks, also when one of them could not be tagged
+	defer func() { sMgr.Boards.UpdateTracks(tagged) }()
+	for _, p := range points {
+		if len(p.Points) == 0 {
+			continue
+		}
+		site, found := FindTakeoffSite(sites, p.Points[0].Lat, p.Points[0].Lon)
+		if !found {
+			continue
+		}
+		if err := sMgr.DB.SetTrackSite(p.ID, site); err != nil {
+			return err
+		}
+		track := untagged[p.ID.Hex()]
+		track.SiteID, track.Site = site.ID.Hex(), site.Name
+		sMgr.Search.Add(track.ID.Hex(), trackSearchFields(track))
+		tagged = append(tagged, track)
+	}
+	return nil
+}
+
+// FindTakeoffSite returns the nearest site that has the position within its radius
+func FindTakeoffSite(sites []SiteInfo, lat float64, lon float64) (SiteInfo, bool) {
+	best := SiteInfo{}
+	bestDist := -1.0
+	for _, site := range sites {
+		d := DistanceKm(lat, lon, site.Lat, site.Lon) * 1000
+		if d <= site.Radius && (bestDist < 0 || d < bestDist) {
+			best, bestDist = site, d
+		}
+	}
+	return best, bestDist >= 0
+}
+
+// CalculateSiteDetails sums up the flights from a site
+func CalculateSiteDetails(site SiteInfo, tracks []TrackInfo) SiteDetails {
+	details := SiteDetails{SiteInfo: site, ID: site.ID.Hex(), Flights: len(tracks), BestFlights: []SiteFlight{}}
+	for _, track := range tracks {
+		distance, _ := strconv.ParseFloat(track.TrackLength, 64)
+		details.BestFlights = append(details.BestFlights, SiteFlight{ID: track.ID.Hex(), Pilot: track.Pilot,
+			Distance: distance, Date: track.HDate})
+		if date, err := time.Parse(hDateLayout, track.HDate); err == nil {
+			details.Months[date.Month()-1]++
+		}
+	}
+	sort.SliceStable(details.BestFlights, func(i, j int) bool {
+		return details.BestFlights[i].Distance > details.BestFlights[j].Distance
+	})
+	if len(details.BestFlights) > siteBestFlights {
+		details.BestFlights = details.BestFlights[:siteBestFlights]
+	}
+	return details
+}
+
+// ParseSitesCSV parses sites from csv with the columns name,lat,lon,radius,orientation.
+// a first line starting with "name" is treated as a header. radius is in meters
+func ParseSitesCSV(content string) ([]SiteInfo, error) {
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var sites []SiteInfo
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "name") {
+			continue
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("line %d: expected name,lat,lon,radius,orientation", i+1)
+		}
+		site := SiteInfo{Name: strings.TrimSpace(record[0])}
+		if site.Lat, err = strconv.ParseFloat(strings.TrimSpace(record[1]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude", i+1)
+		}
+		if site.Lon, err = strconv.ParseFloat(strings.TrimSpace(record[2]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude", i+1)
+		}
+		if site.Radius, err = strconv.ParseFloat(strings.TrimSpace(record[3]), 64); err != nil || site.Radius <= 0 {
+			return nil, fmt.Errorf("line %d: invalid radius", i+1)
+		}
+		if len(record) > 4 {
+			site.Orientation = strings.TrimSpace(record[4])
+		}
+		if err := validateSite(site); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// ParseOpenAirSites parses sites written like openair circles. each site starts with AN and has its
+// center in V X=, the radius in nautical miles in DC and optionaly the orientation in AO:
+//
+//	AN Kvamskogen
+//	V X=60:23:00 N 005:55:00 E
+//	DC 0.2
+//	AO SW
+func ParseOpenAirSites(content string) ([]SiteInfo, error) {
+	var sites []SiteInfo
+	var site *SiteInfo
+	hasCenter := false
+	finish := func(line int) error {
+		if site == nil {
+			return nil
+		}
+		if !hasCenter || site.Radius <= 0 {
+			return fmt.Errorf("line %d: site %s needs V X= and DC", line, site.Name)
+		}
+		if err := validateSite(*site); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		sites = append(sites, *site)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "*") {
+			continue
+		}
+		record, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			record, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch strings.ToUpper(record) {
+		case "AN":
+			if err := finish(lineNr); err != nil {
+				return nil, err
+			}
+			site, hasCenter = &SiteInfo{Name: value}, false
+		case "V":
+			if site == nil {
+				return nil, fmt.Errorf("line %d: V before AN", lineNr)
+			}
+			if !strings.HasPrefix(strings.ToUpper(value), "X=") {
+				continue
+			}
+			lat, lon, err := ParseOpenAirCoord(value[2:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNr, err)
+			}
+			site.Lat, site.Lon, hasCenter = lat, lon, true
+		case "DC":
+			if site == nil {
+				return nil, fmt.Errorf("line %d: DC before AN", lineNr)
+			}
+			nm, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid radius", lineNr)
+			}
+			site.Radius = nm * 1852
+		case "AO":
+			if site == nil {
+				return nil, fmt.Errorf("line %d: AO before AN", lineNr)
+			}
+			site.Orientation = value
+		}
+	}
+	if err := finish(lineNr); err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// an openair coordinate, eg. "60:23:00 N 005:55:00 E" or "39:29.9N 119:46.1W"
+var openAirCoordPattern = regexp.MustCompile(`^(\d+(?::\d+(?:\.\d+)?){0,2}(?:\.\d+)?)\s*([NS])\s*(\d+(?::\d+(?:\.\d+)?){0,2}(?:\.\d+)?)\s*([EW])$`)
+
+// ParseOpenAirCoord parses a coordinate in the openair format and returns it in decimal degrees
+func ParseOpenAirCoord(s string) (float64, float64, error) {
+	m := openAirCoordPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, 0, errors.New("invalid coordinate: " + s)
+	}
+	lat, lon := parseSexagesimal(m[1]), parseSexagesimal(m[3])
+	if m[2] == "S" {
+		lat = -lat
+	}
+	if m[4] == "W" {
+		lon = -lon
+	}
+	if lat > 90 || lon > 180 {
+		return 0, 0, errors.New("coordinate out of range: " + s)
+	}
+	return lat, lon, nil
+}
+
+// parseSexagesimal parses "dd:mm:ss", "dd:mm.mmm" or "dd.ddd" into degrees. the format is checked by the caller
+func parseSexagesimal(s string) float64 {
+	degrees := 0.0
+	factor := 1.0
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		degrees += v / factor
+		factor *= 60
+	}
+	return degrees
+}
+
+func validateSite(site SiteInfo) error {
+	if site.Name == "" {
+		return errors.New("the site has no name")
+	}
+	if site.Lat < -90 || site.Lat > 90 || site.Lon < -180 || site.Lon > 180 {
+		return errors.New("the coordinates of " + site.Name + " are out of range")
+	}
+	return nil
+}
+
+// guessSiteFormat returns openair if the content looks like an openair file and csv otherwise
+func guessSiteFormat(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "*") {
+			continue
+		}
+		upper := strings.ToUpper(line)
+		if strings.HasPrefix(upper, "AN ") || strings.HasPrefix(upper, "AC ") {
+			return "openair"
+		}
+		break
+	}
+	return "csv"
+}
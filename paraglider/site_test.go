@@ -0,0 +1,72 @@
+package paragliding
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_ParseSitesCSV(t *testing.T) {
+	sites, err := ParseSitesCSV("name,lat,lon,radius,orientation\nKvamskogen,60.38,5.92,300,SW\nVoss, 60.63, 6.41, 500\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 2 || sites[0].Name != "Kvamskogen" || sites[0].Orientation != "SW" || sites[1].Radius != 500 {
+		t.Errorf("wrong sites: %+v", sites)
+	}
+	if _, err := ParseSitesCSV("Bad,91,5,300"); err == nil {
+		t.Error("expected error for latitude out of range")
+	}
+}
+
+func Test_ParseOpenAirSites(t *testing.T) {
+	sites, err := ParseOpenAirSites("* sites\nAN Kvamskogen\nV X=60:23:00 N 005:55:00 E\nDC 0.2\nAO SW\n\nAN Voss\nV X=60:37.8N 006:24.6E\nDC 0.5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 2 || sites[0].Orientation != "SW" || math.Abs(sites[0].Radius-370.4) > 0.01 {
+		t.Errorf("wrong sites: %+v", sites)
+	}
+	if math.Abs(sites[1].Lat-60.63) > 0.0001 || math.Abs(sites[1].Lon-6.41) > 0.0001 {
+		t.Errorf("wrong coordinates: %v %v", sites[1].Lat, sites[1].Lon)
+	}
+	if _, err := ParseOpenAirSites("AN Missing radius\nV X=60:23:00 N 005:55:00 E\n"); err == nil {
+		t.Error("expected error for site without radius")
+	}
+}
+
+func Test_FindTakeoffSite(t *testing.T) {
+	sites := []SiteInfo{
+		{ID: objectid.New(), Name: "A", Lat: 60.0, Lon: 5.0, Radius: 1000},
+		{ID: objectid.New(), Name: "B", Lat: 60.005, Lon: 5.0, Radius: 1000},
+	}
+	// about 330m from B and 890m from A, so B is the nearest
+	if site, found := FindTakeoffSite(sites, 60.008, 5.0); !found || site.Name != "B" {
+		t.Error("expected site B")
+	}
+	if _, found := FindTakeoffSite(sites, 61, 5); found {
+		t.Error("expected no site")
+	}
+	// the imported waypoints are sites with a 500 meter radius when no site matches
+	waypoints := []WaypointInfo{{Name: "Hangur", Lat: 61, Lon: 5}}
+	if site, found := FindTakeoffSite(waypointSites(waypoints), 61.003, 5); !found || site.Name != "Hangur" {
+		t.Error("expected the waypoint Hangur")
+	}
+}
+
+func Test_CalculateSiteDetails(t *testing.T) {
+	site := SiteInfo{ID: objectid.New(), Name: "A"}
+	var tracks []TrackInfo
+	for i := 0; i < 7; i++ {
+		tracks = append(tracks, TrackInfo{ID: objectid.New(), TrackLength: []string{"1", "7", "3", "9", "2", "8", "4"}[i],
+			HDate: "2016-0" + string(rune('1'+i%3)) + "-19 00:00:00 +0000 UTC"})
+	}
+	details := CalculateSiteDetails(site, tracks)
+	if details.Flights != 7 || len(details.BestFlights) != 5 || details.BestFlights[0].Distance != 9 {
+		t.Errorf("wrong details: %+v", details)
+	}
+	if details.Months[0] != 3 || details.Months[1] != 2 || details.Months[2] != 2 {
+		t.Errorf("wrong months: %v", details.Months)
+	}
+}
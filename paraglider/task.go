@@ -0,0 +1,446 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	igc "github.com/marni/goigc"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// the start and goal types of a task
+const (
+	TaskStartRace    = "race"    // the speed section time starts at the start gate
+	TaskStartElapsed = "elapsed" // the speed section time starts when the pilot crosses the start
+	TaskGoalCylinder = "cylinder"
+	TaskGoalLine     = "line"
+)
+
+// the turnpoint types
+const (
+	TurnpointTakeoff = "takeoff"
+	TurnpointSSS     = "sss" // start of speed section
+	TurnpointNormal  = "turnpoint"
+	TurnpointESS     = "ess" // end of speed section
+	TurnpointGoal    = "goal"
+)
+
+// TaskMgr is the manager for competition tasks
+type TaskMgr struct {
+	DB *Database
+}
+
+// TaskDetails is the response for GET /api/tasks/<id>
+type TaskDetails struct {
+	TaskInfo
+	ID       string  `json:"id"`
+	Distance float64 `json:"distance"` // km
+}
+
+// Scoreboard is the response for GET /api/tasks/<id>/results
+type Scoreboard struct {
+	TaskID   string            `json:"task_id"`
+	Name     string            `json:"name"`
+	Distance float64           `json:"distance"` // km
+	Results  []ScoreboardEntry `json:"results"`
+}
+
+// ScoreboardEntry is the best result of a pilot in a task
+type ScoreboardEntry struct {
+	Rank int `json:"rank"`
+	TaskResult
+}
+
+// HandlerPostTask is the handler for POST /admin/api/tasks. the body is a task, see TaskInfo.
+// the tracks already stored that took off in the task window are validated against the new task. it responds with the id
+func (tMgr *TaskMgr) HandlerPostTask(w http.ResponseWriter, r *http.Request) {
+	var task TaskInfo
+	err := json.NewDecoder(r.Body).Decode(&task)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	waypoints, err := tMgr.DB.GetAllWaypoints()
+	if err != nil {
+		http.Error(w, "could not get the stored waypoints", http.StatusInternalServerError)
+		return
+	}
+	if err := resolveTaskWaypoints(&task, waypoints); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateTask(&task); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	task.ID = objectid.New()
+	id, added := tMgr.DB.Insert("tasks", task)
+	if !added {
+		http.Error(w, "could not add task", http.StatusInternalServerError)
+		return
+	}
+	if err := tMgr.validateExistingTracks(task); err != nil {
+		http.Error(w, "could not validate the stored tracks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{id})
+}
+
+// HandlerGetAllTasks is the handler for GET /api/tasks. it replies with an array of all task ids
+func (tMgr *TaskMgr) HandlerGetAllTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	tasks, err := tMgr.DB.GetAllTasks()
+	if err != nil {
+		http.Error(w, "Could not receive task list", http.StatusInternalServerError)
+		return
+	}
+	ids := []string{}
+	for _, task := range tasks {
+		ids = append(ids, task.ID.Hex())
+	}
+	json.NewEncoder(w).Encode(ids)
+}
+
+// HandlerGetTaskByID is the handler for GET /api/tasks/<id>. it responds with the task and its distance
+func (tMgr *TaskMgr) HandlerGetTaskByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	parts := strings.Split(r.URL.Path, "/")
+	task, found := tMgr.DB.GetTaskByID(parts[len(parts)-1]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	legs := taskLegs(task)
+	json.NewEncoder(w).Encode(TaskDetails{TaskInfo: task, ID: task.ID.Hex(), Distance: legs[len(legs)-1]})
+}
+
+// HandlerGetTaskResults is the handler for GET /api/tasks/<id>/results. it responds with the scoreboard of the task
+func (tMgr *TaskMgr) HandlerGetTaskResults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	parts := strings.Split(r.URL.Path, "/")
+	task, found := tMgr.DB.GetTaskByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	results, err := tMgr.DB.GetTaskResults(task.ID.Hex())
+	if err != nil {
+		http.Error(w, "could not get the results of the task", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(CalculateScoreboard(task, results))
+}
+
+// ValidateTrack validates a new track against every task it took off in the window of, and stores the results
+func (tMgr *TaskMgr) ValidateTrack(track TrackInfo, points []TrackPoint) {
+	if len(points) == 0 {
+		return
+	}
+	tasks, err := tMgr.DB.GetAllTasks()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, task := range tasks {
+		if points[0].Time < task.WindowOpen || points[0].Time > task.WindowClose {
+			continue
+		}
+		tMgr.storeResult(task, track, points)
+	}
+}
+
+// validateExistingTracks validates the stored tracks from the days of the task window. used after a task is added
+func (tMgr *TaskMgr) validateExistingTracks(task TaskInfo) error {
+	tracks, err := tMgr.DB.GetAllTracks()
+	if err != nil {
+		return err
+	}
+	// the header date is the day of the flight, so only those days need their points loaded
+	firstDay := time.Unix(0, task.WindowOpen*int64(time.Millisecond)).UTC().Truncate(24 * time.Hour)
+	lastDay := time.Unix(0, task.WindowClose*int64(time.Millisecond)).UTC()
+	byID := map[string]TrackInfo{}
+	var ids []string
+	for _, track := range tracks {
+		date, err := time.Parse(hDateLayout, track.HDate)
+		if err != nil || date.Before(firstDay) || date.After(lastDay) {
+			continue
+		}
+		byID[track.ID.Hex()] = track
+		ids = append(ids, track.ID.Hex())
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	points, err := tMgr.DB.GetPointsByTrackIDs(ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if len(p.Points) == 0 || p.Points[0].Time < task.WindowOpen || p.Points[0].Time > task.WindowClose {
+			continue
+		}
+		tMgr.storeResult(task, byID[p.ID.Hex()], p.Points)
+	}
+	return nil
+}
+
+func (tMgr *TaskMgr) storeResult(task TaskInfo, track TrackInfo, points []TrackPoint) {
+	result := ValidateFlight(task, points)
+	result.ID, result.TaskID, result.TrackID = objectid.New(), task.ID.Hex(), track.ID.Hex()
+	result.PilotID, result.Pilot = track.PilotID, track.Pilot
+	tMgr.DB.Insert("task_results", result)
+}
+
+// ValidateFlight checks the fixes of a flight against a task. the turnpoints have to be reached in order and only
+// fixes inside the task window count. the sss is reached by crossing its cylinder in the start direction after the
+// start gate, and it can be crossed again until the next turnpoint is reached. pilots that do not make goal get the
+// distance along the task to the point closest to the next turnpoint
+func ValidateFlight(task TaskInfo, points []TrackPoint) TaskResult {
+	result := TaskResult{Reached: []TurnpointTime{}}
+	tps := task.Turnpoints
+	sss, ess := taskSectionIndexes(task)
+	legs := taskLegs(task)
+	next := 0
+	progress := 0.0 // the best distance along the task
+	var prev *TrackPoint
+	for i := range points {
+		p := points[i]
+		if p.Time < task.WindowOpen || p.Time > task.WindowClose {
+			continue
+		}
+		// restarting is allowed until the turnpoint after the start is reached
+		if next == sss+1 && prev != nil && p.Time >= task.StartGate && crossesCylinder(*prev, p, tps[sss]) {
+			result.StartTime = p.Time
+			result.Reached[len(result.Reached)-1].Time = p.Time
+		}
+		for next < len(tps) && turnpointReached(task, next, prev, p) {
+			result.Reached = append(result.Reached, TurnpointTime{Index: next, Name: tps[next].Name, Time: p.Time})
+			if next == sss {
+				result.StartTime = p.Time
+			}
+			if next == ess {
+				result.ESSTime = p.Time
+			}
+			progress = legs[next]
+			next++
+		}
+		if next > sss && next < len(tps) {
+			remaining := math.Max(0, DistanceKm(p.Lat, p.Lon, tps[next].Lat, tps[next].Lon)-tps[next].Radius/1000)
+			progress = math.Max(progress, math.Max(legs[next-1], legs[next]-remaining))
+		}
+		prev = &points[i]
+	}
+
+	if next > sss {
+		result.Distance = progress
+	}
+	result.Goal = next == len(tps)
+	if result.ESSTime != 0 {
+		start := result.StartTime
+		if task.StartType == TaskStartRace {
+			start = task.StartGate
+		}
+		result.SpeedTime = (result.ESSTime - start) / 1000
+	}
+	return result
+}
+
+// turnpointReached returns true if the fix reaches the turnpoint with the given index
+func turnpointReached(task TaskInfo, index int, prev *TrackPoint, p TrackPoint) bool {
+	tp := task.Turnpoints[index]
+	switch {
+	case tp.Type == TurnpointSSS:
+		return prev != nil && p.Time >= task.StartGate && crossesCylinder(*prev, p, tp)
+	case tp.Type == TurnpointGoal && task.GoalType == TaskGoalLine:
+		return prev != nil && crossesGoalLine(*prev, p, task.Turnpoints[index-1], tp)
+	}
+	return DistanceKm(p.Lat, p.Lon, tp.Lat, tp.Lon)*1000 <= tp.Radius
+}
+
+// crossesCylinder returns true if the pilot went out of (exit) or into the cylinder between the two fixes
+func crossesCylinder(from TrackPoint, to TrackPoint, tp Turnpoint) bool {
+	wasInside := DistanceKm(from.Lat, from.Lon, tp.Lat, tp.Lon)*1000 <= tp.Radius
+	isInside := DistanceKm(to.Lat, to.Lon, tp.Lat, tp.Lon)*1000 <= tp.Radius
+	if tp.Exit {
+		return wasInside && !isInside
+	}
+	return !wasInside && isInside
+}
+
+// crossesGoalLine returns true if the pilot crossed the goal line between the two fixes in the direction of the
+// last leg. the line goes through the goal, perpendicular to the last leg, and is twice the radius long
+func crossesGoalLine(from TrackPoint, to TrackPoint, last Turnpoint, goal Turnpoint) bool {
+	// flat projection in meters around the goal, good enough for the length of a goal line
+	project := func(lat float64, lon float64) (float64, float64) {
+		const rad = math.Pi / 180
+		return (lon - goal.Lon) * rad * math.Cos(goal.Lat*rad) * igc.EarthRadius * 1000, (lat - goal.Lat) * rad * igc.EarthRadius * 1000
+	}
+	dx, dy := project(last.Lat, last.Lon)
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return false
+	}
+	dx, dy = -dx/length, -dy/length // the direction of the last leg
+	x1, y1 := project(from.Lat, from.Lon)
+	x2, y2 := project(to.Lat, to.Lon)
+	along1, along2 := x1*dx+y1*dy, x2*dx+y2*dy
+	if along1 >= 0 || along2 < 0 {
+		return false
+	}
+	across1, across2 := x1*dy-y1*dx, x2*dy-y2*dx
+	t := -along1 / (along2 - along1)
+	return math.Abs(across1+t*(across2-across1)) <= goal.Radius
+}
+
+// taskSectionIndexes returns the indexes of the sss and the ess. the goal is the ess if the task has none
+func taskSectionIndexes(task TaskInfo) (int, int) {
+	sss, ess := -1, len(task.Turnpoints)-1
+	for i, tp := range task.Turnpoints {
+		switch tp.Type {
+		case TurnpointSSS:
+			sss = i
+		case TurnpointESS:
+			ess = i
+		}
+	}
+	return sss, ess
+}
+
+// taskLegs returns the distance in km from the first turnpoint to each turnpoint, measured between the centres
+func taskLegs(task TaskInfo) []float64 {
+	legs := make([]float64, len(task.Turnpoints))
+	for i := 1; i < len(task.Turnpoints); i++ {
+		a, b := task.Turnpoints[i-1], task.Turnpoints[i]
+		legs[i] = legs[i-1] + DistanceKm(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+	return legs
+}
+
+// CalculateScoreboard ranks the best result of each pilot. pilots in goal are ranked by speed section time,
+// the rest by distance
+func CalculateScoreboard(task TaskInfo, results []TaskResult) Scoreboard {
+	legs := taskLegs(task)
+	board := Scoreboard{TaskID: task.ID.Hex(), Name: task.Name, Distance: legs[len(legs)-1], Results: []ScoreboardEntry{}}
+	best := map[string]TaskResult{}
+	for _, result := range results {
+		key := result.PilotID
+		if key == "" {
+			key = "name:" + NormalizePilotName(result.Pilot)
+		}
+		if current, exists := best[key]; !exists || betterResult(result, current) {
+			best[key] = result
+		}
+	}
+	for _, result := range best {
+		board.Results = append(board.Results, ScoreboardEntry{TaskResult: result})
+	}
+	sort.SliceStable(board.Results, func(i, j int) bool {
+		a, b := board.Results[i].TaskResult, board.Results[j].TaskResult
+		return betterResult(a, b) || (!betterResult(b, a) && a.Pilot < b.Pilot)
+	})
+	for i := range board.Results {
+		board.Results[i].Rank = i + 1
+		if i > 0 && !betterResult(board.Results[i-1].TaskResult, board.Results[i].TaskResult) {
+			board.Results[i].Rank = board.Results[i-1].Rank
+		}
+	}
+	return board
+}
+
+// betterResult returns true if a is ranked before b
+func betterResult(a TaskResult, b TaskResult) bool {
+	if a.Goal != b.Goal {
+		return a.Goal
+	}
+	if a.Goal && a.SpeedTime != b.SpeedTime {
+		return a.SpeedTime < b.SpeedTime
+	}
+	return a.Distance > b.Distance
+}
+
+// resolveTaskWaypoints sets the position of the turnpoints that refer to a stored waypoint
+func resolveTaskWaypoints(task *TaskInfo, waypoints []WaypointInfo) error {
+	for i := range task.Turnpoints {
+		tp := &task.Turnpoints[i]
+		if tp.Waypoint == "" {
+			continue
+		}
+		waypoint, found := FindWaypointByName(waypoints, tp.Waypoint)
+		if !found {
+			return errors.New("unknown waypoint: " + tp.Waypoint)
+		}
+		tp.Lat, tp.Lon = waypoint.Lat, waypoint.Lon
+		if tp.Name == "" {
+			tp.Name = waypoint.Name
+		}
+	}
+	return nil
+}
+
+// validateTask checks a posted task and fills in the default start and goal types
+func validateTask(task *TaskInfo) error {
+	if strings.TrimSpace(task.Name) == "" {
+		return errors.New("the task has no name")
+	}
+	if task.StartType == "" {
+		task.StartType = TaskStartRace
+	}
+	if task.StartType != TaskStartRace && task.StartType != TaskStartElapsed {
+		return errors.New("start_type must be race or elapsed")
+	}
+	if task.GoalType == "" {
+		task.GoalType = TaskGoalCylinder
+	}
+	if task.GoalType != TaskGoalCylinder && task.GoalType != TaskGoalLine {
+		return errors.New("goal_type must be cylinder or line")
+	}
+	if task.WindowClose <= task.WindowOpen {
+		return errors.New("the window must close after it opens")
+	}
+	if task.StartGate == 0 {
+		task.StartGate = task.WindowOpen
+	}
+	if task.StartGate < task.WindowOpen || task.StartGate > task.WindowClose {
+		return errors.New("the start gate must be inside the window")
+	}
+	if len(task.Turnpoints) < 2 {
+		return errors.New("the task needs at least a start and a goal")
+	}
+	counts := map[string]int{}
+	for i, tp := range task.Turnpoints {
+		switch tp.Type {
+		case TurnpointTakeoff, TurnpointSSS, TurnpointNormal, TurnpointESS, TurnpointGoal:
+		default:
+			return fmt.Errorf("turnpoint %d has the unknown type %s", i+1, tp.Type)
+		}
+		if tp.Radius <= 0 {
+			return fmt.Errorf("turnpoint %d has no radius", i+1)
+		}
+		if tp.Lat < -90 || tp.Lat > 90 || tp.Lon < -180 || tp.Lon > 180 {
+			return fmt.Errorf("the coordinates of turnpoint %d are out of range", i+1)
+		}
+		counts[tp.Type]++
+	}
+	if counts[TurnpointSSS] != 1 || counts[TurnpointESS] > 1 || counts[TurnpointGoal] != 1 || counts[TurnpointTakeoff] > 1 {
+		return errors.New("the task must have one sss, one goal and at most one takeoff and ess")
+	}
+	sss, ess := taskSectionIndexes(*task)
+	if task.Turnpoints[len(task.Turnpoints)-1].Type != TurnpointGoal || ess <= sss ||
+		(counts[TurnpointTakeoff] == 1 && task.Turnpoints[0].Type != TurnpointTakeoff) {
+		return errors.New("the turnpoints must be in the order takeoff, sss, ess and goal")
+	}
+	return nil
+}
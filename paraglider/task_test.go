@@ -0,0 +1,96 @@
+package paragliding
+
+import (
+	"math"
+	"testing"
+)
+
+func testTask() TaskInfo {
+	return TaskInfo{Name: "task 1", StartType: TaskStartRace, GoalType: TaskGoalLine,
+		StartGate: 600000, WindowOpen: 0, WindowClose: 3600000,
+		Turnpoints: []Turnpoint{
+			{Name: "takeoff", Lat: 60, Lon: 5, Radius: 400, Type: TurnpointTakeoff},
+			{Name: "start", Lat: 60, Lon: 5, Radius: 2000, Type: TurnpointSSS, Exit: true},
+			{Name: "tp1", Lat: 60, Lon: 5.2, Radius: 400, Type: TurnpointNormal},
+			{Name: "goal", Lat: 60.1, Lon: 5.2, Radius: 200, Type: TurnpointGoal},
+		}}
+}
+
+func testFlight(positions ...[2]float64) []TrackPoint {
+	var points []TrackPoint
+	for i, pos := range positions {
+		points = append(points, TrackPoint{Time: int64(i) * 300000, Lat: pos[0], Lon: pos[1]})
+	}
+	return points
+}
+
+func Test_ValidateFlight(t *testing.T) {
+	task := testTask()
+	// leaves the start before the gate, restarts after it and crosses the goal line
+	goal := ValidateFlight(task, testFlight([2]float64{60, 5}, [2]float64{60, 5.05}, [2]float64{60, 5}, [2]float64{60, 5.05},
+		[2]float64{60, 5.2}, [2]float64{60.05, 5.2}, [2]float64{60.101, 5.2}))
+	if !goal.Goal || len(goal.Reached) != 4 || goal.StartTime != 900000 || goal.ESSTime != 1800000 || goal.SpeedTime != 1200 {
+		t.Errorf("wrong goal result: %+v", goal)
+	}
+	if legs := taskLegs(task); math.Abs(goal.Distance-legs[3]) > 0.001 {
+		t.Errorf("goal pilot should get the task distance, got %f", goal.Distance)
+	}
+
+	// lands half way to tp1
+	short := ValidateFlight(task, testFlight([2]float64{60, 5}, [2]float64{60, 5}, [2]float64{60, 5}, [2]float64{60, 5.05}, [2]float64{60, 5.1}))
+	if short.Goal || len(short.Reached) != 2 || short.SpeedTime != 0 || math.Abs(short.Distance-5.97) > 0.05 {
+		t.Errorf("wrong result for landing early: %+v", short)
+	}
+
+	// passing next to the goal line does not count
+	missed := ValidateFlight(task, testFlight([2]float64{60, 5}, [2]float64{60, 5}, [2]float64{60, 5}, [2]float64{60, 5.05}, [2]float64{60, 5.2},
+		[2]float64{60.05, 5.21}, [2]float64{60.101, 5.21}))
+	if missed.Goal {
+		t.Error("crossed outside the goal line but got goal")
+	}
+}
+
+func Test_CalculateScoreboard(t *testing.T) {
+	results := []TaskResult{
+		{PilotID: "a", Pilot: "a", Distance: 10},
+		{PilotID: "b", Pilot: "b", Goal: true, SpeedTime: 3000, Distance: 20},
+		{PilotID: "c", Pilot: "c", Goal: true, SpeedTime: 2000, Distance: 20},
+		{PilotID: "a", Pilot: "a", Distance: 15},
+	}
+	board := CalculateScoreboard(testTask(), results)
+	if len(board.Results) != 3 || board.Results[0].Pilot != "c" || board.Results[1].Pilot != "b" ||
+		board.Results[2].Distance != 15 || board.Results[2].Rank != 3 {
+		t.Errorf("wrong scoreboard: %+v", board.Results)
+	}
+}
+
+func Test_validateTask(t *testing.T) {
+	task := testTask()
+	task.StartType, task.StartGate = "", 0
+	if err := validateTask(&task); err != nil || task.StartType != TaskStartRace || task.StartGate != task.WindowOpen {
+		t.Errorf("defaults not set: %v", err)
+	}
+	task = testTask()
+	task.Turnpoints[0], task.Turnpoints[1] = task.Turnpoints[1], task.Turnpoints[0]
+	if err := validateTask(&task); err == nil {
+		t.Error("expected error for takeoff after the start")
+	}
+	task = testTask()
+	task.WindowClose = task.WindowOpen
+	if err := validateTask(&task); err == nil {
+		t.Error("expected error for empty window")
+	}
+}
+
+func Test_resolveTaskWaypoints(t *testing.T) {
+	task := testTask()
+	task.Turnpoints[2] = Turnpoint{Waypoint: "voss", Radius: 400, Type: TurnpointNormal}
+	waypoints := []WaypointInfo{{Name: "Voss", Lat: 60.63, Lon: 6.41}}
+	if err := resolveTaskWaypoints(&task, waypoints); err != nil || task.Turnpoints[2].Name != "Voss" || task.Turnpoints[2].Lat != 60.63 {
+		t.Errorf("waypoint not resolved: %+v %v", task.Turnpoints[2], err)
+	}
+	task.Turnpoints[2].Waypoint = "unknown"
+	if err := resolveTaskWaypoints(&task, waypoints); err == nil {
+		t.Error("expected error for unknown waypoint")
+	}
+}
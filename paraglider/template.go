@@ -0,0 +1,328 @@
+package paragliding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+)
+
+// the limits of a webhook template
+const (
+	maxTemplateLength     = 2000
+	maxMessageLength      = 2000 // the longest discord message
+	maxTemplateIterations = 10000
+	templateTimeout       = 100 * time.Millisecond
+)
+
+// TemplateData is what the template of a webhook is rendered with. the template is a go text/template, eg.
+//
+//	{{pilot}} just flew {{distance}} km from {{site}}
+//	{{.Count}} new tracks: {{range .Tracks}}{{.Pilot}} ({{km .Distance}} km) {{end}}
+//
+// pilot, glider, distance and site are the values of the first track, and km formats a distance with one decimal.
+// join, upper and lower work like the functions of the strings package. range only works over .Tracks, and a
+// template can not define other templates
+type TemplateData struct {
+	Event     string         // the event type, eg. track.created
+	Timestamp int64          // unix time in milliseconds the event happened
+	Count     int            // the number of tracks
+	Track     TrackSummary   // the first track
+	Tracks    []TrackSummary // Pilot, Glider, GliderClass, Distance (km), Date, Site, Validity and Timestamp
+	Ticker    Response       // TLatest, TStart, TStop and Processing of the ticker
+	Text      string         // the default text of the event
+}
+
+// the functions of the templates. the first track functions are replaced when a template is rendered
+var templateFuncs = template.FuncMap{
+	"pilot":    func() string { return "" },
+	"glider":   func() string { return "" },
+	"distance": func() string { return "" },
+	"site":     func() string { return "" },
+	"km":       func(distance float64) string { return strconv.FormatFloat(distance, 'f', 1, 64) },
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	// counts the iterations of the ranges, added to every range by sandboxTemplate
+	"iteration": func() (string, error) { return "", nil },
+}
+
+// ParseWebhookTemplate parses the template and renders it with an example event, so mistakes like unknown fields
+// are found when the webhook is registered
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	if len(text) > maxTemplateLength {
+		return nil, errors.New("the template is longer than " + strconv.Itoa(maxTemplateLength) + " characters")
+	}
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeTemplate(tmpl, exampleEvent()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderTemplate renders the template with the event. the message is cut at 2000 characters
+func RenderTemplate(text string, event WebhookEvent) (string, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(tmpl, event)
+}
+
+// parseTemplate parses the template and sandboxes it
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := sandboxTemplate(tmpl); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// sandboxTemplate checks that the template only uses actions, conditions and ranges over the tracks, and makes every
+// range call iteration, so a template with nested ranges is stopped by executeTemplate instead of running for long.
+// defining and calling other templates is not allowed, since they can call themselves
+func sandboxTemplate(tmpl *template.Template) error {
+	if len(tmpl.Templates()) > 1 {
+		return errors.New("the template can not define templates")
+	}
+	counter, err := template.New("iteration").Funcs(templateFuncs).Parse("{{iteration}}")
+	if err != nil {
+		return err
+	}
+	iteration := counter.Tree.Root.Nodes[0]
+	var walk func(node parse.Node) error
+	walk = func(node parse.Node) error {
+		switch n := node.(type) {
+		case nil, *parse.TextNode, *parse.FieldNode, *parse.VariableNode, *parse.DotNode, *parse.IdentifierNode,
+			*parse.StringNode, *parse.NumberNode, *parse.BoolNode, *parse.NilNode, *parse.BreakNode, *parse.ContinueNode:
+			return nil
+		case *parse.ListNode:
+			if n == nil {
+				return nil
+			}
+			for _, child := range n.Nodes {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *parse.ActionNode:
+			return walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return nil
+			}
+			for _, cmd := range n.Cmds {
+				for _, arg := range cmd.Args {
+					if err := walk(arg); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		case *parse.ChainNode:
+			return walk(n.Node)
+		case *parse.IfNode:
+			return walkBranch(walk, &n.BranchNode)
+		case *parse.WithNode:
+			return walkBranch(walk, &n.BranchNode)
+		case *parse.RangeNode:
+			if !rangesOverTracks(n.Pipe) {
+				return errors.New("range can only be used over .Tracks")
+			}
+			if n.List != nil {
+				n.List.Nodes = append([]parse.Node{iteration.Copy()}, n.List.Nodes...)
+			}
+			return walkBranch(walk, &n.BranchNode)
+		}
+		return fmt.Errorf("%s is not allowed in a template", node)
+	}
+	return walk(tmpl.Tree.Root)
+}
+
+func walkBranch(walk func(node parse.Node) error, branch *parse.BranchNode) error {
+	for _, node := range []parse.Node{branch.Pipe, branch.List, branch.ElseList} {
+		if err := walk(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangesOverTracks returns true if the pipeline is .Tracks or $.Tracks, the only list of the template data
+func rangesOverTracks(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	var ident []string
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		ident = arg.Ident
+	case *parse.VariableNode:
+		if len(arg.Ident) < 2 || arg.Ident[0] != "$" {
+			return false
+		}
+		ident = arg.Ident[1:]
+	}
+	return len(ident) == 1 && ident[0] == "Tracks"
+}
+
+// executeTemplate renders the template, which was sandboxed by parseTemplate. it stops after 10000 iterations of
+// the ranges, or after 100 milliseconds
+func executeTemplate(tmpl *template.Template, event WebhookEvent) (string, error) {
+	data := TemplateData{Event: event.Type, Timestamp: event.Timestamp, Count: len(event.Tracks), Tracks: event.Tracks,
+		Text: eventText(event)}
+	if len(event.Tracks) > 0 {
+		data.Track = event.Tracks[0]
+	}
+	if event.Ticker != nil {
+		data.Ticker = *event.Ticker
+	}
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	iterations, deadline := 0, time.Now().Add(templateTimeout)
+	tmpl.Funcs(template.FuncMap{
+		"pilot":    func() string { return data.Track.Pilot },
+		"glider":   func() string { return data.Track.Glider },
+		"distance": func() string { return strconv.FormatFloat(data.Track.Distance, 'f', 1, 64) },
+		"site":     func() string { return data.Track.Site },
+		"iteration": func() (string, error) {
+			if iterations++; iterations > maxTemplateIterations {
+				return "", errors.New("the template repeats more than " + strconv.Itoa(maxTemplateIterations) + " times")
+			}
+			if time.Now().After(deadline) {
+				return "", errors.New("the template took too long")
+			}
+			return "", nil
+		},
+	})
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&limitedWriter{w: &buf, n: maxMessageLength * utf8.UTFMax}, data); err != nil &&
+		err != errMessageTooLong {
+		return "", err
+	}
+	return truncateMessage(buf.String()), nil
+}
+
+// truncateMessage cuts the message to the longest discord message
+func truncateMessage(message string) string {
+	if utf8.RuneCountInString(message) > maxMessageLength {
+		return string([]rune(message)[:maxMessageLength])
+	}
+	return message
+}
+
+var errMessageTooLong = errors.New("the message is too long")
+
+// limitedWriter stops a template that writes too much, like a range over a large list
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		l.w.Write(p[:l.n])
+		l.n = 0
+		return 0, errMessageTooLong
+	}
+	l.n -= len(p)
+	return l.w.Write(p)
+}
+
+// exampleEvent is the event templates are checked and previewed with when there are no tracks
+func exampleEvent() WebhookEvent {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return WebhookEvent{Type: EventTrackCreated, Timestamp: now, Ticker: &Response{TLatest: now, TStart: now, TStop: now},
+		Tracks: []TrackSummary{{ID: "5bd5e4a1c2a8b73f1c5e9d01", Pilot: "Ola Nordmann", Glider: "Ozone Rush 5",
+			GliderClass: "EN-B", Distance: 52.3, Date: "2018-05-02 00:00:00 +0000 UTC", Site: "Voss", Timestamp: now}}}
+}
+
+// renderWebhookPayload renders the event for the webhook, with the text from its template if it has one
+func renderWebhookPayload(webhook WebhookInfo, event WebhookEvent) ([]byte, error) {
+	if webhook.Template != "" {
+		text, err := RenderTemplate(webhook.Template, event)
+		if err != nil {
+			return nil, err
+		}
+		event.Text = text
+	}
+	return RenderPayload(webhook.Format, event)
+}
+
+// HandlerPreviewWebhook is the handler for POST /api/webhook/preview. the body has a template, a format and a filter,
+// or the id of a webhook to use its own. it responds with the text and the payload the webhook would get
+// for the latest tracks, without sending it
+func (whMgr *WebHookMgr) HandlerPreviewWebhook(w http.ResponseWriter, r *http.Request) {
+	var postData map[string]string
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	webhook := WebhookInfo{Template: postData["template"], Format: postData["format"], Filter: postData["filter"]}
+	if id := postData["id"]; id != "" {
+		var found bool
+		if webhook, found = whMgr.DB.GetWebhookByID(id); !found {
+			http.Error(w, "the id does not exist", http.StatusNotFound)
+			return
+		}
+	}
+	if !validFormat(webhook.Format) {
+		http.Error(w, "format must be discord, slack, teams or json", http.StatusBadRequest)
+		return
+	}
+	if webhook.Template != "" {
+		if _, err := ParseWebhookTemplate(webhook.Template); err != nil {
+			http.Error(w, "invalid template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if webhook.Filter != "" {
+		if _, err := ParseTrackFilter(webhook.Filter); err != nil {
+			http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the latest page of the matching tracks
+	event := exampleEvent()
+	if tickerResp, err := whMgr.Ticker.GetLatestFiltered(whMgr.trackMatcher(webhook)); err == nil && len(tickerResp.TrackIDs) > 0 {
+		if tracks, err := whMgr.tickerTracks(tickerResp); err == nil {
+			event.Ticker, event.Tracks = &tickerResp, tracks
+		}
+	}
+	payload, err := renderWebhookPayload(webhook, event)
+	if err != nil {
+		http.Error(w, "could not render the payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	text := eventText(event)
+	if webhook.Template != "" {
+		text, _ = RenderTemplate(webhook.Template, event)
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Text    string          `json:"text"`
+		Payload json.RawMessage `json:"payload"`
+	}{text, payload})
+}
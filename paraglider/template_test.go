@@ -0,0 +1,60 @@
+package paragliding
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func Test_ParseWebhookTemplate(t *testing.T) {
+	for _, text := range []string{"{{pilot}} just flew {{distance}} km from {{site}}",
+		"{{.Count}} new tracks: {{range .Tracks}}{{.Pilot}} ({{km .Distance}} km) {{end}}", "{{upper .Track.Glider}}"} {
+		if _, err := ParseWebhookTemplate(text); err != nil {
+			t.Errorf("%q should be valid: %v", text, err)
+		}
+	}
+	for _, text := range []string{"{{pilot", "{{.Speed}}", "{{exec \"rm\"}}", "{{.Track.Pilot.Name}}", strings.Repeat("a", 2001),
+		"{{range 1000000}}{{range 1000000}}{{end}}{{end}}", "{{range .Count}}{{end}}",
+		`{{define "a"}}{{template "a"}}{{end}}{{template "a"}}`} {
+		if _, err := ParseWebhookTemplate(text); err == nil {
+			t.Errorf("%q should be invalid", text)
+		}
+	}
+}
+
+func Test_RenderTemplateIterations(t *testing.T) {
+	event := testEvent()
+	for len(event.Tracks) < 100 {
+		event.Tracks = append(event.Tracks, event.Tracks[0])
+	}
+	start := time.Now()
+	_, err := RenderTemplate(strings.Repeat("{{range $.Tracks}}", 4)+strings.Repeat("{{end}}", 4), event)
+	if err == nil || time.Since(start) > time.Second {
+		t.Errorf("the nested ranges should be stopped, got %v after %s", err, time.Since(start))
+	}
+}
+
+func Test_RenderTemplate(t *testing.T) {
+	event := testEvent()
+	text, err := RenderTemplate("{{pilot}} just flew {{distance}} km from {{site}}", event)
+	if err != nil || text != `Ole "the eagle" just flew 52.3 km from Voss` {
+		t.Errorf("wrong text %q %v", text, err)
+	}
+	text, _ = RenderTemplate("{{.Count}}:{{range .Tracks}} {{lower .Glider}}{{end}} at {{.Ticker.TLatest}}", event)
+	if text != "2: rush 5 zeno at 1530000000000" {
+		t.Errorf("wrong text %q", text)
+	}
+	// a long message is cut
+	text, err = RenderTemplate(strings.Repeat("{{range $.Tracks}}", 5)+"{{$.Text}}"+strings.Repeat("{{end}}", 5), event)
+	if err != nil || utf8.RuneCountInString(text) != maxMessageLength {
+		t.Errorf("the message should be cut at %d characters, got %d %v", maxMessageLength, utf8.RuneCountInString(text), err)
+	}
+}
+
+func Test_RenderWebhookPayload(t *testing.T) {
+	payload, err := renderWebhookPayload(WebhookInfo{Format: FormatSlack, Template: "{{pilot}} flew {{distance}} km"}, testEvent())
+	if err != nil || !strings.Contains(string(payload), `"text":"Ole \"the eagle\" flew 52.3 km"`) {
+		t.Errorf("the payload should have the text of the template: %s %v", payload, err)
+	}
+}
@@ -97,6 +97,15 @@ func (mgrTicker *MgrTicker) HandlerTickerByTimestamp(w http.ResponseWriter, r *h
 // GetTickerByTimeStamp returns  the latest added track, the first and last after specified timestamp, and the processing time
 // returns the reponse, and error if present
 func (mgrTicker *MgrTicker) GetTickerByTimeStamp(timestamp int64) (Response, error) {
+	return mgrTicker.GetTickerFiltered(timestamp, nil, 0)
+}
+
+// GetTickerFiltered is GetTickerByTimeStamp with only the tracks match returns true for, and at most limit tracks
+// instead of the page cap if limit is set. TLatest is still the latest added track. a nil match matches every track
+func (mgrTicker *MgrTicker) GetTickerFiltered(timestamp int64, match func(track TrackInfo) bool, limit int) (Response, error) {
+	if limit <= 0 {
+		limit = mgrTicker.PageCap
+	}
 	startTime := time.Now()
 	tickerResp := Response{}
 	tracks, err := mgrTicker.DB.GetAllTracks()
@@ -115,13 +124,13 @@ func (mgrTicker *MgrTicker) GetTickerByTimeStamp(timestamp int64) (Response, err
 	addedCount := 0
 	// loops through the found tracks and append thil pagecap or unthil the end if less than pagecap
 	for _, v := range tracks {
-		if v.Timestamp > timestamp {
+		if v.Timestamp > timestamp && (match == nil || match(v)) {
 			tickerResp.TrackIDs = append(tickerResp.TrackIDs, v.ID)
 			if addedCount == 0 {
 				tickerResp.TStart = v.Timestamp
 			}
 			addedCount++
-			if addedCount == mgrTicker.PageCap {
+			if addedCount == limit {
 				tickerResp.TStop = v.Timestamp
 				break
 			}
@@ -130,3 +139,39 @@ func (mgrTicker *MgrTicker) GetTickerByTimeStamp(timestamp int64) (Response, err
 	tickerResp.Processing = int64(float64(time.Since(startTime)) / float64(time.Millisecond))
 	return tickerResp, err
 }
+
+// GetLatestFiltered returns the latest page of the tracks match returns true for, the oldest of them first.
+// a nil match matches every track
+func (mgrTicker *MgrTicker) GetLatestFiltered(match func(track TrackInfo) bool) (Response, error) {
+	startTime := time.Now()
+	tracks, err := mgrTicker.DB.GetAllTracks()
+	if err != nil {
+		return Response{}, err
+	}
+	tickerResp := latestPage(tracks, match, mgrTicker.PageCap)
+	tickerResp.Processing = int64(float64(time.Since(startTime)) / float64(time.Millisecond))
+	return tickerResp, nil
+}
+
+// latestPage returns the last limit tracks match returns true for. the tracks are ordered by when they were added
+func latestPage(tracks []TrackInfo, match func(track TrackInfo) bool, limit int) Response {
+	tickerResp := Response{}
+	if len(tracks) == 0 {
+		return tickerResp
+	}
+	tickerResp.TLatest = tracks[len(tracks)-1].Timestamp
+	var page []TrackInfo // the latest first
+	for i := len(tracks) - 1; i >= 0 && len(page) < limit; i-- {
+		if match == nil || match(tracks[i]) {
+			page = append(page, tracks[i])
+		}
+	}
+	if len(page) == 0 {
+		return tickerResp
+	}
+	for i := len(page) - 1; i >= 0; i-- {
+		tickerResp.TrackIDs = append(tickerResp.TrackIDs, page[i].ID)
+	}
+	tickerResp.TStart, tickerResp.TStop = page[len(page)-1].Timestamp, page[0].Timestamp
+	return tickerResp
+}
@@ -104,3 +104,20 @@ func Test_GetTickerByTimeStamp(t *testing.T) {
 	}
 
 }
+
+func Test_LatestPage(t *testing.T) {
+	var tracks []TrackInfo
+	for i := 0; i < 10; i++ {
+		tracks = append(tracks, TrackInfo{ID: objectid.New(), Pilot: []string{"ole", "kari"}[i%2], Timestamp: int64(i)})
+	}
+	res := latestPage(tracks, func(track TrackInfo) bool { return track.Pilot == "ole" }, 3)
+	if len(res.TrackIDs) != 3 || res.TrackIDs[0] != tracks[4].ID || res.TrackIDs[2] != tracks[8].ID {
+		t.Errorf("expected the latest 3 tracks of ole, the oldest first, got %v", res.TrackIDs)
+	}
+	if res.TStart != 4 || res.TStop != 8 || res.TLatest != 9 {
+		t.Errorf("wrong timestamps %d %d %d", res.TStart, res.TStop, res.TLatest)
+	}
+	if res := latestPage(tracks, func(track TrackInfo) bool { return false }, 3); len(res.TrackIDs) != 0 || res.TLatest != 9 {
+		t.Errorf("expected no tracks, got %v", res.TrackIDs)
+	}
+}
@@ -2,8 +2,10 @@ package paragliding
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,30 +17,81 @@ import (
 
 // TrackMgr is the manager struct for tacks
 type TrackMgr struct {
-	DB    *Database
-	WHMgr *WebHookMgr
+	DB       *Database
+	WHMgr    *WebHookMgr
+	Search   *SearchIndex
+	Pilots   *PilotMgr
+	Gliders  *GliderRegistry
+	Sites    *SiteMgr
+	Boards   *LeaderboardMgr
+	Tasks    *TaskMgr
+	Airspace *AirspaceMgr
+	Heatmap  *HeatmapMgr
+	Validity *IGCValidator
+	Fetcher  *Fetcher
 }
 
-// HandlerPostTrack is the handler for POST /api/track. it registers the track and replies with the id
+// HandlerPostTrack is the handler for POST /api/track. it registers the track and replies with the id.
+// the body is {"url": "...", "mode": "strict|lenient"}, see ParseIGC for the modes. a rejected file gets
+// 422 with the diagnostics
 func (tMgr *TrackMgr) HandlerPostTrack(w http.ResponseWriter, r *http.Request) {
 	var postData map[string]string
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err == nil {
-		track, err2 := igc.ParseLocation(postData["url"])
+		mode := postData["mode"]
+		if mode == "" {
+			mode = IngestLenient
+		} else if mode != IngestStrict && mode != IngestLenient {
+			http.Error(w, "mode must be strict or lenient", http.StatusBadRequest)
+			return
+		}
+		content, err2 := tMgr.Fetcher.Fetch(postData["url"])
 		if err2 != nil {
-			http.Error(w, "could not get a track from url: "+postData["url"], http.StatusNotFound)
+			status := http.StatusBadGateway
+			var fetchErr *FetchError
+			if errors.As(err2, &fetchErr) {
+				status = fetchErr.Status()
+			}
+			http.Error(w, "could not get the file from url: "+postData["url"]+": "+err2.Error(), status)
+			return
+		}
+		track, diagnostics, ok := ParseIGC(content, mode)
+		if !ok {
+			w.Header().Add("content-type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(struct {
+				Error       string       `json:"error"`
+				Diagnostics []Diagnostic `json:"diagnostics"`
+			}{"the file was rejected in " + mode + " mode", diagnostics})
 			return
 		}
+		points := ConvertPoints(track)
+		stats := CalculateTrackStats(points)
 		trackInfo := TrackInfo{ID: objectid.New(), HDate: track.Date.String(), Pilot: track.Pilot,
 			Glider: track.GliderType, GliderID: track.GliderID, TrackLength: CalculatedistanceFromPoints(track.Points),
-			TrackURL: postData["url"], Timestamp: (time.Now().UnixNano() / int64(time.Millisecond))}
+			TrackURL: postData["url"], Timestamp: (time.Now().UnixNano() / int64(time.Millisecond)),
+			Airtime: stats.Duration, XCDistance: stats.MaxDistance, Validity: tMgr.Validity.Check(content), Warnings: diagnostics}
+		trackInfo.PilotID = tMgr.Pilots.LinkTrack(trackInfo)
+		tMgr.Sites.TagTrack(&trackInfo, points)
+		if glider, found := tMgr.Gliders.Normalise(trackInfo.Glider, trackInfo.GliderID); found {
+			trackInfo.GliderRef, trackInfo.GliderClass, trackInfo.GliderSize = glider.Ref, glider.Class, glider.Size
+		}
 		id, added := tMgr.DB.Insert("tracks", trackInfo)
 		if added {
+			tMgr.DB.Insert("points", TrackPoints{ID: trackInfo.ID, Points: points})
+			tMgr.Search.Add(id, trackSearchFields(trackInfo))
+			tMgr.Boards.AddTrack(trackInfo)
+			tMgr.Tasks.ValidateTrack(trackInfo, points)
+			if err := tMgr.Heatmap.AddTrack(trackInfo, points); err != nil {
+				fmt.Println("could not add the track to the heatmap:", err)
+			}
 			w.Header().Add("content-type", "application/json")
 			json.NewEncoder(w).Encode(struct {
 				ID string `json:"id"`
 			}{id})
-			tMgr.WHMgr.InvokeNewWebHooks() // invoke webhooks cause new track is added
+			tMgr.WHMgr.InvokeNewWebHooks(trackInfo, points) // invoke webhooks cause new track is added
+			tMgr.checkRecords(trackInfo)
+			tMgr.Airspace.CheckTrack(trackInfo, points)
 		} else {
 			http.Error(w, "track already exists with id: "+id, http.StatusBadRequest)
 		}
@@ -49,10 +102,26 @@ func (tMgr *TrackMgr) HandlerPostTrack(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandlerGetAllTracks is the handler for GET /api/track. it replies with an array of all track ids
+// HandlerGetAllTracks is the handler for GET /api/track. it replies with an array of all track ids.
+// ?validity=verified only lists the tracks with that validity
 func (tMgr *TrackMgr) HandlerGetAllTracks(w http.ResponseWriter, r *http.Request) {
+	validity := r.URL.Query().Get("validity")
+	if validity != "" && !validValidity(validity) {
+		http.Error(w, "validity must be verified, unsigned, invalid or unknown_logger", http.StatusBadRequest)
+		return
+	}
 	w.Header().Add("content-type", "application/json")
-	ids, err := tMgr.DB.GetAllTrackIDs()
+	var ids []objectid.ObjectID
+	var err error
+	if validity == "" {
+		ids, err = tMgr.DB.GetAllTrackIDs()
+	} else {
+		var tracks []TrackInfo
+		tracks, err = tMgr.DB.GetTracksByValidity(validity)
+		for _, track := range tracks {
+			ids = append(ids, track.ID)
+		}
+	}
 	if err != nil {
 		http.Error(w, "Could not receive track list", http.StatusInternalServerError)
 		return
@@ -73,7 +142,7 @@ func (tMgr *TrackMgr) HandlerGetTrackByID(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(trackInfo)
 }
 
-// HandlerGetTrackFieldByID is the handler for GET /api/track/<id><field>. is reponds with the single informationc ontained in that field
+// HandlerGetTrackFieldByID is the handler for GET /api/track/<id>/<field>. is reponds with the single informationc ontained in that field
 func (tMgr *TrackMgr) HandlerGetTrackFieldByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "text/plain")
 	parts := strings.Split(r.URL.Path, "/")
@@ -95,6 +164,14 @@ func (tMgr *TrackMgr) HandlerGetTrackFieldByID(w http.ResponseWriter, r *http.Re
 		fmt.Fprintf(w, "H_date: %s", trackInfo.HDate)
 	case "track_length":
 		fmt.Fprintf(w, "track_length: %s", trackInfo.TrackLength)
+	case "glider_ref":
+		fmt.Fprintf(w, "glider_ref: %s", trackInfo.GliderRef)
+	case "site":
+		fmt.Fprintf(w, "site: %s", trackInfo.Site)
+	case "pilot_id":
+		fmt.Fprintf(w, "pilot_id: %s", trackInfo.PilotID)
+	case "validity":
+		fmt.Fprintf(w, "validity: %s", trackInfo.Validity)
 	case "track_src_url":
 		fmt.Fprintf(w, "track_src_url: %s", trackInfo.TrackLength)
 	default:
@@ -110,3 +187,87 @@ func CalculatedistanceFromPoints(points []igc.Point) string {
 	}
 	return strconv.FormatFloat(d, 'f', 2, 64)
 }
+
+// TrackStats is the statistics derived from the points of a track
+type TrackStats struct {
+	TakeoffTime      int64   `json:"takeoff_time"` // unix time in milliseconds
+	LandingTime      int64   `json:"landing_time"`
+	Duration         int64   `json:"duration"` // seconds
+	MaxAltitude      int64   `json:"max_altitude"`
+	MinAltitude      int64   `json:"min_altitude"`
+	MaxClimb         float64 `json:"max_climb"` // m/s
+	MaxSink          float64 `json:"max_sink"`
+	TotalDistance    float64 `json:"total_distance"` // km
+	StraightDistance float64 `json:"straight_distance"`
+	MaxDistance      float64 `json:"max_distance"` // max distance from takeoff
+}
+
+// ConvertPoints converts the points of a parsed igc track to the points stored in the database.
+// the igc fixes only have the time of day so the date from the header is added to them
+func ConvertPoints(track igc.Track) []TrackPoint {
+	points := make([]TrackPoint, 0, len(track.Points))
+	day := time.Date(track.Date.Year(), track.Date.Month(), track.Date.Day(), 0, 0, 0, 0, time.UTC)
+	var last time.Time
+	for _, p := range track.Points {
+		t := day.Add(time.Duration(p.Time.Hour())*time.Hour + time.Duration(p.Time.Minute())*time.Minute +
+			time.Duration(p.Time.Second())*time.Second)
+		if t.Before(last) { // passed midnight UTC
+			day = day.AddDate(0, 0, 1)
+			t = t.AddDate(0, 0, 1)
+		}
+		last = t
+		points = append(points, TrackPoint{Time: t.UnixNano() / int64(time.Millisecond), Lat: p.Lat.Degrees(),
+			Lon: p.Lng.Degrees(), PressureAltitude: p.PressureAltitude, GNSSAltitude: p.GNSSAltitude})
+	}
+	return points
+}
+
+// CalculateTrackStats returns the statistics for a set of points
+func CalculateTrackStats(points []TrackPoint) TrackStats {
+	stats := TrackStats{}
+	if len(points) == 0 {
+		return stats
+	}
+	first, last := points[0], points[len(points)-1]
+	stats.TakeoffTime = first.Time
+	stats.LandingTime = last.Time
+	stats.Duration = (last.Time - first.Time) / 1000
+	stats.MaxAltitude = first.GNSSAltitude
+	stats.MinAltitude = first.GNSSAltitude
+	stats.StraightDistance = DistanceKm(first.Lat, first.Lon, last.Lat, last.Lon)
+	for i, p := range points {
+		if p.GNSSAltitude > stats.MaxAltitude {
+			stats.MaxAltitude = p.GNSSAltitude
+		}
+		if p.GNSSAltitude < stats.MinAltitude {
+			stats.MinAltitude = p.GNSSAltitude
+		}
+		if d := DistanceKm(first.Lat, first.Lon, p.Lat, p.Lon); d > stats.MaxDistance {
+			stats.MaxDistance = d
+		}
+		if i == 0 {
+			continue
+		}
+		prev := points[i-1]
+		stats.TotalDistance += DistanceKm(prev.Lat, prev.Lon, p.Lat, p.Lon)
+		if dt := float64(p.Time-prev.Time) / 1000; dt > 0 {
+			vario := float64(p.GNSSAltitude-prev.GNSSAltitude) / dt
+			if vario > stats.MaxClimb {
+				stats.MaxClimb = vario
+			}
+			if vario < stats.MaxSink {
+				stats.MaxSink = vario
+			}
+		}
+	}
+	return stats
+}
+
+// DistanceKm returns the great circle distance in km between two coordinates given in degrees
+func DistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * igc.EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,167 @@
+package paragliding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the validity of a track, decided by the security record (G record) of the igc file
+const (
+	ValidityVerified      = "verified"       // the logger's verifier accepted the G record
+	ValidityUnsigned      = "unsigned"       // the file has no G record
+	ValidityInvalid       = "invalid"        // the verifier rejected the G record, or records were added after it
+	ValidityUnknownLogger = "unknown_logger" // the file is signed, but there is no verifier for the logger that could verify it
+)
+
+// how long the validation program may run when the verifier has no timeout, and how many may run at the same time
+// when the validator has no limit
+const (
+	defaultVerifyTimeout    = 10 * time.Second
+	defaultMaxVerifications = 4
+)
+
+// ErrVerifyTimeout is returned by a verifier that did not finish in time
+var ErrVerifyTimeout = errors.New("the verifier timed out")
+
+// GRecordVerifier verifies the security record of the igc files of one logger manufacturer.
+// it returns false if the file has been changed since the logger signed it
+type GRecordVerifier interface {
+	Verify(content []byte) (bool, error)
+}
+
+// ExecVerifier verifies a file by running the manufacturer's validation program (the vali program approved
+// by the FAI) with the path of the file. an exit status of 0 means the file is valid. the program is killed after
+// the timeout, 10 seconds if it is 0
+type ExecVerifier struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Verify writes the file to a temporary file and runs the validation program on it
+func (v ExecVerifier) Verify(content []byte) (bool, error) {
+	file, err := ioutil.TempFile("", "track-*.igc")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return false, err
+	}
+	file.Close()
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = exec.CommandContext(ctx, v.Command, file.Name()).Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return false, ErrVerifyTimeout
+	}
+	if _, failed := err.(*exec.ExitError); failed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IGCValidator decides the validity of igc files using the verifiers of the manufacturers it knows.
+// at most MaxConcurrent verifiers run at the same time, so the uploads can not start a program each. a file
+// that comes in while they are all busy is not verified
+type IGCValidator struct {
+	Verifiers     map[string]GRecordVerifier // the three letter manufacturer code of the A record -> verifier
+	MaxConcurrent int                        // 4 if it is 0
+
+	slots     chan struct{}
+	slotsOnce sync.Once
+}
+
+// LoadIGCValidator creates a validator from a list like "XCS=/opt/vali/vali-xcs,LXN=/opt/vali/vali-lxn"
+// with the validation program of every manufacturer. an empty list gives a validator without verifiers
+func LoadIGCValidator(programs string) (*IGCValidator, error) {
+	validator := &IGCValidator{Verifiers: map[string]GRecordVerifier{}}
+	for _, program := range strings.Split(programs, ",") {
+		if strings.TrimSpace(program) == "" {
+			continue
+		}
+		parts := strings.SplitN(program, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) != 3 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid validation program %q, expected MANUFACTURER=command", program)
+		}
+		validator.Register(parts[0], ExecVerifier{Command: strings.TrimSpace(parts[1])})
+	}
+	return validator, nil
+}
+
+// Register sets the verifier of the manufacturer
+func (validator *IGCValidator) Register(manufacturer string, verifier GRecordVerifier) {
+	if validator.Verifiers == nil {
+		validator.Verifiers = map[string]GRecordVerifier{}
+	}
+	validator.Verifiers[strings.ToUpper(strings.TrimSpace(manufacturer))] = verifier
+}
+
+// Check returns the validity of the igc file
+func (validator *IGCValidator) Check(content []byte) string {
+	manufacturer := ""
+	signed := false
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		switch {
+		case line[0] == 'A' && manufacturer == "" && len(line) >= 4:
+			manufacturer = strings.ToUpper(string(line[1:4]))
+		case line[0] == 'G':
+			signed = true
+		case signed:
+			// the G record is the last record of the file, so anything after it was added later
+			return ValidityInvalid
+		}
+	}
+	if !signed {
+		return ValidityUnsigned
+	}
+	if validator == nil || validator.Verifiers[manufacturer] == nil {
+		return ValidityUnknownLogger
+	}
+	validator.slotsOnce.Do(func() {
+		max := validator.MaxConcurrent
+		if max <= 0 {
+			max = defaultMaxVerifications
+		}
+		validator.slots = make(chan struct{}, max)
+	})
+	select {
+	case validator.slots <- struct{}{}:
+		defer func() { <-validator.slots }()
+	default:
+		fmt.Println("could not verify the igc file: too many verifications are running")
+		return ValidityUnknownLogger
+	}
+	valid, err := validator.Verifiers[manufacturer].Verify(content)
+	if err != nil {
+		// the verifier could not run or did not finish in time, which says nothing about the file
+		fmt.Println("could not verify the igc file:", err)
+		return ValidityUnknownLogger
+	}
+	if !valid {
+		return ValidityInvalid
+	}
+	return ValidityVerified
+}
+
+// validValidity returns true if the value is one of the validities
+func validValidity(value string) bool {
+	return value == ValidityVerified || value == ValidityUnsigned || value == ValidityInvalid ||
+		value == ValidityUnknownLogger
+}
@@ -0,0 +1,107 @@
+package paragliding
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeVerifier accepts the files that contain the valid G record
+type fakeVerifier struct {
+	err error
+}
+
+func (v fakeVerifier) Verify(content []byte) (bool, error) {
+	return string(content[len(content)-6:]) == "GVALID", v.err
+}
+
+func Test_IGCValidatorCheck(t *testing.T) {
+	validator := &IGCValidator{}
+	validator.Register("xcs", fakeVerifier{})
+	validator.Register("FLY", fakeVerifier{err: errors.New("the program is missing")})
+	validator.Register("LXV", fakeVerifier{err: ErrVerifyTimeout})
+	body := "AXCSAAA\nHFDTE020518\nB1101355206343N00006198WA0058700558\n"
+	tests := []struct {
+		content  string
+		validity string
+	}{
+		{body, ValidityUnsigned},
+		{body + "GVALID", ValidityVerified},
+		{body + "GWRONG", ValidityInvalid},
+		{body + "GVALID\nB1101365206343N00006198WA0058700558\n", ValidityInvalid},
+		{"ALXNAAA\n" + body[8:] + "GVALID", ValidityUnknownLogger},
+		{"AFLYAAA\n" + body[8:] + "GVALID", ValidityUnknownLogger},
+		{"ALXVAAA\n" + body[8:] + "GVALID", ValidityUnknownLogger},
+	}
+	for _, test := range tests {
+		if validity := validator.Check([]byte(test.content)); validity != test.validity {
+			t.Errorf("expected %s, got %s for %q", test.validity, validity, test.content)
+		}
+	}
+	var none *IGCValidator
+	if validity := none.Check([]byte(body + "GVALID")); validity != ValidityUnknownLogger {
+		t.Errorf("expected unknown_logger without verifiers, got %s", validity)
+	}
+}
+
+// blockingVerifier accepts every file once it is released
+type blockingVerifier struct {
+	started, release chan struct{}
+}
+
+func (v blockingVerifier) Verify(content []byte) (bool, error) {
+	v.started <- struct{}{}
+	<-v.release
+	return true, nil
+}
+
+func Test_IGCValidatorBusy(t *testing.T) {
+	verifier := blockingVerifier{started: make(chan struct{}), release: make(chan struct{})}
+	validator := &IGCValidator{MaxConcurrent: 1}
+	validator.Register("XCS", verifier)
+	file := []byte("AXCSAAA\nGVALID")
+	done := make(chan string)
+	go func() { done <- validator.Check(file) }()
+	<-verifier.started
+	// the only slot is taken, so the next file is not verified
+	if validity := validator.Check(file); validity != ValidityUnknownLogger {
+		t.Errorf("expected unknown_logger while the verifier is busy, got %s", validity)
+	}
+	close(verifier.release)
+	if validity := <-done; validity != ValidityVerified {
+		t.Errorf("expected the first file to be verified, got %s", validity)
+	}
+	go func() { <-verifier.started }()
+	if validity := validator.Check(file); validity != ValidityVerified {
+		t.Errorf("expected the slot to be free again, got %s", validity)
+	}
+}
+
+func Test_LoadIGCValidator(t *testing.T) {
+	validator, err := LoadIGCValidator("XCS=/opt/vali/vali-xcs, lxn=/opt/vali/vali-lxn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := validator.Verifiers["LXN"].(ExecVerifier); !ok || v.Command != "/opt/vali/vali-lxn" || len(validator.Verifiers) != 2 {
+		t.Errorf("wrong verifiers: %+v", validator.Verifiers)
+	}
+	if _, err := LoadIGCValidator("XCS"); err == nil {
+		t.Error("expected an error for a program without a manufacturer")
+	}
+}
+
+func Test_ExecVerifier(t *testing.T) {
+	if valid, err := (ExecVerifier{Command: "true"}).Verify([]byte("AXCS")); !valid || err != nil {
+		t.Errorf("expected the file to be valid: %v", err)
+	}
+	if valid, err := (ExecVerifier{Command: "false"}).Verify([]byte("AXCS")); valid || err != nil {
+		t.Errorf("expected the file to be invalid: %v", err)
+	}
+	if _, err := (ExecVerifier{Command: "/does/not/exist"}).Verify([]byte("AXCS")); err == nil {
+		t.Error("expected an error for a missing program")
+	}
+	slow := ExecVerifier{Command: "yes", Timeout: 10 * time.Millisecond} // prints the path until it is killed
+	if _, err := slow.Verify([]byte("AXCS")); err != ErrVerifyTimeout {
+		t.Errorf("expected the program to time out, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package paragliding
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// WaypointMgr is the manager for waypoints
+type WaypointMgr struct {
+	DB *Database
+}
+
+// WaypointImport is the response for POST /admin/api/waypoints
+type WaypointImport struct {
+	Added      []string `json:"added"`      // the ids of the new waypoints
+	Duplicates []string `json:"duplicates"` // the names of the waypoints that were skipped
+}
+
+// waypoints closer than this are the same waypoint, in meters
+const waypointDuplicateDistance = 50
+
+// the radius of the sites imported from waypoint files, in meters
+const waypointSiteRadius = 500
+
+// the content types of the waypoint formats
+var waypointContentTypes = map[string]string{
+	"cup": "text/plain",
+	"gpx": "application/gpx+xml",
+	"wpt": "text/plain",
+}
+
+// HandlerImportWaypoints is the handler for POST /admin/api/waypoints. the body is a seeyou cup, gpx or compegps wpt
+// file. the format is taken from the format parameter, and guessed from the content if it is not set. waypoints with
+// the name of a stored waypoint, or closer than 50 meters to one, are skipped as duplicates
+func (wMgr *WaypointMgr) HandlerImportWaypoints(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read the body", http.StatusBadRequest)
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = guessWaypointFormat(string(body))
+	}
+	waypoints, err := ParseWaypoints(format, string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	existing, err := wMgr.DB.GetAllWaypoints()
+	if err != nil {
+		http.Error(w, "could not get the stored waypoints", http.StatusInternalServerError)
+		return
+	}
+
+	result := WaypointImport{Added: []string{}, Duplicates: []string{}}
+	for _, waypoint := range waypoints {
+		if _, duplicate := FindDuplicateWaypoint(existing, waypoint); duplicate {
+			result.Duplicates = append(result.Duplicates, waypoint.Name)
+			continue
+		}
+		waypoint.ID = objectid.New()
+		id, added := wMgr.DB.Insert("waypoints", waypoint)
+		if !added {
+			http.Error(w, "could not add waypoint "+waypoint.Name, http.StatusInternalServerError)
+			return
+		}
+		existing = append(existing, waypoint) // so duplicates within the file are found too
+		result.Added = append(result.Added, id)
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// HandlerGetWaypoints is the handler for GET /api/waypoints. it replies with all the waypoints as json,
+// or as a file if the format parameter is cup, gpx or wpt
+func (wMgr *WaypointMgr) HandlerGetWaypoints(w http.ResponseWriter, r *http.Request) {
+	waypoints, err := wMgr.DB.GetAllWaypoints()
+	if err != nil {
+		http.Error(w, "Could not receive waypoint list", http.StatusInternalServerError)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		if waypoints == nil {
+			waypoints = []WaypointInfo{}
+		}
+		w.Header().Add("content-type", "application/json")
+		json.NewEncoder(w).Encode(waypoints)
+		return
+	}
+	content, err := ExportWaypoints(format, waypoints)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("content-type", waypointContentTypes[format])
+	w.Header().Add("content-disposition", "attachment; filename=waypoints."+format)
+	w.Write(content)
+}
+
+// FindDuplicateWaypoint returns the waypoint that has the same name as the passed one or is within 50 meters of it
+func FindDuplicateWaypoint(waypoints []WaypointInfo, waypoint WaypointInfo) (WaypointInfo, bool) {
+	name := NormalizeWaypointName(waypoint.Name)
+	for _, other := range waypoints {
+		if NormalizeWaypointName(other.Name) == name ||
+			DistanceKm(waypoint.Lat, waypoint.Lon, other.Lat, other.Lon)*1000 < waypointDuplicateDistance {
+			return other, true
+		}
+	}
+	return WaypointInfo{}, false
+}
+
+// FindWaypointByName returns the waypoint with the name, see NormalizeWaypointName
+func FindWaypointByName(waypoints []WaypointInfo, name string) (WaypointInfo, bool) {
+	name = NormalizeWaypointName(name)
+	for _, waypoint := range waypoints {
+		if NormalizeWaypointName(waypoint.Name) == name {
+			return waypoint, true
+		}
+	}
+	return WaypointInfo{}, false
+}
+
+// NormalizeWaypointName returns the form of a waypoint name used to compare them. case, accents, spaces and
+// punctuation are ignored, since the gps files often shorten the names, so "Voss - Hangur" and "VOSSHANGUR" are the
+// same waypoint
+func NormalizeWaypointName(name string) string {
+	return strings.Join(tokenize(name), "")
+}
+
+// ParseWaypoints parses a waypoint file in the given format, cup, gpx or wpt
+func ParseWaypoints(format string, content string) ([]WaypointInfo, error) {
+	var waypoints []WaypointInfo
+	var err error
+	switch format {
+	case "cup":
+		waypoints, err = ParseCUP(content)
+	case "gpx":
+		waypoints, err = ParseGPXWaypoints(content)
+	case "wpt":
+		waypoints, err = ParseCompeGPSWPT(content)
+	default:
+		return nil, errors.New("unknown format: " + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, waypoint := range waypoints {
+		if err := validateWaypoint(waypoint); err != nil {
+			return nil, err
+		}
+	}
+	return waypoints, nil
+}
+
+// ExportWaypoints writes the waypoints in the given format, cup, gpx or wpt
+func ExportWaypoints(format string, waypoints []WaypointInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "cup":
+		writer := csv.NewWriter(&buf)
+		writer.Write([]string{"name", "code", "country", "lat", "lon", "elev", "style", "rwdir", "rwlen", "freq", "desc"})
+		for _, waypoint := range waypoints {
+			writer.Write([]string{waypoint.Name, waypoint.Code, "", formatCUPCoord(waypoint.Lat, 2, "NS"),
+				formatCUPCoord(waypoint.Lon, 3, "EW"), strconv.FormatFloat(waypoint.Elevation, 'f', 1, 64) + "m",
+				"1", "", "", "", waypoint.Description})
+		}
+		writer.Flush()
+	case "gpx":
+		doc := gpxDocument{Version: "1.1", Creator: "paragliding"}
+		for _, waypoint := range waypoints {
+			doc.Waypoints = append(doc.Waypoints, gpxWaypoint{Lat: waypoint.Lat, Lon: waypoint.Lon, Elevation: waypoint.Elevation,
+				Name: waypoint.Name, Description: waypoint.Description})
+		}
+		buf.WriteString(xml.Header)
+		encoder := xml.NewEncoder(&buf)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	case "wpt":
+		buf.WriteString("G  WGS 84\r\nU  1\r\n")
+		for _, waypoint := range waypoints {
+			fmt.Fprintf(&buf, "W  %s A %.10fº%s %.10fº%s 27-MAR-62 00:00:00 %f %s\r\n", strings.Replace(waypoint.Name, " ", "_", -1),
+				math.Abs(waypoint.Lat), hemisphere(waypoint.Lat, "NS"), math.Abs(waypoint.Lon), hemisphere(waypoint.Lon, "EW"),
+				waypoint.Elevation, waypoint.Description)
+		}
+	default:
+		return nil, errors.New("unknown format: " + format)
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseCUP parses a seeyou cup file. it is csv with the columns name,code,country,lat,lon,elev,style and more,
+// and the coordinates written as 6023.000N and 00555.000E. the tasks at the end of the file are ignored
+func ParseCUP(content string) ([]WaypointInfo, error) {
+	// the task section is not csv with the same columns
+	if i := strings.Index(content, "-----Related Tasks-----"); i >= 0 {
+		content = content[:i]
+	}
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var waypoints []WaypointInfo
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "name") {
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) < 6 {
+			return nil, fmt.Errorf("line %d: expected name,code,country,lat,lon,elev", i+1)
+		}
+		waypoint := WaypointInfo{Name: strings.TrimSpace(record[0]), Code: strings.TrimSpace(record[1])}
+		if waypoint.Lat, err = parseCUPCoord(record[3], 2, "NS"); err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude", i+1)
+		}
+		if waypoint.Lon, err = parseCUPCoord(record[4], 3, "EW"); err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude", i+1)
+		}
+		if waypoint.Elevation, err = parseElevation(record[5]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid elevation", i+1)
+		}
+		if len(record) > 10 {
+			waypoint.Description = strings.TrimSpace(record[10])
+		}
+		waypoints = append(waypoints, waypoint)
+	}
+	return waypoints, nil
+}
+
+// gpxDocument is the part of a gpx file with the waypoints
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr,omitempty"`
+	Creator   string        `xml:"creator,attr,omitempty"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+type gpxWaypoint struct {
+	Lat         float64 `xml:"lat,attr"`
+	Lon         float64 `xml:"lon,attr"`
+	Elevation   float64 `xml:"ele,omitempty"`
+	Name        string  `xml:"name"`
+	Description string  `xml:"desc,omitempty"`
+}
+
+// ParseGPXWaypoints parses the wpt elements of a gpx file. routes and tracks are ignored
+func ParseGPXWaypoints(content string) ([]WaypointInfo, error) {
+	var doc gpxDocument
+	if err := xml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, errors.New("invalid gpx: " + err.Error())
+	}
+	var waypoints []WaypointInfo
+	for _, wpt := range doc.Waypoints {
+		waypoints = append(waypoints, WaypointInfo{Name: strings.TrimSpace(wpt.Name), Lat: wpt.Lat, Lon: wpt.Lon,
+			Elevation: wpt.Elevation, Description: strings.TrimSpace(wpt.Description)})
+	}
+	return waypoints, nil
+}
+
+// a compegps waypoint line, eg. "W  KVAM A 60.3833333333ºN 5.9166666667ºE 27-MAR-62 00:00:00 480.000000 Kvamskogen"
+var wptLinePattern = regexp.MustCompile(`^W\s+(\S+)\s+A\s+([\d.]+)\S*?([NS])\s+([\d.]+)\S*?([EW])\s+\S+\s+\S+\s+(-?[\d.]+)\s*(.*)$`)
+
+// ParseCompeGPSWPT parses a compegps wpt file. only files with the coordinates in degrees (U  1) are supported.
+// underscores in the names are read as spaces
+func ParseCompeGPSWPT(content string) ([]WaypointInfo, error) {
+	var waypoints []WaypointInfo
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 'U':
+			if strings.TrimSpace(line[1:]) != "1" {
+				return nil, fmt.Errorf("line %d: only coordinates in degrees (U  1) are supported", lineNr)
+			}
+		case 'W':
+			m := wptLinePattern.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid waypoint", lineNr)
+			}
+			waypoint := WaypointInfo{Name: strings.Replace(m[1], "_", " ", -1), Description: strings.TrimSpace(m[7])}
+			waypoint.Lat, _ = strconv.ParseFloat(m[2], 64)
+			waypoint.Lon, _ = strconv.ParseFloat(m[4], 64)
+			waypoint.Elevation, _ = strconv.ParseFloat(m[6], 64)
+			if m[3] == "S" {
+				waypoint.Lat = -waypoint.Lat
+			}
+			if m[5] == "W" {
+				waypoint.Lon = -waypoint.Lon
+			}
+			waypoints = append(waypoints, waypoint)
+		}
+	}
+	return waypoints, nil
+}
+
+// parseCUPCoord parses a cup coordinate with the given number of degree digits, eg. 6023.000N
+func parseCUPCoord(s string, degreeDigits int, hemispheres string) (float64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < degreeDigits+3 || !strings.ContainsRune(hemispheres, rune(s[len(s)-1])) {
+		return 0, errors.New("invalid coordinate")
+	}
+	degrees, err := strconv.Atoi(s[:degreeDigits])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseFloat(s[degreeDigits:len(s)-1], 64)
+	if err != nil || minutes >= 60 {
+		return 0, errors.New("invalid coordinate")
+	}
+	value := float64(degrees) + minutes/60
+	if s[len(s)-1] == hemispheres[1] {
+		value = -value
+	}
+	return value, nil
+}
+
+func formatCUPCoord(value float64, degreeDigits int, hemispheres string) string {
+	abs := math.Abs(value)
+	degrees := math.Floor(abs)
+	minutes := (abs - degrees) * 60
+	// rounding can give 60 minutes
+	if math.Round(minutes*1000) >= 60000 {
+		degrees, minutes = degrees+1, 0
+	}
+	return fmt.Sprintf("%0*d%06.3f%s", degreeDigits, int(degrees), minutes, hemisphere(value, hemispheres))
+}
+
+func hemisphere(value float64, hemispheres string) string {
+	if value < 0 {
+		return hemispheres[1:]
+	}
+	return hemispheres[:1]
+}
+
+// parseElevation parses a cup elevation like "480m", "1500ft" or "480" and returns meters
+func parseElevation(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	factor := 1.0
+	if strings.HasSuffix(s, "ft") {
+		s, factor = strings.TrimSuffix(s, "ft"), 0.3048
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "m"), 64)
+	return v * factor, err
+}
+
+func validateWaypoint(waypoint WaypointInfo) error {
+	if waypoint.Name == "" {
+		return errors.New("a waypoint has no name")
+	}
+	if waypoint.Lat < -90 || waypoint.Lat > 90 || waypoint.Lon < -180 || waypoint.Lon > 180 {
+		return errors.New("the coordinates of " + waypoint.Name + " are out of range")
+	}
+	return nil
+}
+
+// guessWaypointFormat returns gpx for xml, wpt if the content looks like a compegps file and cup otherwise
+func guessWaypointFormat(content string) string {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "<") {
+		return "gpx"
+	}
+	if strings.HasPrefix(content, "G ") || strings.HasPrefix(content, "B ") {
+		return "wpt"
+	}
+	return "cup"
+}
+
+// waypointSites turns waypoints into takeoff sites with the default radius
+func waypointSites(waypoints []WaypointInfo) []SiteInfo {
+	var sites []SiteInfo
+	for _, waypoint := range waypoints {
+		sites = append(sites, SiteInfo{Name: waypoint.Name, Lat: waypoint.Lat, Lon: waypoint.Lon, Radius: waypointSiteRadius})
+	}
+	return sites
+}
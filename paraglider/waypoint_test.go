@@ -0,0 +1,83 @@
+package paragliding
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func Test_ParseCUP(t *testing.T) {
+	cup := "name,code,country,lat,lon,elev,style,rwdir,rwlen,freq,desc\n" +
+		"\"Kvamskogen\",KVAM,NO,6023.000N,00555.000E,480.0m,1,,,,\"takeoff\"\n" +
+		"\"Voss\",VOSS,NO,6037.800N,00624.600W,1500ft,1,,,,\n" +
+		"-----Related Tasks-----\n\"task\",\"Kvamskogen\",\"Voss\"\n"
+	waypoints, err := ParseWaypoints("cup", cup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(waypoints) != 2 || waypoints[0].Code != "KVAM" || math.Abs(waypoints[0].Lat-60.38333) > 0.0001 ||
+		waypoints[0].Description != "takeoff" || waypoints[1].Lon > -6.4 || math.Abs(waypoints[1].Elevation-457.2) > 0.01 {
+		t.Errorf("wrong waypoints: %+v", waypoints)
+	}
+	if _, err := ParseCUP("name,code,country,lat,lon,elev\nBad,,,6023.000X,00555.000E,0m\n"); err == nil {
+		t.Error("expected error for invalid latitude")
+	}
+}
+
+func Test_ParseGPXAndWPT(t *testing.T) {
+	gpx := `<?xml version="1.0"?><gpx version="1.1"><wpt lat="60.5" lon="-5.25"><ele>100</ele><name>A</name></wpt></gpx>`
+	waypoints, err := ParseWaypoints(guessWaypointFormat(gpx), gpx)
+	if err != nil || len(waypoints) != 1 || waypoints[0].Name != "A" || waypoints[0].Lon != -5.25 || waypoints[0].Elevation != 100 {
+		t.Errorf("wrong gpx waypoints: %+v %v", waypoints, err)
+	}
+	wpt := "G  WGS 84\r\nU  1\r\nW  KVAM_TO A 60.3833333333ºN 5.9166666667ºE 27-MAR-62 00:00:00 480.000000 Kvamskogen\r\n"
+	waypoints, err = ParseWaypoints(guessWaypointFormat(wpt), wpt)
+	if err != nil || len(waypoints) != 1 || waypoints[0].Name != "KVAM TO" || waypoints[0].Elevation != 480 ||
+		waypoints[0].Description != "Kvamskogen" {
+		t.Errorf("wrong wpt waypoints: %+v %v", waypoints, err)
+	}
+}
+
+func Test_ExportWaypoints(t *testing.T) {
+	waypoints := []WaypointInfo{{Name: "Kvamskogen", Code: "KVAM", Lat: 60.383333, Lon: -5.916667, Elevation: 480}}
+	for _, format := range []string{"cup", "gpx", "wpt"} {
+		content, err := ExportWaypoints(format, waypoints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseWaypoints(format, string(content))
+		if err != nil || len(parsed) != 1 {
+			t.Fatalf("%s: could not parse the export: %v", format, err)
+		}
+		if !strings.EqualFold(parsed[0].Name, "Kvamskogen") || math.Abs(parsed[0].Lat-60.383333) > 0.0001 ||
+			math.Abs(parsed[0].Lon+5.916667) > 0.0001 || parsed[0].Elevation != 480 {
+			t.Errorf("%s: the export does not match: %+v", format, parsed[0])
+		}
+	}
+}
+
+func Test_FindDuplicateWaypoint(t *testing.T) {
+	waypoints := []WaypointInfo{{Name: "Kvamskogen", Lat: 60.38, Lon: 5.92}}
+	if _, dup := FindDuplicateWaypoint(waypoints, WaypointInfo{Name: "KVAMSKOGEN", Lat: 61, Lon: 5}); !dup {
+		t.Error("same name should be a duplicate")
+	}
+	if _, dup := FindDuplicateWaypoint(waypoints, WaypointInfo{Name: "Other", Lat: 60.3802, Lon: 5.92}); !dup {
+		t.Error("a waypoint 22 meters away should be a duplicate")
+	}
+	if _, dup := FindDuplicateWaypoint(waypoints, WaypointInfo{Name: "Other", Lat: 60.39, Lon: 5.92}); dup {
+		t.Error("a waypoint 1 km away is not a duplicate")
+	}
+}
+
+func Test_NormalizeWaypointName(t *testing.T) {
+	if NormalizeWaypointName("Voss - Hangur") != NormalizeWaypointName("VOSSHANGUR") ||
+		NormalizeWaypointName("Bømlo") != NormalizeWaypointName("bomlo") {
+		t.Error("the names should be the same waypoint")
+	}
+	if NormalizeWaypointName("T01") == NormalizeWaypointName("T02") {
+		t.Error("the names should not be the same waypoint")
+	}
+	if _, found := FindWaypointByName([]WaypointInfo{{Name: "Voss-Hangur"}}, "voss hangur"); !found {
+		t.Error("expected the waypoint to be found by name")
+	}
+}
@@ -1,11 +1,12 @@
 package paragliding
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -15,12 +16,17 @@ import (
 
 // WebHookMgr is the manager for webhooks
 type WebHookMgr struct {
-	DB     *Database
-	Ticker *MgrTicker
+	DB         *Database
+	Ticker     *MgrTicker
+	Dispatcher *Dispatcher
 }
 
-// HandlerNewTrackWebHook is the handler for POST /api/webhook/new_track/.
-// it registers a new webhook and reponds with the id assigned to it
+// HandlerNewTrackWebHook is the handler for POST /api/webhook/new_track/. it is kept for the webhooks that were
+// registered before /api/webhooks, and registers a webhook for track.created, and airspace.infringement if airspace is
+// "true". it reponds with the id assigned to it and the secret the requests are signed with.
+// the secret is either given in the body or generated, and format (discord, slack, teams or json) decides what the
+// requests look like. template is an optional text/template of the message, see TemplateData, and filter an optional
+// filter of the tracks, see TrackFilter
 func (whMgr *WebHookMgr) HandlerNewTrackWebHook(w http.ResponseWriter, r *http.Request) {
 	var postData map[string]string
 	err := json.NewDecoder(r.Body).Decode(&postData)
@@ -30,17 +36,13 @@ func (whMgr *WebHookMgr) HandlerNewTrackWebHook(w http.ResponseWriter, r *http.R
 			http.Error(w, "triggervalue is not a number", http.StatusBadRequest)
 			return
 		}
-		minTriggerVal, _ := strconv.ParseInt(postData["minTriggerValue"], 10, 64) // guaranteed to be number cause regex checks in url
-		wekbookInfo := WebhookInfo{ID: objectid.New(), WebhookURL: postData["webhookURL"], MinTriggerValue: int64(triggerVal), Counter: minTriggerVal, LatestTimestamp: (time.Now().UnixNano() / int64(time.Millisecond))}
-		id, added := whMgr.DB.Insert("webhooks", wekbookInfo)
-		if added {
-			w.Header().Add("content-type", "application/json")
-			json.NewEncoder(w).Encode(struct {
-				ID string `json:"id"`
-			}{id})
-		} else {
-			http.Error(w, "track already exists with id: "+id, http.StatusBadRequest)
+		events := []string{EventTrackCreated}
+		if postData["airspace"] == "true" {
+			events = append(events, EventAirspaceInfringement)
 		}
+		whMgr.register(w, WebhookInfo{WebhookURL: postData["webhookURL"], MinTriggerValue: int64(triggerVal),
+			Airspace: postData["airspace"] == "true", Format: postData["format"], Template: postData["template"],
+			Events: events, Filter: postData["filter"]}, postData["secret"])
 	} else if err == io.EOF {
 		http.Error(w, "POST body is empty", http.StatusBadRequest)
 	} else {
@@ -48,8 +50,154 @@ func (whMgr *WebHookMgr) HandlerNewTrackWebHook(w http.ResponseWriter, r *http.R
 	}
 }
 
-// HandlerGetWebhookHookByID is the handler for "GET /api/webhook/new_track/<webhook_id>"
-// it responds with the webhoo url and the minimum trigger value
+// HandlerNewWebhook is the handler for POST /api/webhooks. the body is like
+//
+//	{"webhookURL": "https://...", "events": ["track.created", "record.broken"], "minTriggerValue": 2}
+//
+// events are the event types of the webhook, see EventTypes. minTriggerValue is the number of new tracks before
+// track.created is sent, 1 by default, and filter is an optional filter of the tracks, see TrackFilter.
+// with a schedule ("hourly" or "daily 20:00", in timezone) the new tracks are sent as a digest instead, see
+// DigestSchedule, and with maxDelay (seconds) they are also sent when the oldest of them is that old. both need
+// track.created in the events.
+// format, template and secret are like for /api/webhook/new_track/
+func (whMgr *WebHookMgr) HandlerNewWebhook(w http.ResponseWriter, r *http.Request) {
+	var postData struct {
+		WebhookURL      string   `json:"webhookURL"`
+		Events          []string `json:"events"`
+		MinTriggerValue int64    `json:"minTriggerValue"`
+		Format          string   `json:"format"`
+		Template        string   `json:"template"`
+		Filter          string   `json:"filter"`
+		Schedule        string   `json:"schedule"`
+		Timezone        string   `json:"timezone"`
+		MaxDelay        int64    `json:"maxDelay"`
+		Secret          string   `json:"secret"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&postData)
+	if err == io.EOF {
+		http.Error(w, "POST body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "POST body is not valid json", http.StatusBadRequest)
+		return
+	}
+	if err := validEvents(postData.Events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if postData.MinTriggerValue < 0 {
+		http.Error(w, "minTriggerValue can not be negative", http.StatusBadRequest)
+		return
+	} else if postData.MinTriggerValue == 0 {
+		postData.MinTriggerValue = 1
+	}
+	whMgr.register(w, WebhookInfo{WebhookURL: postData.WebhookURL, MinTriggerValue: postData.MinTriggerValue,
+		Format: postData.Format, Template: postData.Template, Events: postData.Events, Filter: postData.Filter,
+		Schedule: postData.Schedule, Timezone: postData.Timezone, MaxDelay: postData.MaxDelay}, postData.Secret)
+}
+
+// register checks the settings and secret of a new webhook and adds it. it responds with the id and secret
+func (whMgr *WebHookMgr) register(w http.ResponseWriter, webhook WebhookInfo, secret string) {
+	if err := validateWebhook(webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := whMgr.Dispatcher.checkURL(webhook.WebhookURL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret, err := webhookSecret(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	webhook.ID, webhook.Secret, webhook.Counter = objectid.New(), secret, webhook.MinTriggerValue
+	webhook.LatestTimestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	webhook.NextDigest = nextDigest(webhook, time.Now())
+	id, added := whMgr.DB.Insert("webhooks", webhook)
+	if added {
+		w.Header().Add("content-type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		}{id, secret})
+	} else {
+		http.Error(w, "webhook already exists with id: "+id, http.StatusBadRequest)
+	}
+}
+
+// validateWebhook checks the url, trigger value, format, template, filter, schedule and max delay of a webhook
+func validateWebhook(webhook WebhookInfo) error {
+	if !validWebhookURL(webhook.WebhookURL) {
+		return errors.New("webhookURL must be a http or https url")
+	}
+	if webhook.MinTriggerValue < 1 {
+		return errors.New("minTriggerValue must be at least 1")
+	}
+	if !validFormat(webhook.Format) {
+		return errors.New("format must be discord, slack, teams or json")
+	}
+	if webhook.Template != "" {
+		if _, err := ParseWebhookTemplate(webhook.Template); err != nil {
+			return errors.New("invalid template: " + err.Error())
+		}
+	}
+	if webhook.Filter != "" {
+		if _, err := ParseTrackFilter(webhook.Filter); err != nil {
+			return errors.New("invalid filter: " + err.Error())
+		}
+	}
+	if webhook.Schedule != "" || webhook.Timezone != "" {
+		if _, err := ParseDigestSchedule(webhook.Schedule, webhook.Timezone); err != nil {
+			return errors.New("invalid schedule: " + err.Error())
+		}
+	}
+	if webhook.MaxDelay < 0 {
+		return errors.New("maxDelay can not be negative")
+	}
+	if webhook.Schedule != "" && webhook.MaxDelay > 0 {
+		return errors.New("a webhook can not have both a schedule and a maxDelay")
+	}
+	if (webhook.Schedule != "" || webhook.MaxDelay > 0) && !containsString(webhookEvents(webhook), EventTrackCreated) {
+		return errors.New("a schedule or a maxDelay needs the " + EventTrackCreated + " event")
+	}
+	return nil
+}
+
+// validWebhookURL returns true for http and https urls
+func validWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validEvents returns an error if there are no events, or one of them is unknown
+func validEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("events must have at least one of " + strings.Join(EventTypes, ", "))
+	}
+	for _, event := range events {
+		if !containsString(EventTypes, event) {
+			return fmt.Errorf("unknown event %q, must be one of %s", event, strings.Join(EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// webhookEvents returns the events of the webhook. webhooks from before webhooks had events get new tracks,
+// and the infringements if they asked for them
+func webhookEvents(webhook WebhookInfo) []string {
+	if webhook.Events != nil {
+		return webhook.Events
+	}
+	events := []string{EventTrackCreated}
+	if webhook.Airspace {
+		events = append(events, EventAirspaceInfringement)
+	}
+	return events
+}
+
+// HandlerGetWebhookHookByID is the handler for "GET /api/webhooks/<webhook_id>" and "GET /api/webhook/new_track/<webhook_id>"
+// it responds with the webhoo url, the minimum trigger value and the events
 func (whMgr *WebHookMgr) HandlerGetWebhookHookByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 	parts := strings.Split(r.URL.Path, "/")
@@ -58,10 +206,10 @@ func (whMgr *WebHookMgr) HandlerGetWebhookHookByID(w http.ResponseWriter, r *htt
 		http.Error(w, "the id does not exist", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(webhookInfo)
+	json.NewEncoder(w).Encode(newWebhookView(webhookInfo))
 }
 
-// HandlerDeleteWebhookHookByID is the handler for "DELETE /api/webhook/new_track/<webhook_id>"
+// HandlerDeleteWebhookHookByID is the handler for "DELETE /api/webhooks/<webhook_id>" and "DELETE /api/webhook/new_track/<webhook_id>"
 // it deletes the webhook and reponds with the webhook's info
 func (whMgr *WebHookMgr) HandlerDeleteWebhookHookByID(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
@@ -75,56 +223,191 @@ func (whMgr *WebHookMgr) HandlerDeleteWebhookHookByID(w http.ResponseWriter, r *
 	if err != nil {
 		http.Error(w, "could not delete webhook", http.StatusInternalServerError)
 	}
-	json.NewEncoder(w).Encode(webhookInfo)
+	json.NewEncoder(w).Encode(newWebhookView(webhookInfo))
 }
 
-// InvokeNewWebHooks should be called when a new track is added. it will invoke the webohooks that should be invoked
-func (whMgr *WebHookMgr) InvokeNewWebHooks() {
+// InvokeNewWebHooks should be called when a new track is added. it will invoke the webohooks that should be invoked.
+// the track only counts for the webhooks whose filter it matches, and the webhooks with a filter only get the
+// matching tracks. the requests are added to the outbox and sent by the dispatcher
+func (whMgr *WebHookMgr) InvokeNewWebHooks(track TrackInfo, points []TrackPoint) {
 	// get the webhooks that should be invoked
-	webhooks, err := whMgr.DB.GetAllInvokeWebhooks()
+	webhooks, err := whMgr.DB.GetAllInvokeWebhooks(whMgr.unmatchedWebhooks(track, points))
 	if err != nil {
 		return
 	}
 	// loop through the webhooks and invoke them
 	for _, v := range webhooks {
-		startTime := time.Now()
+		whMgr.sendNewTracks(v, 0)
+	}
+}
 
-		tickerResp, err := whMgr.Ticker.GetTickerByTimeStamp(v.LatestTimestamp)
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
+// sendNewTracks sends the tracks after the LatestTimestamp of the webhook, at most limit of them or a page of the
+// ticker if limit is 0. with a limit the tracks after it are left for the next time. the tracks are claimed before
+// they are added to the outbox, so they are not sent twice when the counter and the scheduler fire at the same time
+func (whMgr *WebHookMgr) sendNewTracks(v WebhookInfo, limit int) {
+	startTime := time.Now()
+
+	tickerResp, err := whMgr.Ticker.GetTickerFiltered(v.LatestTimestamp, whMgr.trackMatcher(v), limit)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(tickerResp.TrackIDs) <= 0 {
+		return
+	}
+	tracks, err := whMgr.tickerTracks(tickerResp)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	previous := v.LatestTimestamp
+	v.LatestTimestamp = sentUntil(tickerResp)
+	event := WebhookEvent{Type: EventTrackCreated, Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Ticker: &tickerResp, Tracks: tracks, Processing: float64(time.Since(startTime)) / float64(time.Millisecond)}
+	payload, err := renderWebhookPayload(v, event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// the counter is reset and the tracks are claimed, then the dispatcher posts the request
+	if !whMgr.DB.ClaimWebhookTracks(v, previous) {
+		return
+	}
+	if err := whMgr.Dispatcher.Enqueue(v, payload); err != nil {
+		fmt.Println(err)
+	}
+}
 
-		var trackIdsString string
-		nOfNewTrack := len(tickerResp.TrackIDs)
+// sentUntil returns the timestamp a webhook has got the tracks of the page until. when the page is full it is the
+// last track of the page, so the tracks after it are sent the next time, and the latest track otherwise
+func sentUntil(tickerResp Response) int64 {
+	if tickerResp.TStop != 0 {
+		return tickerResp.TStop
+	}
+	return tickerResp.TLatest
+}
 
-		if nOfNewTrack <= 0 {
+// unmatchedWebhooks returns the ids of the new track webhooks whose filter the track does not match
+func (whMgr *WebHookMgr) unmatchedWebhooks(track TrackInfo, points []TrackPoint) []objectid.ObjectID {
+	webhooks, err := whMgr.DB.GetWebhooksByEvent(EventTrackCreated)
+	if err != nil {
+		return nil
+	}
+	var takeoff *TrackPoint
+	if len(points) > 0 {
+		takeoff = &points[0]
+	}
+	var ids []objectid.ObjectID
+	for _, v := range webhooks {
+		if v.Filter == "" {
 			continue
 		}
+		if filter, err := ParseTrackFilter(v.Filter); err != nil || !filter.Match(newTrackSummary(track), takeoff) {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
 
-		trackIdsString = tickerResp.TrackIDs[0].Hex()
-		for i := 1; i < nOfNewTrack; i++ {
-			trackIdsString += ", " + tickerResp.TrackIDs[i].Hex()
+// trackMatcher returns a function that returns true for the tracks that match the filter of the webhook, nil if
+// the webhook has no filter
+func (whMgr *WebHookMgr) trackMatcher(webhook WebhookInfo) func(track TrackInfo) bool {
+	if webhook.Filter == "" {
+		return nil
+	}
+	filter, err := ParseTrackFilter(webhook.Filter)
+	if err != nil { // checked when the webhook was registered
+		return func(track TrackInfo) bool { return false }
+	}
+	return func(track TrackInfo) bool { return whMgr.matchFilter(filter, newTrackSummary(track)) }
+}
+
+// matchFilter matches the track with the filter, and gets the takeoff of the track if the filter needs it
+func (whMgr *WebHookMgr) matchFilter(filter *TrackFilter, track TrackSummary) bool {
+	var takeoff *TrackPoint
+	if filter.NeedsTakeoff() {
+		points, err := whMgr.DB.GetPointsByTrackIDs([]string{track.ID})
+		if err == nil && len(points) > 0 && len(points[0].Points) > 0 {
+			takeoff = &points[0].Points[0]
 		}
+	}
+	return filter.Match(track, takeoff)
+}
 
-		areOrIsString := "is: "
-		if nOfNewTrack > 1 {
-			areOrIsString = "are: "
+// tickerTracks returns the summaries of the tracks of the ticker response, in the same order
+func (whMgr *WebHookMgr) tickerTracks(tickerResp Response) ([]TrackSummary, error) {
+	var ids []string
+	for _, id := range tickerResp.TrackIDs {
+		ids = append(ids, id.Hex())
+	}
+	tracks, err := whMgr.DB.GetTracksByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]TrackInfo{}
+	for _, track := range tracks {
+		byID[track.ID.Hex()] = track
+	}
+	summaries := []TrackSummary{}
+	for _, id := range ids {
+		if track, found := byID[id]; found {
+			summaries = append(summaries, newTrackSummary(track))
 		}
+	}
+	return summaries, nil
+}
 
-		v.LatestTimestamp = tickerResp.TLatest
-		reponseString := "latest timestamp: " + strconv.FormatInt(tickerResp.TLatest, 10) +
-			", " + strconv.Itoa(len(tickerResp.TrackIDs)) + " new tracks " + areOrIsString +
-			trackIdsString + ". (processing: " + strconv.FormatFloat(float64(time.Since(startTime))/float64(time.Millisecond), 'f', 2, 64) + "ms)"
+// InvokeAirspaceWebHooks alerts the airspace webhooks about the infringements of a new track
+func (whMgr *WebHookMgr) InvokeAirspaceWebHooks(track TrackInfo, infringements []Infringement) {
+	whMgr.InvokeEvent(WebhookEvent{Type: EventAirspaceInfringement, Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Tracks: []TrackSummary{newTrackSummary(track)}, Infringements: infringements})
+}
 
-		var jsonStr = []byte(`{"content":"` + reponseString + `"}`)
-		// post the request
-		_, postErr := http.Post(v.WebhookURL, "application/json", bytes.NewBuffer(jsonStr))
-		if postErr != nil {
-			fmt.Println(postErr)
+// InvokeEvent sends the event to every webhook that is subscribed to it. unlike track.created the events are sent
+// right away, without a trigger value. a webhook with a filter only gets the matching tracks of the event, and
+// nothing if none of them match
+func (whMgr *WebHookMgr) InvokeEvent(event WebhookEvent) {
+	eventTracks := event.Tracks
+	webhooks, err := whMgr.DB.GetWebhooksByEvent(event.Type)
+	if err != nil {
+		return
+	}
+	if event.Type == EventAirspaceInfringement {
+		legacy, err := whMgr.DB.GetAirspaceWebhooks()
+		if err != nil {
+			return
+		}
+		for _, v := range legacy {
+			if v.Events == nil {
+				webhooks = append(webhooks, v)
+			}
+		}
+	}
+	for _, v := range webhooks {
+		event := event
+		if v.Filter != "" && len(event.Tracks) > 0 {
+			filter, err := ParseTrackFilter(v.Filter)
+			if err != nil {
+				continue
+			}
+			event.Tracks = nil
+			for _, track := range eventTracks {
+				if whMgr.matchFilter(filter, track) {
+					event.Tracks = append(event.Tracks, track)
+				}
+			}
+			if len(event.Tracks) == 0 {
+				continue
+			}
+		}
+		payload, err := renderWebhookPayload(v, event)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if err := whMgr.Dispatcher.Enqueue(v, payload); err != nil {
+			fmt.Println(err)
 		}
-		whMgr.Ticker.DB.ResetWebhookCounter(v)
-
 	}
-
 }
@@ -3,7 +3,9 @@ package paragliding
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_HandlerGetWebhookHookByID(t *testing.T) {
@@ -26,3 +28,42 @@ func Test_HandlerGetWebhookHookByID(t *testing.T) {
 		t.Error("Bad status response: expected %i got %i", http.StatusOK, res.Code)
 	}
 }
+
+func Test_HandlerNewTrackWebHookValidation(t *testing.T) {
+	whMgr := WebHookMgr{Dispatcher: NewDispatcher(nil, 1, time.Second)}
+	for _, body := range []string{`{"webhookURL": "ftp://example.com/hook", "minTriggerValue": "1"}`,
+		`{"webhookURL": "https://example.com/hook", "minTriggerValue": "0"}`,
+		`{"webhookURL": "https://example.com/hook", "minTriggerValue": "-3"}`} {
+		req, err := http.NewRequest("POST", "/paragliding/api/webhook/new_track/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := httptest.NewRecorder()
+		http.HandlerFunc(whMgr.HandlerNewTrackWebHook).ServeHTTP(res, req)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("expected %d for %s, got %d", http.StatusBadRequest, body, res.Code)
+		}
+	}
+}
+
+func Test_WebhookEvents(t *testing.T) {
+	if err := validEvents([]string{EventTrackCreated, EventRecordBroken}); err != nil {
+		t.Error(err)
+	}
+	if err := validEvents(nil); err == nil {
+		t.Error("expected an error without events")
+	}
+	if err := validEvents([]string{"new_track"}); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+
+	// webhooks from before the events get new tracks, and infringements if they asked for them
+	events := webhookEvents(WebhookInfo{Airspace: true})
+	if len(events) != 2 || events[0] != EventTrackCreated || events[1] != EventAirspaceInfringement {
+		t.Errorf("wrong events %v", events)
+	}
+	events = webhookEvents(WebhookInfo{Airspace: true, Events: []string{EventTracksPurged}})
+	if len(events) != 1 || events[0] != EventTracksPurged {
+		t.Errorf("wrong events %v", events)
+	}
+}
@@ -0,0 +1,265 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// WebhookView is a webhook in the responses of /api/webhooks, with its id
+type WebhookView struct {
+	ID string `json:"id"`
+	WebhookInfo
+}
+
+// newWebhookView returns the view of the webhook, with the events of the webhooks from before the events
+func newWebhookView(webhook WebhookInfo) WebhookView {
+	webhook.Events = webhookEvents(webhook)
+	return WebhookView{ID: webhook.ID.Hex(), WebhookInfo: webhook}
+}
+
+// WebhookList is the response for GET /api/webhooks
+type WebhookList struct {
+	Page     int           `json:"page"`
+	Limit    int           `json:"limit"`
+	Total    int64         `json:"total"`
+	Webhooks []WebhookView `json:"webhooks"`
+}
+
+// HandlerGetWebhooks is the handler for GET /admin/api/webhooks?page=1&limit=20. it responds with a page of the
+// webhooks, the oldest first. the id of a webhook is the key to change it and the url of discord and slack webhooks
+// is a secret, so it is only for the admin and the path of the urls is masked
+func (whMgr *WebHookMgr) HandlerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	page, limit, ok := parsePaging(w, r, 20)
+	if !ok {
+		return
+	}
+	webhooks, total, err := whMgr.DB.GetWebhooks(int64((page-1)*limit), int64(limit))
+	if err != nil {
+		http.Error(w, "could not get the webhooks", http.StatusInternalServerError)
+		return
+	}
+	views := []WebhookView{}
+	for _, webhook := range webhooks {
+		view := newWebhookView(webhook)
+		view.WebhookURL = maskWebhookURL(view.WebhookURL)
+		views = append(views, view)
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(WebhookList{Page: page, Limit: limit, Total: total, Webhooks: views})
+}
+
+// maskWebhookURL returns the scheme and the host of the url, with the rest replaced by ***
+func maskWebhookURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "***"
+	}
+	return u.Scheme + "://" + u.Host + "/***"
+}
+
+// WebhookPatch is the body of PATCH /api/webhooks/<id>, the fields that are left out are not changed
+type WebhookPatch struct {
+	WebhookURL      *string   `json:"webhookURL"`
+	MinTriggerValue *int64    `json:"minTriggerValue"`
+	Events          *[]string `json:"events"`
+	Format          *string   `json:"format"`
+	Template        *string   `json:"template"`
+	Filter          *string   `json:"filter"`
+	Schedule        *string   `json:"schedule"`
+	Timezone        *string   `json:"timezone"`
+	MaxDelay        *int64    `json:"maxDelay"`
+}
+
+// HandlerPatchWebhook is the handler for PATCH /api/webhooks/<id>. it changes the settings in the body and
+// responds with the webhook. the tracks that were counted are kept when minTriggerValue changes, so a webhook that
+// has counted 3 tracks and gets a minTriggerValue of 3 is sent right away. the counter starts over when the events,
+// the filter or the schedule change, because the counted tracks may not be the ones the webhook gets any more
+func (whMgr *WebHookMgr) HandlerPatchWebhook(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	webhook, found := whMgr.DB.GetWebhookByID(parts[len(parts)-1]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	var patch WebhookPatch
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err == io.EOF {
+		http.Error(w, "PATCH body is empty", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "PATCH body is not valid json", http.StatusBadRequest)
+		return
+	}
+	updated, counterChange, err := applyWebhookPatch(webhook, patch, time.Now())
+	if err == nil && patch.WebhookURL != nil {
+		err = whMgr.Dispatcher.checkURL(updated.WebhookURL)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := whMgr.DB.UpdateWebhook(updated, counterChange); err != nil {
+		http.Error(w, "could not update the webhook", http.StatusInternalServerError)
+		return
+	}
+	if updated, found = whMgr.DB.GetWebhookByID(updated.ID.Hex()); !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	// the tracks that were counted may be enough for the new trigger value
+	if updated.Counter <= 0 && !updated.Paused && updated.Schedule == "" &&
+		containsString(webhookEvents(updated), EventTrackCreated) {
+		whMgr.sendNewTracks(updated, 0)
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(newWebhookView(updated))
+}
+
+// applyWebhookPatch returns the webhook with the patch applied and how much its counter changes
+func applyWebhookPatch(webhook WebhookInfo, patch WebhookPatch, now time.Time) (WebhookInfo, int64, error) {
+	updated := webhook
+	updated.Events = webhookEvents(webhook)
+	if patch.WebhookURL != nil {
+		if !validWebhookURL(*patch.WebhookURL) {
+			return webhook, 0, errors.New("webhookURL must be a http or https url")
+		}
+		updated.WebhookURL = *patch.WebhookURL
+	}
+	if patch.MinTriggerValue != nil {
+		if *patch.MinTriggerValue < 1 {
+			return webhook, 0, errors.New("minTriggerValue must be at least 1")
+		}
+		updated.MinTriggerValue = *patch.MinTriggerValue
+	}
+	if patch.Events != nil {
+		if err := validEvents(*patch.Events); err != nil {
+			return webhook, 0, err
+		}
+		updated.Events = *patch.Events
+	}
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{{patch.Format, &updated.Format}, {patch.Template, &updated.Template}, {patch.Filter, &updated.Filter},
+		{patch.Schedule, &updated.Schedule}, {patch.Timezone, &updated.Timezone}} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	if patch.MaxDelay != nil {
+		updated.MaxDelay = *patch.MaxDelay
+	}
+	if err := validateWebhook(updated); err != nil {
+		return webhook, 0, err
+	}
+
+	scheduleChanged := updated.Schedule != webhook.Schedule || updated.Timezone != webhook.Timezone
+	if scheduleChanged {
+		updated.NextDigest = nextDigest(updated, now)
+	}
+	if scheduleChanged || updated.Filter != webhook.Filter ||
+		containsString(updated.Events, EventTrackCreated) != containsString(webhookEvents(webhook), EventTrackCreated) {
+		return updated, updated.MinTriggerValue - webhook.Counter, nil
+	}
+	return updated, updated.MinTriggerValue - webhook.MinTriggerValue, nil
+}
+
+// HandlerPauseWebhook is the handler for POST /api/webhooks/<id>/pause. nothing is sent to a paused webhook and
+// the new tracks do not count, the deliveries that are already in the outbox are held until it is resumed
+func (whMgr *WebHookMgr) HandlerPauseWebhook(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	webhook, found := whMgr.DB.GetWebhookByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	if err := whMgr.DB.PauseWebhook(webhook.ID); err != nil {
+		http.Error(w, "could not pause the webhook", http.StatusInternalServerError)
+		return
+	}
+	webhook.Paused = true
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(newWebhookView(webhook))
+}
+
+// HandlerResumeWebhook is the handler for POST /api/webhooks/<id>/resume. the webhook starts over from now, with
+// a full counter and the next digest on its schedule, so the tracks from while it was paused are not sent. the
+// held deliveries are sent right away
+func (whMgr *WebHookMgr) HandlerResumeWebhook(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	webhook, found := whMgr.DB.GetWebhookByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	if webhook.Paused {
+		now := time.Now()
+		webhook = resumedWebhook(webhook, now)
+		if err := whMgr.DB.ResumeWebhook(webhook); err != nil {
+			http.Error(w, "could not resume the webhook", http.StatusInternalServerError)
+			return
+		}
+		if err := whMgr.DB.RescheduleDeliveries(webhook.ID.Hex(), now.UnixNano()/int64(time.Millisecond)); err == nil {
+			whMgr.Dispatcher.wakeUp()
+		}
+	}
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(newWebhookView(webhook))
+}
+
+// resumedWebhook returns the webhook as it is when it is resumed at the time
+func resumedWebhook(webhook WebhookInfo, now time.Time) WebhookInfo {
+	webhook.Paused = false
+	webhook.Counter = webhook.MinTriggerValue
+	webhook.LatestTimestamp = now.UnixNano() / int64(time.Millisecond)
+	webhook.NextDigest = nextDigest(webhook, now)
+	return webhook
+}
+
+// WebhookTestResult is the response for POST /api/webhooks/<id>/test
+type WebhookTestResult struct {
+	Delivered bool   `json:"delivered"`
+	Status    int    `json:"status"` // the http status of the response, 0 if there was none
+	Latency   int64  `json:"latency"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HandlerTestWebhook is the handler for POST /api/webhooks/<id>/test. it sends a webhook.test event with an
+// example track right away, signed and logged like the other deliveries but without retries, and responds with the
+// result. it also works while the webhook is paused, to check that the receiver is back. the counter and the tracks
+// of the webhook are not changed
+func (whMgr *WebHookMgr) HandlerTestWebhook(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	webhook, found := whMgr.DB.GetWebhookByID(parts[len(parts)-2]) // guaranteed to be valid cause of regex in server.go
+	if !found {
+		http.Error(w, "the id does not exist", http.StatusNotFound)
+		return
+	}
+	event := exampleEvent()
+	event.Type = EventWebhookTest
+	payload, err := renderWebhookPayload(webhook, event)
+	if err != nil {
+		http.Error(w, "could not render the payload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	start := time.Now()
+	delivery := DeliveryInfo{ID: objectid.New(), WebhookID: webhook.ID.Hex(), URL: webhook.WebhookURL,
+		Payload: string(payload), CreatedAt: start.UnixNano() / int64(time.Millisecond), Attempts: 1}
+	status, _, err := whMgr.Dispatcher.send(delivery, signingSecrets(webhook, start))
+	latency := time.Since(start)
+	delivery.LastStatus = status
+	if err != nil {
+		delivery.LastError = err.Error()
+	}
+	whMgr.DB.Insert("delivery_log", newDeliveryAttempt(delivery, start, latency))
+	w.Header().Add("content-type", "application/json")
+	json.NewEncoder(w).Encode(WebhookTestResult{Delivered: err == nil, Status: status,
+		Latency: int64(latency / time.Millisecond), Error: delivery.LastError})
+}
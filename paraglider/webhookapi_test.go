@@ -0,0 +1,83 @@
+package paragliding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+func Test_ApplyWebhookPatch(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	// 3 of 5 tracks are counted
+	webhook := WebhookInfo{ID: objectid.New(), WebhookURL: "https://example.com/hook", MinTriggerValue: 5, Counter: 2}
+	three, url := int64(3), "https://example.com/other"
+
+	updated, change, err := applyWebhookPatch(webhook, WebhookPatch{MinTriggerValue: &three, WebhookURL: &url}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.WebhookURL != url || updated.MinTriggerValue != 3 || webhook.Counter+change != 0 {
+		t.Errorf("expected the counted tracks to be kept, got %+v %d", updated, change)
+	}
+	if len(updated.Events) != 1 || updated.Events[0] != EventTrackCreated {
+		t.Errorf("expected the events of an old webhook, got %v", updated.Events)
+	}
+
+	// a new filter starts the counter over
+	filter := "distance > 50"
+	updated, change, err = applyWebhookPatch(webhook, WebhookPatch{Filter: &filter}, now)
+	if err != nil || updated.Filter != filter || webhook.Counter+change != 5 {
+		t.Errorf("expected a full counter, got %d %v", webhook.Counter+change, err)
+	}
+
+	schedule := "hourly"
+	updated, _, err = applyWebhookPatch(webhook, WebhookPatch{Schedule: &schedule}, now)
+	if err != nil || updated.NextDigest != time.Date(2026, 7, 1, 13, 0, 0, 0, time.UTC).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("expected the next digest, got %d %v", updated.NextDigest, err)
+	}
+
+	zero, bad, events := int64(0), "ftp://example.com", []string{"new_track"}
+	maxDelay, airspace := int64(60), []string{EventAirspaceInfringement}
+	for _, patch := range []WebhookPatch{{MinTriggerValue: &zero}, {WebhookURL: &bad}, {Events: &events},
+		{Filter: &bad}, {Schedule: &schedule, MaxDelay: &maxDelay}, {Schedule: &schedule, Events: &airspace},
+		{MaxDelay: &maxDelay, Events: &airspace}} {
+		if _, _, err := applyWebhookPatch(webhook, patch, now); err == nil {
+			t.Errorf("expected an error for %+v", patch)
+		}
+	}
+}
+
+func Test_ResumedWebhook(t *testing.T) {
+	now := time.Date(2026, 7, 1, 12, 30, 0, 0, time.UTC)
+	webhook := resumedWebhook(WebhookInfo{MinTriggerValue: 4, Counter: -2, LatestTimestamp: 1, Paused: true,
+		Schedule: "daily 20:00"}, now)
+	if webhook.Paused || webhook.Counter != 4 || webhook.LatestTimestamp != now.UnixNano()/int64(time.Millisecond) ||
+		webhook.NextDigest != time.Date(2026, 7, 1, 20, 0, 0, 0, time.UTC).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("the webhook did not start over, got %+v", webhook)
+	}
+}
+
+func Test_WebhookView(t *testing.T) {
+	webhook := WebhookInfo{ID: objectid.New(), WebhookURL: "https://example.com/hook", Secret: "secret", Paused: true}
+	body, err := json.Marshal(newWebhookView(webhook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(body)
+	if !strings.Contains(s, `"id":"`+webhook.ID.Hex()+`"`) || !strings.Contains(s, `"paused":true`) ||
+		!strings.Contains(s, `"events":["track.created"]`) || strings.Contains(s, "secret") {
+		t.Errorf("wrong view %s", s)
+	}
+}
+
+func Test_MaskWebhookURL(t *testing.T) {
+	if masked := maskWebhookURL("https://discord.com/api/webhooks/123/token"); masked != "https://discord.com/***" {
+		t.Errorf("wrong masked url %q", masked)
+	}
+	if masked := maskWebhookURL("not a url"); masked != "***" {
+		t.Errorf("wrong masked url %q", masked)
+	}
+}
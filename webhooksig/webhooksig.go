@@ -0,0 +1,104 @@
+// Package webhooksig signs and verifies the webhook requests of the paragliding api.
+//
+// every request has the header
+//
+//	X-Paragliding-Signature: t=1530000000,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// where t is the unix time the request was sent and v1 is the hex encoded HMAC-SHA256 of "<t>.<body>" with the
+// secret of the webhook. while a secret is being rotated the header has a v1 for both the old and the new secret.
+// a consumer verifies a request with
+//
+//	body, err := webhooksig.VerifyRequest(r, secret, webhooksig.DefaultTolerance)
+package webhooksig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderName is the header with the signature
+const HeaderName = "X-Paragliding-Signature"
+
+// DefaultTolerance is how old a request can be before it is rejected as a replay
+const DefaultTolerance = 5 * time.Minute
+
+// the errors of a failed verification
+var (
+	ErrMissingHeader = errors.New("the signature header is missing")
+	ErrInvalidHeader = errors.New("the signature header is invalid")
+	ErrTooOld        = errors.New("the timestamp of the signature is outside the tolerance")
+	ErrNoMatch       = errors.New("no signature matches the secret")
+)
+
+// Sign returns the hex encoded signature of the body sent at the timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Header returns the value of the signature header with a signature for every secret
+func Header(secrets []string, timestamp int64, body []byte) string {
+	parts := []string{"t=" + strconv.FormatInt(timestamp, 10)}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+Sign(secret, timestamp, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify checks that the header has a signature of the body with the secret, and that it was signed within
+// the tolerance of now
+func Verify(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrMissingHeader
+	}
+	var timestamp int64 = -1
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidHeader
+		}
+		switch kv[0] {
+		case "t":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return ErrInvalidHeader
+			}
+			timestamp = t
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if timestamp < 0 || len(signatures) == 0 {
+		return ErrInvalidHeader
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrTooOld
+	}
+	expected := []byte(Sign(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+	return ErrNoMatch
+}
+
+// VerifyRequest reads the body of the request and verifies its signature. it returns the body
+func VerifyRequest(r *http.Request, secret string, tolerance time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return body, Verify(r.Header.Get(HeaderName), body, secret, tolerance, time.Now())
+}
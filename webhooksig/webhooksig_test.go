@@ -0,0 +1,47 @@
+package webhooksig
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Verify(t *testing.T) {
+	body := []byte(`{"content":"hi"}`)
+	now := time.Unix(1530000000, 0)
+	header := Header([]string{"new secret", "old secret"}, now.Unix(), body)
+	tests := []struct {
+		header string
+		body   []byte
+		secret string
+		now    time.Time
+		err    error
+	}{
+		{header, body, "new secret", now, nil},
+		{header, body, "old secret", now.Add(4 * time.Minute), nil},
+		{header, body, "other secret", now, ErrNoMatch},
+		{header, []byte(`{"content":"bye"}`), "new secret", now, ErrNoMatch},
+		{header, body, "new secret", now.Add(10 * time.Minute), ErrTooOld},
+		{"", body, "new secret", now, ErrMissingHeader},
+		{"v1=abc", body, "new secret", now, ErrInvalidHeader},
+		{"t=abc,v1=abc", body, "new secret", now, ErrInvalidHeader},
+	}
+	for _, test := range tests {
+		if err := Verify(test.header, test.body, test.secret, DefaultTolerance, test.now); err != test.err {
+			t.Errorf("expected %v for %q with %q, got %v", test.err, test.header, test.secret, err)
+		}
+	}
+}
+
+func Test_VerifyRequest(t *testing.T) {
+	body := []byte(`{"content":"hi"}`)
+	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(body))
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderName, "t="+strconv.FormatInt(timestamp, 10)+",v1="+Sign("secret", timestamp, body))
+	read, err := VerifyRequest(req, "secret", DefaultTolerance)
+	if err != nil || !bytes.Equal(read, body) {
+		t.Errorf("the request should verify: %v", err)
+	}
+}